@@ -0,0 +1,62 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+)
+
+type QueryAuditRequest struct {
+	Key       string    `form:"key"`
+	Subject   string    `form:"subject"`
+	Operation string    `form:"operation"` // put or delete
+	Since     time.Time `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+	Until     time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit     int       `form:"limit"`
+}
+
+func (q *QueryAuditRequest) Validate() error {
+	if q.Limit < 0 || q.Limit > lib.MAX_SEARCH_LIMIT {
+		return customerrors.ErrInvalidPagination
+	}
+	if q.Limit == 0 {
+		q.Limit = lib.DEFAULT_SEARCH_LIMIT
+	}
+	return nil
+}
+
+type AuditRecord struct {
+	Time         time.Time `json:"time"`
+	RequestID    string    `json:"request_id,omitempty"`
+	Subject      string    `json:"subject,omitempty"`
+	AuthMethod   string    `json:"auth_method,omitempty"`
+	ChangeID     string    `json:"change_id,omitempty"`    // approved change applied, the subject being its approver
+	RequestedBy  string    `json:"requested_by,omitempty"` // subject that requested the approved change
+	Operation    string    `json:"operation"`
+	Key          string    `json:"key"`
+	OldValueHash string    `json:"old_value_hash,omitempty"`
+	NewValueHash string    `json:"new_value_hash,omitempty"`
+	PrevRevision int64     `json:"prev_revision,omitempty"`
+	Revision     int64     `json:"revision,omitempty"`
+	PrevHash     string    `json:"prev_hash,omitempty"`
+	Hash         string    `json:"hash,omitempty"`
+}
+
+type QueryAuditResponse struct {
+	Records []AuditRecord `json:"records"`
+}
+
+type VerifyAuditResponse struct {
+	Valid   bool       `json:"valid"`
+	Records int        `json:"records"` // number of records checked
+	Error   string     `json:"error,omitempty"`
+	Head    *AuditHead `json:"head,omitempty"` // signed head of the log, to be kept elsewhere
+}
+
+// AuditHead is the number of records and the hash of the last one, signed with the audit secret
+type AuditHead struct {
+	Records   int    `json:"records"`
+	Hash      string `json:"hash,omitempty"`
+	Signature string `json:"signature"`
+}
@@ -0,0 +1,22 @@
+package dto
+
+// LoginRequest holds the query parameters of GET /auth/login
+type LoginRequest struct {
+	Redirect string `form:"redirect"` // path to return to after the login, defaults to /
+}
+
+// CallbackRequest holds the query parameters the OIDC provider redirects to GET /auth/callback with
+type CallbackRequest struct {
+	Code             string `form:"code"`
+	State            string `form:"state"`
+	Error            string `form:"error"`
+	ErrorDescription string `form:"error_description"`
+}
+
+// IdentityResponse describes the authenticated caller
+type IdentityResponse struct {
+	Subject string   `json:"subject"`
+	Email   string   `json:"email,omitempty"`
+	Groups  []string `json:"groups"`
+	Method  string   `json:"method"`
+}
@@ -0,0 +1,31 @@
+package dto
+
+import (
+	"time"
+)
+
+type ListChangesRequest struct {
+	Status string `form:"status"` // pending, applied, rejected, stale or expired, every change if empty
+}
+
+type Change struct {
+	ID           string     `json:"id"`
+	Operation    string     `json:"operation"` // put or delete
+	Key          string     `json:"key"`
+	Value        string     `json:"value,omitempty"` // new value of puts
+	OldValue     string     `json:"old_value,omitempty"`
+	BaseRevision int64      `json:"base_revision"` // mod revision the change applies to, 0 if the key did not exist
+	Diff         string     `json:"diff"`
+	Status       string     `json:"status"`
+	RequestedBy  string     `json:"requested_by"`
+	RequestID    string     `json:"request_id,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	DecidedBy    string     `json:"decided_by,omitempty"`
+	DecidedAt    *time.Time `json:"decided_at,omitempty"`
+	Revision     int64      `json:"revision,omitempty"` // revision the change was applied at, unknown for deletes
+}
+
+type ListChangesResponse struct {
+	Changes []Change `json:"changes"`
+}
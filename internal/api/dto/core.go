@@ -1,6 +1,11 @@
 package dto
 
-import "github.com/etcdfinder/etcdfinder/internal/customerrors"
+import (
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+)
 
 type GetKeyRequest struct {
 	Key string `json:"key"`
@@ -20,9 +25,17 @@ type GetKeyResponse struct {
 
 type SearchKeysRequest struct {
 	SearchStr string `json:"search_str"`
+	Offset    int64  `json:"offset,omitempty"`
+	Limit     int64  `json:"limit,omitempty"`
 }
 
 func (s *SearchKeysRequest) Validate() error {
+	if s.Offset < 0 || s.Limit < 0 || s.Limit > lib.MAX_SEARCH_LIMIT {
+		return customerrors.ErrInvalidPagination
+	}
+	if s.Limit == 0 {
+		s.Limit = lib.DEFAULT_SEARCH_LIMIT
+	}
 	return nil
 }
 
@@ -30,6 +43,27 @@ type SearchKeysResponse struct {
 	Keys []string `json:"keys"`
 }
 
+// ListKeysRequest pages through every indexed key, in no particular order
+type ListKeysRequest struct {
+	Cursor int64 `json:"cursor,omitempty"` // next_cursor of the previous page, 0 for the first page
+	Limit  int64 `json:"limit,omitempty"`
+}
+
+func (l *ListKeysRequest) Validate() error {
+	if l.Cursor < 0 || l.Limit < 0 || l.Limit > lib.MAX_SEARCH_LIMIT {
+		return customerrors.ErrInvalidPagination
+	}
+	if l.Limit == 0 {
+		l.Limit = lib.DEFAULT_SEARCH_LIMIT
+	}
+	return nil
+}
+
+type ListKeysResponse struct {
+	Keys       []string `json:"keys"`
+	NextCursor int64    `json:"next_cursor,omitempty"` // 0 once every key was listed
+}
+
 type PutKeyRequest struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
@@ -66,5 +100,40 @@ type DeleteKeyResponse struct {
 }
 
 type GetIngestionDelayResponse struct {
-	IngestionDelay int `json:"ingestion_delay"`
+	IngestionDelay  int64      `json:"ingestion_delay"` // apply latency of the last event, in milliseconds
+	AppliedRevision int64      `json:"applied_revision"`
+	HeadRevision    int64      `json:"head_revision"`
+	RevisionLag     int64      `json:"revision_lag"`
+	P50Latency      int64      `json:"p50_latency"` // in milliseconds
+	P99Latency      int64      `json:"p99_latency"` // in milliseconds
+	LastAppliedAt   *time.Time `json:"last_applied_at,omitempty"`
+	SearchReady     bool       `json:"search_ready"` // whether the initial sync is fully indexed
+	Degraded        bool       `json:"degraded"`     // whether the watch or the etcd connection checks are failing
+	DegradedSince   *time.Time `json:"degraded_since,omitempty"`
+	DegradedReason  string     `json:"degraded_reason,omitempty"`
+}
+
+type GetReconciliationResponse struct {
+	Enabled      bool       `json:"enabled"`
+	Interval     int64      `json:"interval"` // in seconds
+	Runs         int64      `json:"runs"`
+	LastRunAt    *time.Time `json:"last_run_at,omitempty"`
+	LastDuration int64      `json:"last_duration"`      // in milliseconds
+	Revision     int64      `json:"revision,omitempty"` // revision etcd was read at by the last run
+	Added        int        `json:"added"`
+	Updated      int        `json:"updated"`
+	Deleted      int        `json:"deleted"`
+	Skipped      int        `json:"skipped"` // keys the watch changed during the last run, left to it
+	Error        string     `json:"error,omitempty"`
+}
+
+type WatchKeysRequest struct {
+	Prefix string `form:"prefix"`
+}
+
+type WatchEvent struct {
+	Type     string `json:"type"`
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	Revision int64  `json:"revision,omitempty"` // etcd revision of the change
 }
@@ -0,0 +1,15 @@
+package dto
+
+type LivenessResponse struct {
+	Status string `json:"status"`
+}
+
+type ReadinessResponse struct {
+	Ready      bool                       `json:"ready"`
+	Components map[string]ComponentStatus `json:"components"` // by component: etcd, kvstore, initial_sync and watch
+}
+
+type ComponentStatus struct {
+	Ready bool   `json:"ready"`
+	Error string `json:"error,omitempty"` // reason the component is not ready
+}
@@ -0,0 +1,13 @@
+package dto
+
+// KeyResponse is the JSON representation of a key of the /v2/keys resource
+type KeyResponse struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	ModRevision int64  `json:"mod_revision"`
+}
+
+// PutKeyValueRequest is the JSON body accepted by PUT /v2/keys, other content types are stored raw
+type PutKeyValueRequest struct {
+	Value string `json:"value"`
+}
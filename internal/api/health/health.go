@@ -0,0 +1,107 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/api/dto"
+	"github.com/etcdfinder/etcdfinder/internal/ingestor"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+	"github.com/gin-gonic/gin"
+)
+
+// timeout of the KV store and etcd requests made by a readiness check
+const probeTimeout = 2 * time.Second
+
+// HealthHandler serves the liveness and readiness probes
+type HealthHandler struct {
+	ingestor ingestor.Base
+	kvStore  kvstore.KVStore
+	maxLag   int64 // revisions the KV store may lag behind etcd while ready, 0 for no limit
+}
+
+func NewHealthHandler(ing ingestor.Base, kvStore kvstore.KVStore, maxLag int64) *HealthHandler {
+	return &HealthHandler{
+		ingestor: ing,
+		kvStore:  kvStore,
+		maxLag:   maxLag,
+	}
+}
+
+// Live reports that the process is serving requests
+func (h *HealthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, dto.LivenessResponse{Status: "ok"})
+}
+
+// Ready reports the status of every component the search depends on, with a 503 if any is not ready
+func (h *HealthHandler) Ready(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), probeTimeout)
+	defer cancel()
+
+	health := h.ingestor.Health()
+	resp := dto.ReadinessResponse{
+		Ready: true,
+		Components: map[string]dto.ComponentStatus{
+			"etcd":         componentStatus(etcd(health)),
+			"kvstore":      componentStatus(h.kvStore.Ping(ctx)),
+			"initial_sync": componentStatus(h.initialSync()),
+			"watch":        componentStatus(h.watch(ctx, health)),
+		},
+	}
+	for _, status := range resp.Components {
+		resp.Ready = resp.Ready && status.Ready
+	}
+
+	code := http.StatusOK
+	if !resp.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, resp)
+}
+
+// etcd fails until an etcd connection check of the auditor succeeds, and while they fail
+func etcd(health ingestor.Health) error {
+	if health.EtcdError != "" {
+		return errors.New(health.EtcdError)
+	}
+	return nil
+}
+
+// initialSync fails until the keys of etcd read at startup are all indexed
+func (h *HealthHandler) initialSync() error {
+	select {
+	case <-h.ingestor.SearchReady():
+		return nil
+	default:
+		return errors.New("initial sync in progress")
+	}
+}
+
+// watch fails while the watch is being restarted or the KV store lags too far behind etcd
+func (h *HealthHandler) watch(ctx context.Context, health ingestor.Health) error {
+	if health.WatchError != "" {
+		return errors.New(health.WatchError)
+	}
+	if h.maxLag <= 0 {
+		return nil
+	}
+
+	delay, err := h.ingestor.GetIngestionDelay(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get the ingestion delay: %w", err)
+	}
+	if delay.RevisionLag > h.maxLag {
+		return fmt.Errorf("lagging %d revisions behind etcd, more than %d", delay.RevisionLag, h.maxLag)
+	}
+	return nil
+}
+
+func componentStatus(err error) dto.ComponentStatus {
+	if err != nil {
+		return dto.ComponentStatus{Ready: false, Error: err.Error()}
+	}
+	return dto.ComponentStatus{Ready: true}
+}
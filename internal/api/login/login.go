@@ -0,0 +1,124 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/etcdfinder/etcdfinder/internal/api/dto"
+	"github.com/etcdfinder/etcdfinder/internal/auth"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/gin-gonic/gin"
+)
+
+// LoginHandler logs users of the web UI in through the OIDC authorization code flow
+type LoginHandler struct {
+	authenticator *auth.Authenticator
+	secureCookie  bool // set the Secure attribute on cookies, required when served over https
+}
+
+func NewLoginHandler(authenticator *auth.Authenticator, secureCookie bool) *LoginHandler {
+	return &LoginHandler{
+		authenticator: authenticator,
+		secureCookie:  secureCookie,
+	}
+}
+
+// Login redirects the user to the OIDC provider
+func (l *LoginHandler) Login(c *gin.Context) {
+	var req dto.LoginRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.Error(fmt.Errorf("invalid request: %w", err)) //nolint
+		return
+	}
+	if !auth.IsLocalRedirect(req.Redirect) {
+		req.Redirect = "/"
+	}
+
+	url, state, err := l.authenticator.LoginURL(req.Redirect)
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	l.setCookie(c, auth.LoginStateCookie, state, "/auth", int(auth.LoginStateTTL.Seconds()))
+	c.Redirect(http.StatusFound, url)
+}
+
+// Callback completes the login and sets the session cookie
+func (l *LoginHandler) Callback(c *gin.Context) {
+	var req dto.CallbackRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.Error(fmt.Errorf("invalid request: %w", err)) //nolint
+		return
+	}
+	if req.Error != "" {
+		c.Error(fmt.Errorf("login refused by provider: %s %s: %w", req.Error, req.ErrorDescription, customerrors.ErrUnauthenticated)) //nolint
+		return
+	}
+
+	state, err := c.Cookie(auth.LoginStateCookie)
+	if err != nil {
+		c.Error(fmt.Errorf("no login in progress: %w", customerrors.ErrUnauthenticated)) //nolint
+		return
+	}
+	// The state is single use
+	l.setCookie(c, auth.LoginStateCookie, "", "/auth", -1)
+
+	session, redirect, err := l.authenticator.Callback(c.Request.Context(), req.Code, req.State, state)
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	l.setCookie(c, auth.SessionCookie, session, "/", int(l.authenticator.SessionTTL().Seconds()))
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// Logout clears the session cookie
+func (l *LoginHandler) Logout(c *gin.Context) {
+	var req dto.LoginRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.Error(fmt.Errorf("invalid request: %w", err)) //nolint
+		return
+	}
+	if !auth.IsLocalRedirect(req.Redirect) {
+		req.Redirect = "/"
+	}
+
+	l.setCookie(c, auth.SessionCookie, "", "/", -1)
+	c.Redirect(http.StatusFound, req.Redirect)
+}
+
+// Me returns the identity of the caller, it must be served behind the auth middleware
+func (l *LoginHandler) Me(c *gin.Context) {
+	identity := lib.GetIdentity(c.Request.Context())
+	if identity == nil {
+		c.Error(customerrors.ErrUnauthenticated) //nolint
+		return
+	}
+
+	groups := identity.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	c.JSON(http.StatusOK, dto.IdentityResponse{
+		Subject: identity.Subject,
+		Email:   identity.Email,
+		Groups:  groups,
+		Method:  string(identity.Method),
+	})
+}
+
+// setCookie sets an HttpOnly cookie, SameSite=Lax keeps it from being sent with cross-site API requests
+func (l *LoginHandler) setCookie(c *gin.Context, name, value, path string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   l.secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,337 @@
+package login_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/api"
+	"github.com/etcdfinder/etcdfinder/internal/api/dto"
+	"github.com/etcdfinder/etcdfinder/internal/api/health"
+	"github.com/etcdfinder/etcdfinder/internal/api/login"
+	v1 "github.com/etcdfinder/etcdfinder/internal/api/v1"
+	v2 "github.com/etcdfinder/etcdfinder/internal/api/v2"
+	"github.com/etcdfinder/etcdfinder/internal/auth"
+	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	clientID    = "etcdfinder"
+	redirectURL = "http://etcdfinder.test/auth/callback"
+)
+
+// authorization is a code issued by the provider, along with the parameters of its authorization request
+type authorization struct {
+	challenge string
+	nonce     string
+}
+
+// provider is a local OIDC provider issuing ID tokens signed with an RSA key for any authorization request
+type provider struct {
+	server *httptest.Server
+	signer jose.Signer
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+func newProvider(t *testing.T) *provider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: "test"}}, nil)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+
+	p := &provider{signer: signer, key: key, codes: map[string]authorization{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.server.URL,
+		"authorization_endpoint":                p.server.URL + "/authorize",
+		"token_endpoint":                        p.server.URL + "/token",
+		"jwks_uri":                              p.server.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &p.key.PublicKey, KeyID: "test", Algorithm: string(jose.RS256), Use: "sig"},
+	}})
+}
+
+// authorize logs the user in right away and redirects back with a code
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "PKCE required", http.StatusBadRequest)
+		return
+	}
+
+	code := lib.GenerateUUID()
+	p.mu.Lock()
+	p.codes[code] = authorization{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	p.mu.Unlock()
+
+	callback, _ := url.Parse(query.Get("redirect_uri"))
+	callback.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, callback.String(), http.StatusFound)
+}
+
+// token exchanges a code for an ID token if the verifier matches the challenge of the authorization
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.mu.Lock()
+	authz, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != authz.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims, _ := json.Marshal(map[string]any{
+		"iss":    p.server.URL,
+		"aud":    clientID,
+		"sub":    "alice",
+		"email":  "alice@example.com",
+		"groups": []string{"admins"},
+		"nonce":  authz.nonce,
+		"iat":    now.Unix(),
+		"exp":    now.Add(time.Hour).Unix(),
+	})
+	signed, err := p.signer.Sign(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	idToken, _ := signed.CompactSerialize()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) //nolint
+}
+
+// newRouter serves the login routes of an authenticator logging users in through the provider
+func newRouter(t *testing.T, p *provider, sessionTTL int64) *gin.Engine {
+	t.Helper()
+	logger.L = &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}
+
+	authenticator, err := auth.NewAuthenticator(context.Background(), config.AuthConfig{
+		OIDC: config.OIDCConfig{
+			IssuerURL:   p.server.URL,
+			ClientID:    clientID,
+			RedirectURL: redirectURL,
+			SessionTTL:  sessionTTL,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+
+	router, err := api.NewRouter(api.RouterConfig{Authenticator: authenticator}, api.Handlers{
+		EtcdFinderHandler: v1.NewEtcdfinderHandler(nil),
+		KeysHandler:       v2.NewKeysHandler(nil),
+		HealthHandler:     health.NewHealthHandler(nil, nil, 0),
+		LoginHandler:      login.NewLoginHandler(authenticator, false),
+	})
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	return router
+}
+
+func serve(router *gin.Engine, path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func cookie(t *testing.T, rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	t.Helper()
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == name && c.MaxAge >= 0 {
+			return c
+		}
+	}
+	t.Fatalf("response did not set the %s cookie", name)
+	return nil
+}
+
+// startLogin calls /auth/login and returns the login state cookie and the callback the provider redirects to
+func startLogin(t *testing.T, router *gin.Engine) (*http.Cookie, *url.URL) {
+	t.Helper()
+	rec := serve(router, "/auth/login?redirect=/ui")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("/auth/login returned %d", rec.Code)
+	}
+	state := cookie(t, rec, auth.LoginStateCookie)
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("authorization request failed: %v", err)
+	}
+	resp.Body.Close() //nolint
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("provider returned %d to the authorization request", resp.StatusCode)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid callback: %v", err)
+	}
+	return state, callback
+}
+
+// logIn completes the login and returns the session cookie
+func logIn(t *testing.T, router *gin.Engine) *http.Cookie {
+	t.Helper()
+	state, callback := startLogin(t, router)
+	rec := serve(router, callback.RequestURI(), state)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("/auth/callback returned %d: %s", rec.Code, rec.Body)
+	}
+	if location := rec.Header().Get("Location"); location != "/ui" {
+		t.Errorf("callback redirected to %q, want /ui", location)
+	}
+	return cookie(t, rec, auth.SessionCookie)
+}
+
+func TestLoginFlow(t *testing.T) {
+	router := newRouter(t, newProvider(t), 0)
+	session := logIn(t, router)
+
+	rec := serve(router, "/auth/me", session)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/auth/me returned %d: %s", rec.Code, rec.Body)
+	}
+	var me dto.IdentityResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &me); err != nil {
+		t.Fatalf("failed to decode /auth/me: %v", err)
+	}
+	if me.Subject != "alice" || me.Email != "alice@example.com" || len(me.Groups) != 1 || me.Groups[0] != "admins" ||
+		me.Method != string(lib.AUTH_METHOD_SESSION) {
+		t.Errorf("/auth/me = %+v", me)
+	}
+
+	if rec := serve(router, "/auth/me"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("/auth/me without session returned %d, want 401", rec.Code)
+	}
+}
+
+func TestCallbackStateMismatch(t *testing.T) {
+	router := newRouter(t, newProvider(t), 0)
+	state, callback := startLogin(t, router)
+
+	query := callback.Query()
+	query.Set("state", "forged")
+	callback.RawQuery = query.Encode()
+	if rec := serve(router, callback.RequestURI(), state); rec.Code != http.StatusUnauthorized {
+		t.Errorf("callback with another state returned %d, want 401", rec.Code)
+	}
+}
+
+func TestCallbackWithoutLoginState(t *testing.T) {
+	router := newRouter(t, newProvider(t), 0)
+	_, callback := startLogin(t, router)
+
+	if rec := serve(router, callback.RequestURI()); rec.Code != http.StatusUnauthorized {
+		t.Errorf("callback without login state returned %d, want 401", rec.Code)
+	}
+}
+
+func TestCallbackPKCEMismatch(t *testing.T) {
+	router := newRouter(t, newProvider(t), 0)
+	_, first := startLogin(t, router)
+	state, second := startLogin(t, router)
+
+	// The code of the first login is exchanged with the verifier of the second one
+	query := second.Query()
+	query.Set("code", first.Query().Get("code"))
+	second.RawQuery = query.Encode()
+	if rec := serve(router, second.RequestURI(), state); rec.Code != http.StatusUnauthorized {
+		t.Errorf("callback with the code of another login returned %d, want 401", rec.Code)
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	router := newRouter(t, newProvider(t), 1)
+	session := logIn(t, router)
+
+	time.Sleep(1100 * time.Millisecond)
+	if rec := serve(router, "/auth/me", session); rec.Code != http.StatusUnauthorized {
+		t.Errorf("/auth/me with an expired session returned %d, want 401", rec.Code)
+	}
+}
+
+func TestSessionTampered(t *testing.T) {
+	router := newRouter(t, newProvider(t), 0)
+	session := logIn(t, router)
+
+	payload, sig, _ := strings.Cut(session.Value, ".")
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		t.Fatalf("failed to decode session: %v", err)
+	}
+	data = []byte(strings.Replace(string(data), `"sub":"alice"`, `"sub":"mallory"`, 1))
+
+	tampered := *session
+	tampered.Value = base64.RawURLEncoding.EncodeToString(data) + "." + sig
+	if rec := serve(router, "/auth/me", &tampered); rec.Code != http.StatusUnauthorized {
+		t.Errorf("/auth/me with a tampered session returned %d, want 401", rec.Code)
+	}
+
+	// A login state is signed for another purpose and is not accepted as a session
+	state, _ := startLogin(t, router)
+	state.Name = auth.SessionCookie
+	if rec := serve(router, "/auth/me", state); rec.Code != http.StatusUnauthorized {
+		t.Errorf("/auth/me with a login state as session returned %d, want 401", rec.Code)
+	}
+}
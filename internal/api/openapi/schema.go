@@ -0,0 +1,132 @@
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schema is an OpenAPI 3 schema object
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// schemaGenerator derives schemas from Go types, registering named structs as components
+type schemaGenerator struct {
+	components map[string]*Schema
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{components: map[string]*Schema{}}
+}
+
+// schemaOf returns the schema of v's type, a reference for named structs
+func (g *schemaGenerator) schemaOf(v any) *Schema {
+	return g.schema(reflect.TypeOf(v))
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return &Schema{Type: "string", Format: "date-time"}
+		}
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.components[t.Name()]; !ok {
+			// Register before recursing so that self-referencing types terminate
+			g.components[t.Name()] = &Schema{}
+			*g.components[t.Name()] = *g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	default:
+		// interfaces accept any value
+		return &Schema{}
+	}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+
+	for field := range fields(t, "json") {
+		s.Properties[field.name] = g.schema(field.typ)
+		if !field.omitEmpty {
+			s.Required = append(s.Required, field.name)
+		}
+	}
+
+	return s
+}
+
+type structField struct {
+	name      string
+	typ       reflect.Type
+	omitEmpty bool
+}
+
+// fields yields the exported fields of t named after the given struct tag, flattening embedded structs
+func fields(t reflect.Type, tag string) func(yield func(structField) bool) {
+	return func(yield func(structField) bool) {
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+
+			name, opts, _ := strings.Cut(f.Tag.Get(tag), ",")
+			if name == "-" {
+				continue
+			}
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				for embedded := range fields(f.Type, tag) {
+					if !yield(embedded) {
+						return
+					}
+				}
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+
+			if !yield(structField{
+				name:      name,
+				typ:       f.Type,
+				omitEmpty: strings.Contains(opts, "omitempty"),
+			}) {
+				return
+			}
+		}
+	}
+}
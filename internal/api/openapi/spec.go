@@ -0,0 +1,238 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/gin-gonic/gin"
+)
+
+// Document is an OpenAPI 3 document
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+	Security   []map[string][]string            `json:"security,omitempty"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+}
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Route describes a route of the router in terms of its DTOs
+type Route struct {
+	Summary     string
+	Description string
+	Tags        []string
+	Query       any     // struct whose form-tagged fields are query parameters, nil if none
+	Request     any     // JSON request body, nil if none
+	Response    any     // response body, nil if none
+	ContentType string  // content type of the response, defaults to application/json
+	Status      int     // status of a successful response, defaults to 200
+	Errors      []error // errors from customerrors the route may return
+}
+
+// Key returns the key of the route registered for method and path
+func Key(method, path string) string {
+	return method + " " + path
+}
+
+var pathParamRegexp = regexp.MustCompile(`[:*]([^/]+)`)
+
+// Build generates the document for the routes of the router from their descriptions.
+// Every route must be described and every description must match a route.
+func Build(title, version string, routes gin.RoutesInfo, descriptions map[string]Route) (*Document, error) {
+	gen := newSchemaGenerator()
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info: Info{
+			Title:   title,
+			Version: version,
+		},
+		Paths: map[string]map[string]*Operation{},
+	}
+
+	var missing []string
+	described := map[string]bool{}
+	for _, r := range routes {
+		key := Key(r.Method, r.Path)
+		route, ok := descriptions[key]
+		if !ok {
+			missing = append(missing, key)
+			continue
+		}
+		described[key] = true
+
+		path := pathParamRegexp.ReplaceAllString(r.Path, "{$1}")
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*Operation{}
+		}
+		doc.Paths[path][strings.ToLower(r.Method)] = route.operation(gen, r.Method, r.Path)
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("routes without an openapi description: %s", strings.Join(missing, ", "))
+	}
+
+	var stale []string
+	for key := range descriptions {
+		if !described[key] {
+			stale = append(stale, key)
+		}
+	}
+	if len(stale) > 0 {
+		sort.Strings(stale)
+		return nil, fmt.Errorf("openapi descriptions without a route: %s", strings.Join(stale, ", "))
+	}
+
+	gen.schemaOf(customerrors.ErrorResponse{})
+	doc.Components.Schemas = gen.components
+
+	return doc, nil
+}
+
+// RequireAuth documents that every operation accepts either an API key in the header or a bearer token
+func (d *Document) RequireAuth(apiKeyHeader string) {
+	d.Components.SecuritySchemes = map[string]*SecurityScheme{
+		"apiKey": {Type: "apiKey", In: "header", Name: apiKeyHeader},
+		"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+	}
+	d.Security = []map[string][]string{{"apiKey": {}}, {"bearer": {}}}
+}
+
+func (r Route) operation(gen *schemaGenerator, method, path string) *Operation {
+	op := &Operation{
+		OperationID: operationID(method, path),
+		Summary:     r.Summary,
+		Description: r.Description,
+		Tags:        r.Tags,
+		Responses:   map[string]*Response{},
+	}
+
+	for _, param := range pathParamRegexp.FindAllStringSubmatch(path, -1) {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:     param[1],
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "string"},
+		})
+	}
+	if r.Query != nil {
+		for field := range fields(reflect.TypeOf(r.Query), "form") {
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:   field.name,
+				In:     "query",
+				Schema: gen.schema(field.typ),
+			})
+		}
+	}
+
+	if r.Request != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content: map[string]MediaType{
+				"application/json": {Schema: gen.schemaOf(r.Request)},
+			},
+		}
+	}
+
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := &Response{Description: http.StatusText(status)}
+	if r.Response != nil {
+		contentType := r.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		success.Content = map[string]MediaType{
+			contentType: {Schema: gen.schemaOf(r.Response)},
+		}
+	}
+	op.Responses[strconv.Itoa(status)] = success
+
+	// Group the error codes by the status they are returned with
+	codes := map[int][]string{}
+	for _, err := range r.Errors {
+		status := customerrors.HTTPStatusFromErr(err)
+		codes[status] = append(codes[status], customerrors.CodeFromErr(err))
+	}
+	if _, ok := codes[http.StatusInternalServerError]; !ok {
+		codes[http.StatusInternalServerError] = nil
+	}
+	for status, statusCodes := range codes {
+		slices.Sort(statusCodes)
+		description := http.StatusText(status)
+		if len(statusCodes) > 0 {
+			description += ": " + strings.Join(statusCodes, ", ")
+		}
+		op.Responses[strconv.Itoa(status)] = &Response{
+			Description: description,
+			Content: map[string]MediaType{
+				"application/json": {Schema: gen.schemaOf(customerrors.ErrorResponse{})},
+			},
+		}
+	}
+
+	return op
+}
+
+// operationID derives a unique identifier from the route, e.g. "post_v1_get_key"
+func operationID(method, path string) string {
+	id := strings.ToLower(method) + "_" + strings.Trim(path, "/")
+	return strings.NewReplacer("/", "_", "-", "_", ":", "", "*", "", ".", "_").Replace(id)
+}
@@ -0,0 +1,56 @@
+package openapi
+
+import (
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	swaggerfiles "github.com/swaggo/files/v2"
+)
+
+// swaggerInitializer replaces the bundled initializer pointing to the petstore example
+const swaggerInitializer = `window.onload = function() {
+  window.ui = SwaggerUIBundle({
+    url: %q,
+    dom_id: '#swagger-ui',
+    deepLinking: true,
+    presets: [
+      SwaggerUIBundle.presets.apis,
+      SwaggerUIStandalonePreset
+    ],
+    plugins: [
+      SwaggerUIBundle.plugins.DownloadUrl
+    ],
+    layout: "StandaloneLayout"
+  });
+};
+`
+
+// SwaggerUIHandler serves the bundled Swagger UI rendering the document at specURL.
+// It must be registered on a route ending with the *filepath wildcard.
+func SwaggerUIHandler(specURL string) gin.HandlerFunc {
+	initializer := fmt.Sprintf(swaggerInitializer, specURL)
+
+	return func(c *gin.Context) {
+		name := strings.TrimPrefix(path.Clean(c.Param("filepath")), "/")
+		if name == "" || name == "." {
+			name = "index.html"
+		}
+
+		if name == "swagger-initializer.js" {
+			c.Data(http.StatusOK, "application/javascript", []byte(initializer))
+			return
+		}
+
+		data, err := fs.ReadFile(swaggerfiles.FS, name)
+		if err != nil {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Data(http.StatusOK, mime.TypeByExtension(path.Ext(name)), data)
+	}
+}
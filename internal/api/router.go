@@ -1,16 +1,36 @@
 package api
 
 import (
+	"maps"
+	"net/http"
+
+	"github.com/etcdfinder/etcdfinder/internal/api/health"
+	"github.com/etcdfinder/etcdfinder/internal/api/login"
+	"github.com/etcdfinder/etcdfinder/internal/api/openapi"
 	v1 "github.com/etcdfinder/etcdfinder/internal/api/v1"
+	v2 "github.com/etcdfinder/etcdfinder/internal/api/v2"
+	"github.com/etcdfinder/etcdfinder/internal/auth"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
 	"github.com/etcdfinder/etcdfinder/internal/rest/middleware"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Handlers struct {
 	EtcdFinderHandler *v1.EtcdfinderHandler
+	KeysHandler       *v2.KeysHandler
+	LoginHandler      *login.LoginHandler // nil if OIDC login is disabled
+	AuditHandler      *v1.AuditHandler    // nil if the audit log cannot be queried
+	ChangesHandler    *v1.ChangesHandler  // nil if approvals are disabled
+	HealthHandler     *health.HealthHandler
+}
+
+type RouterConfig struct {
+	Authenticator *auth.Authenticator // authenticates callers of the API routes, nil disables authentication
+	ReadOnly      bool                // leaves out the routes modifying keys
 }
 
-func NewRouter(handlers Handlers) (*gin.Engine, error) {
+func NewRouter(conf RouterConfig, handlers Handlers) (*gin.Engine, error) {
 	// Set gin mode to release
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
@@ -23,20 +43,84 @@ func NewRouter(handlers Handlers) (*gin.Engine, error) {
 
 	router.Use(
 		middleware.LoggerMiddleware(),
+		middleware.MetricsMiddleware,
 		middleware.RequestIDMiddleware,
 		middleware.CORSMiddleware,
 		middleware.ErrorHandler(),
 	)
 
-	v1 := router.Group("/v1")
+	// The spec and docs stay public, only the API routes require authentication
+	var apiMiddlewares []gin.HandlerFunc
+	if conf.Authenticator != nil {
+		apiMiddlewares = append(apiMiddlewares, middleware.AuthMiddleware(conf.Authenticator))
+	}
+
+	v1 := router.Group("/v1", apiMiddlewares...)
 
 	{
 		v1.POST("/get-key", handlers.EtcdFinderHandler.GetKey)
 		v1.POST("/search-keys", handlers.EtcdFinderHandler.SearchKeys)
+		v1.POST("/list-keys", handlers.EtcdFinderHandler.ListKeys)
+		v1.GET("/ingestion-delay", handlers.EtcdFinderHandler.GetIngestionDelay)
+		v1.GET("/reconciliation", handlers.EtcdFinderHandler.GetReconciliation)
+		v1.GET("/watch-keys", handlers.EtcdFinderHandler.WatchKeys)
+	}
+
+	v2 := router.Group("/v2", apiMiddlewares...)
+
+	{
+		v2.GET("/keys/*path", handlers.KeysHandler.Get)
+		v2.HEAD("/keys/*path", handlers.KeysHandler.Get)
+	}
+
+	descriptions := maps.Clone(routeDescriptions)
+	if !conf.ReadOnly {
 		v1.PUT("/put-key", handlers.EtcdFinderHandler.PutKey)
 		v1.DELETE("/delete-key", handlers.EtcdFinderHandler.DeleteKey)
-		v1.GET("/ingestion-delay", handlers.EtcdFinderHandler.GetIngestionDelay)
+		v2.PUT("/keys/*path", handlers.KeysHandler.Put)
+		v2.DELETE("/keys/*path", handlers.KeysHandler.Delete)
+		maps.Copy(descriptions, mutatingRouteDescriptions)
+	}
+	if handlers.ChangesHandler != nil {
+		v1.GET("/changes", handlers.ChangesHandler.ListChanges)
+		v1.GET("/changes/:id", handlers.ChangesHandler.GetChange)
+		maps.Copy(descriptions, changesRouteDescriptions)
+
+		if !conf.ReadOnly {
+			v1.POST("/changes/:id/approve", handlers.ChangesHandler.ApproveChange)
+			v1.POST("/changes/:id/reject", handlers.ChangesHandler.RejectChange)
+			maps.Copy(descriptions, decisionRouteDescriptions)
+		}
+	}
+	if handlers.AuditHandler != nil {
+		v1.GET("/audit", handlers.AuditHandler.QueryAudit)
+		v1.GET("/audit/verify", handlers.AuditHandler.VerifyAudit)
+		maps.Copy(descriptions, auditRouteDescriptions)
+	}
+	if handlers.LoginHandler != nil {
+		authGroup := router.Group("/auth")
+		authGroup.GET("/login", handlers.LoginHandler.Login)
+		authGroup.GET("/callback", handlers.LoginHandler.Callback)
+		authGroup.GET("/logout", handlers.LoginHandler.Logout)
+		authGroup.GET("/me", append(apiMiddlewares, handlers.LoginHandler.Me)...)
+		maps.Copy(descriptions, loginRouteDescriptions)
+	}
+
+	// Generate the spec from the routes registered so far, the spec, docs, metrics and probe routes are not part of it
+	spec, err := openapi.Build(specTitle, specVersion, router.Routes(), descriptions)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Authenticator != nil {
+		spec.RequireAuth(lib.HeaderAPIKey)
 	}
+	router.GET(specURL, func(c *gin.Context) {
+		c.JSON(http.StatusOK, spec)
+	})
+	router.GET("/docs/*filepath", openapi.SwaggerUIHandler(specURL))
+	router.GET(metricsURL, gin.WrapH(promhttp.Handler()))
+	router.GET(livenessURL, handlers.HealthHandler.Live)
+	router.GET(readinessURL, handlers.HealthHandler.Ready)
 
 	return router, nil
 }
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/etcdfinder/etcdfinder/internal/api/health"
+	"github.com/etcdfinder/etcdfinder/internal/api/login"
+	"github.com/etcdfinder/etcdfinder/internal/api/openapi"
+	v1 "github.com/etcdfinder/etcdfinder/internal/api/v1"
+	v2 "github.com/etcdfinder/etcdfinder/internal/api/v2"
+	"github.com/etcdfinder/etcdfinder/internal/auth"
+	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// undocumentedRoutes are served next to the API and left out of the spec on purpose
+var undocumentedRoutes = map[string]bool{
+	openapi.Key(http.MethodGet, specURL):           true,
+	openapi.Key(http.MethodGet, "/docs/*filepath"): true,
+	openapi.Key(http.MethodGet, metricsURL):        true,
+	openapi.Key(http.MethodGet, livenessURL):       true,
+	openapi.Key(http.MethodGet, readinessURL):      true,
+}
+
+func TestRouterSpecCoversEveryRoute(t *testing.T) {
+	authenticator, err := auth.NewAuthenticator(context.Background(), config.AuthConfig{})
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		conf     RouterConfig
+		changes  bool
+		audit    bool
+		login    bool
+		mutating bool
+	}{
+		{name: "default", mutating: true},
+		{name: "read-only", conf: RouterConfig{ReadOnly: true}},
+		{name: "changes", changes: true, mutating: true},
+		{name: "read-only with changes", conf: RouterConfig{ReadOnly: true}, changes: true},
+		{name: "audit", audit: true, mutating: true},
+		{name: "login", conf: RouterConfig{Authenticator: authenticator}, login: true, mutating: true},
+		{name: "everything", conf: RouterConfig{Authenticator: authenticator}, changes: true, audit: true, login: true, mutating: true},
+		{name: "read-only everything", conf: RouterConfig{Authenticator: authenticator, ReadOnly: true}, changes: true, audit: true, login: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handlers := Handlers{
+				EtcdFinderHandler: v1.NewEtcdfinderHandler(nil),
+				KeysHandler:       v2.NewKeysHandler(nil),
+				HealthHandler:     health.NewHealthHandler(nil, nil, 0),
+			}
+			if tt.changes {
+				handlers.ChangesHandler = v1.NewChangesHandler(nil, nil, nil)
+			}
+			if tt.audit {
+				handlers.AuditHandler = v1.NewAuditHandler(nil, nil)
+			}
+			if tt.login {
+				handlers.LoginHandler = login.NewLoginHandler(authenticator, false)
+			}
+
+			router, err := NewRouter(tt.conf, handlers)
+			if err != nil {
+				t.Fatalf("NewRouter: %v", err)
+			}
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, specURL, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("GET %s returned %d", specURL, rec.Code)
+			}
+			var spec openapi.Document
+			if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+				t.Fatalf("failed to decode the spec: %v", err)
+			}
+
+			documented := 0
+			for _, r := range router.Routes() {
+				key := openapi.Key(r.Method, r.Path)
+				if undocumentedRoutes[key] {
+					continue
+				}
+				documented++
+				path := pathParam(r.Path)
+				if spec.Paths[path][strings.ToLower(r.Method)] == nil {
+					t.Errorf("route %s is not in the spec", key)
+				}
+			}
+			operations := 0
+			for _, ops := range spec.Paths {
+				operations += len(ops)
+			}
+			if operations != documented {
+				t.Errorf("spec has %d operations for %d routes", operations, documented)
+			}
+
+			if _, ok := spec.Paths["/v1/put-key"]; ok != tt.mutating {
+				t.Errorf("spec documents /v1/put-key: %t, want %t", ok, tt.mutating)
+			}
+			if _, ok := spec.Paths["/v1/changes"]; ok != tt.changes {
+				t.Errorf("spec documents /v1/changes: %t, want %t", ok, tt.changes)
+			}
+			if _, ok := spec.Paths["/v1/changes/{id}/approve"]; ok != (tt.changes && tt.mutating) {
+				t.Errorf("spec documents /v1/changes/{id}/approve: %t, want %t", ok, tt.changes && tt.mutating)
+			}
+			if (spec.Security != nil) != (tt.conf.Authenticator != nil) {
+				t.Errorf("spec requires authentication: %t, want %t", spec.Security != nil, tt.conf.Authenticator != nil)
+			}
+		})
+	}
+}
+
+func TestBuildRejectsUndescribedRoutes(t *testing.T) {
+	routes := []gin.RouteInfo{{Method: http.MethodGet, Path: "/v1/undocumented"}}
+	if _, err := openapi.Build(specTitle, specVersion, routes, routeDescriptions); err == nil {
+		t.Errorf("Build succeeded with a route without description")
+	}
+}
+
+// pathParam converts the parameters of a gin path to the OpenAPI syntax
+func pathParam(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/etcdfinder/etcdfinder/internal/api/dto"
+	"github.com/etcdfinder/etcdfinder/internal/api/openapi"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+)
+
+const (
+	specTitle   = "etcdfinder API"
+	specVersion = "1.0.0"
+	specURL     = "/openapi.json"
+	metricsURL  = "/metrics"
+
+	livenessURL  = "/healthz"
+	readinessURL = "/readyz"
+)
+
+// routeDescriptions documents every route of the router, NewRouter fails if one is missing
+var routeDescriptions = map[string]openapi.Route{
+	openapi.Key(http.MethodPost, "/v1/get-key"): {
+		Summary:  "Get the value of a key from etcd",
+		Tags:     []string{"keys"},
+		Request:  dto.GetKeyRequest{},
+		Response: dto.GetKeyResponse{},
+		Errors:   []error{customerrors.ErrKeyRequired, customerrors.ErrKeyNotFound, customerrors.ErrForbidden},
+	},
+	openapi.Key(http.MethodPost, "/v1/search-keys"): {
+		Summary:     "Search keys",
+		Description: "Full-text search over the indexed keys, paginated with offset and limit.",
+		Tags:        []string{"keys"},
+		Request:     dto.SearchKeysRequest{},
+		Response:    dto.SearchKeysResponse{},
+		Errors:      []error{customerrors.ErrMalformedSearchString, customerrors.ErrInvalidPagination},
+	},
+	openapi.Key(http.MethodPost, "/v1/list-keys"): {
+		Summary:     "List keys",
+		Description: "Pages through every indexed key with a cursor, unlike searches whose results are capped by the search index. A key modified during the listing may be listed twice.",
+		Tags:        []string{"keys"},
+		Request:     dto.ListKeysRequest{},
+		Response:    dto.ListKeysResponse{},
+		Errors:      []error{customerrors.ErrInvalidPagination},
+	},
+	openapi.Key(http.MethodGet, "/v1/ingestion-delay"): {
+		Summary:     "Get the ingestion delay of the search index",
+		Description: "Compares the revision of the last event applied to the search index to the current revision of etcd, along with the time the recent events took from being received to being acknowledged by the search index.",
+		Tags:        []string{"ingestion"},
+		Response:    dto.GetIngestionDelayResponse{},
+	},
+	openapi.Key(http.MethodGet, "/v1/reconciliation"): {
+		Summary:     "Get the last reconciliation of the search index",
+		Description: "Reports the keys the last run of the reconciler added to, updated in or deleted from the search index to match etcd.",
+		Tags:        []string{"ingestion"},
+		Response:    dto.GetReconciliationResponse{},
+	},
+	openapi.Key(http.MethodGet, "/v1/watch-keys"): {
+		Summary:     "Stream key changes",
+		Description: "Server-sent events named `watch`, one per change applied to the search index.",
+		Tags:        []string{"keys"},
+		Query:       dto.WatchKeysRequest{},
+		Response:    dto.WatchEvent{},
+		ContentType: "text/event-stream",
+	},
+	openapi.Key(http.MethodGet, "/v2/keys/*path"): {
+		Summary:     "Get a key",
+		Description: "Returns the raw value as text/plain or application/octet-stream, or the key with its revision as JSON, depending on the Accept header. The ETag is the mod revision of the key, a matching If-None-Match returns 304.",
+		Tags:        []string{"keys"},
+		Response:    dto.KeyResponse{},
+		Errors:      []error{customerrors.ErrKeyRequired, customerrors.ErrKeyNotFound, customerrors.ErrNotAcceptable, customerrors.ErrPreconditionFailed, customerrors.ErrForbidden},
+	},
+	openapi.Key(http.MethodHead, "/v2/keys/*path"): {
+		Summary: "Get the ETag of a key",
+		Tags:    []string{"keys"},
+		Errors:  []error{customerrors.ErrKeyRequired, customerrors.ErrKeyNotFound, customerrors.ErrNotAcceptable, customerrors.ErrPreconditionFailed, customerrors.ErrForbidden},
+	},
+}
+
+// mutatingRouteDescriptions documents the routes modifying keys, left out in read-only mode
+var mutatingRouteDescriptions = map[string]openapi.Route{
+	openapi.Key(http.MethodPut, "/v1/put-key"): {
+		Summary:  "Create or update a key",
+		Tags:     []string{"keys"},
+		Request:  dto.PutKeyRequest{},
+		Response: dto.PutKeyResponse{},
+		Errors:   []error{customerrors.ErrKeyRequired, customerrors.ErrValueRequired, customerrors.ErrValueRedacted, customerrors.ErrKeyNotPut, customerrors.ErrForbidden, customerrors.ErrKeyProtected, customerrors.ErrApprovalRequired},
+	},
+	openapi.Key(http.MethodDelete, "/v1/delete-key"): {
+		Summary:  "Delete a key",
+		Tags:     []string{"keys"},
+		Request:  dto.DeleteKeyRequest{},
+		Response: dto.DeleteKeyResponse{},
+		Errors:   []error{customerrors.ErrKeyRequired, customerrors.ErrKeyNotDeleted, customerrors.ErrForbidden, customerrors.ErrKeyProtected, customerrors.ErrApprovalRequired},
+	},
+	openapi.Key(http.MethodPut, "/v2/keys/*path"): {
+		Summary:     "Create or update a key",
+		Description: "The value is the raw body, or the value field of a JSON body. If-Match makes the write conditional on the ETag of the key, If-None-Match: * on the key not existing.",
+		Tags:        []string{"keys"},
+		Request:     dto.PutKeyValueRequest{},
+		Status:      http.StatusNoContent,
+		Errors:      []error{customerrors.ErrKeyRequired, customerrors.ErrValueRedacted, customerrors.ErrKeyNotPut, customerrors.ErrPreconditionFailed, customerrors.ErrForbidden, customerrors.ErrKeyProtected, customerrors.ErrApprovalRequired},
+	},
+	openapi.Key(http.MethodDelete, "/v2/keys/*path"): {
+		Summary:     "Delete a key",
+		Description: "If-Match makes the delete conditional on the ETag of the key.",
+		Tags:        []string{"keys"},
+		Status:      http.StatusNoContent,
+		Errors:      []error{customerrors.ErrKeyRequired, customerrors.ErrKeyNotDeleted, customerrors.ErrPreconditionFailed, customerrors.ErrForbidden, customerrors.ErrKeyProtected, customerrors.ErrApprovalRequired},
+	},
+}
+
+// changesRouteDescriptions documents the routes registered when approvals are enabled
+var changesRouteDescriptions = map[string]openapi.Route{
+	openapi.Key(http.MethodGet, "/v1/changes"): {
+		Summary:     "List the changes of critical keys",
+		Description: "Returns the most recent changes first, limited to the keys the caller may read.",
+		Tags:        []string{"changes"},
+		Query:       dto.ListChangesRequest{},
+		Response:    dto.ListChangesResponse{},
+	},
+	openapi.Key(http.MethodGet, "/v1/changes/:id"): {
+		Summary:  "Get a change",
+		Tags:     []string{"changes"},
+		Response: dto.Change{},
+		Errors:   []error{customerrors.ErrChangeNotFound},
+	},
+}
+
+// decisionRouteDescriptions documents the routes deciding changes, registered when approvals are enabled and left out
+// in read-only mode
+var decisionRouteDescriptions = map[string]openapi.Route{
+	openapi.Key(http.MethodPost, "/v1/changes/:id/approve"): {
+		Summary:     "Approve a change",
+		Description: "Applies the change if the key is still at the base revision of the change, otherwise the change becomes stale. The approver must not be the requester and needs the permission to make the change.",
+		Tags:        []string{"changes"},
+		Response:    dto.Change{},
+		Errors:      []error{customerrors.ErrChangeNotFound, customerrors.ErrChangeNotPending, customerrors.ErrChangeStale, customerrors.ErrForbidden, customerrors.ErrKeyProtected},
+	},
+	openapi.Key(http.MethodPost, "/v1/changes/:id/reject"): {
+		Summary:     "Reject a change",
+		Description: "The requester may reject their own changes, other callers need the permission to make the change.",
+		Tags:        []string{"changes"},
+		Response:    dto.Change{},
+		Errors:      []error{customerrors.ErrChangeNotFound, customerrors.ErrChangeNotPending, customerrors.ErrForbidden},
+	},
+}
+
+// loginRouteDescriptions documents the routes registered when OIDC login is enabled
+var loginRouteDescriptions = map[string]openapi.Route{
+	openapi.Key(http.MethodGet, "/auth/login"): {
+		Summary:     "Log in through the OIDC provider",
+		Description: "Redirects to the OIDC provider, which redirects back to `/auth/callback`. `redirect` is the path to return to after the login.",
+		Tags:        []string{"auth"},
+		Query:       dto.LoginRequest{},
+		Status:      http.StatusFound,
+	},
+	openapi.Key(http.MethodGet, "/auth/callback"): {
+		Summary:     "Complete the login",
+		Description: "Called by the OIDC provider, sets the session cookie and redirects to the path given to `/auth/login`.",
+		Tags:        []string{"auth"},
+		Query:       dto.CallbackRequest{},
+		Status:      http.StatusFound,
+		Errors:      []error{customerrors.ErrUnauthenticated},
+	},
+	openapi.Key(http.MethodGet, "/auth/logout"): {
+		Summary: "Log out",
+		Tags:    []string{"auth"},
+		Query:   dto.LoginRequest{},
+		Status:  http.StatusFound,
+	},
+	openapi.Key(http.MethodGet, "/auth/me"): {
+		Summary:  "Get the authenticated caller",
+		Tags:     []string{"auth"},
+		Response: dto.IdentityResponse{},
+		Errors:   []error{customerrors.ErrUnauthenticated},
+	},
+}
+
+// auditRouteDescriptions documents the routes registered when the audit log can be queried
+var auditRouteDescriptions = map[string]openapi.Route{
+	openapi.Key(http.MethodGet, "/v1/audit"): {
+		Summary:     "Query the audit log",
+		Description: "Returns the most recent records first, limited to the keys the caller may read. `since` and `until` are RFC 3339 timestamps.",
+		Tags:        []string{"audit"},
+		Query:       dto.QueryAuditRequest{},
+		Response:    dto.QueryAuditResponse{},
+		Errors:      []error{customerrors.ErrInvalidPagination},
+	},
+	openapi.Key(http.MethodGet, "/v1/audit/verify"): {
+		Summary:     "Verify the audit log",
+		Description: "Checks that every record of the audit log holds the hash of the previous one and has not been modified.",
+		Tags:        []string{"audit"},
+		Response:    dto.VerifyAuditResponse{},
+	},
+}
@@ -0,0 +1,94 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/etcdfinder/etcdfinder/internal/api/dto"
+	"github.com/etcdfinder/etcdfinder/internal/audit"
+	"github.com/etcdfinder/etcdfinder/internal/authz"
+	"github.com/gin-gonic/gin"
+)
+
+// AuditHandler serves the audit log of the sinks that can be queried
+type AuditHandler struct {
+	querier    audit.Querier
+	authorizer authz.Authorizer
+}
+
+func NewAuditHandler(querier audit.Querier, authorizer authz.Authorizer) *AuditHandler {
+	return &AuditHandler{
+		querier:    querier,
+		authorizer: authorizer,
+	}
+}
+
+// QueryAudit returns the most recent records matching the query, limited to the keys the caller may read
+func (a *AuditHandler) QueryAudit(c *gin.Context) {
+	var req dto.QueryAuditRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.Error(fmt.Errorf("invalid request: %w", err)) //nolint
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	ctx := c.Request.Context()
+	records, err := a.querier.Query(ctx, audit.Filter{
+		Key:       req.Key,
+		Subject:   req.Subject,
+		Operation: audit.Operation(req.Operation),
+		Since:     req.Since,
+		Until:     req.Until,
+		Limit:     req.Limit,
+		Allowed: func(r audit.Record) bool {
+			return a.authorizer.Allowed(ctx, authz.PermissionRead, r.Key)
+		},
+	})
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	resp := dto.QueryAuditResponse{Records: make([]dto.AuditRecord, 0, len(records))}
+	for _, r := range records {
+		resp.Records = append(resp.Records, dto.AuditRecord{
+			Time:         r.Time,
+			RequestID:    r.RequestID,
+			Subject:      r.Subject,
+			AuthMethod:   r.AuthMethod,
+			ChangeID:     r.ChangeID,
+			RequestedBy:  r.RequestedBy,
+			Operation:    string(r.Operation),
+			Key:          r.Key,
+			OldValueHash: r.OldValueHash,
+			NewValueHash: r.NewValueHash,
+			PrevRevision: r.PrevRevision,
+			Revision:     r.Revision,
+			PrevHash:     r.PrevHash,
+			Hash:         r.Hash,
+		})
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// VerifyAudit checks the hash chain of the audit log and returns its signed head
+func (a *AuditHandler) VerifyAudit(c *gin.Context) {
+	count, err := a.querier.Verify(c.Request.Context())
+
+	head := a.querier.Head()
+	resp := dto.VerifyAuditResponse{
+		Valid:   err == nil,
+		Records: count,
+		Head:    &dto.AuditHead{Records: head.Records, Hash: head.Hash, Signature: head.Signature},
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,110 @@
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/etcdfinder/etcdfinder/internal/api/dto"
+	"github.com/etcdfinder/etcdfinder/internal/changes"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/internal/redact"
+	"github.com/gin-gonic/gin"
+)
+
+// ChangesHandler serves the changes of critical keys awaiting approval
+type ChangesHandler struct {
+	manager  *changes.Manager
+	applier  changes.Applier
+	redactor *redact.Redactor
+}
+
+// NewChangesHandler creates a handler applying the approved changes through applier
+func NewChangesHandler(manager *changes.Manager, applier changes.Applier, redactor *redact.Redactor) *ChangesHandler {
+	return &ChangesHandler{
+		manager:  manager,
+		applier:  applier,
+		redactor: redactor,
+	}
+}
+
+// ListChanges returns the changes on keys the caller may read, most recent first
+func (h *ChangesHandler) ListChanges(c *gin.Context) {
+	var req dto.ListChangesRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.Error(fmt.Errorf("invalid request: %w", err)) //nolint
+		return
+	}
+
+	list, err := h.manager.List(c.Request.Context(), changes.Status(req.Status))
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	resp := dto.ListChangesResponse{Changes: make([]dto.Change, 0, len(list))}
+	for _, change := range list {
+		resp.Changes = append(resp.Changes, h.toChangeDTO(c.Request.Context(), change))
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *ChangesHandler) GetChange(c *gin.Context) {
+	change, err := h.manager.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	c.JSON(http.StatusOK, h.toChangeDTO(c.Request.Context(), change))
+}
+
+// ApproveChange applies the change, unless the key changed since it was requested
+func (h *ChangesHandler) ApproveChange(c *gin.Context) {
+	change, err := h.manager.Approve(c.Request.Context(), h.applier, c.Param("id"))
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	c.JSON(http.StatusOK, h.toChangeDTO(c.Request.Context(), change))
+}
+
+func (h *ChangesHandler) RejectChange(c *gin.Context) {
+	change, err := h.manager.Reject(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	c.JSON(http.StatusOK, h.toChangeDTO(c.Request.Context(), change))
+}
+
+// toChangeDTO converts the change, masking its values and diff if either value is sensitive
+func (h *ChangesHandler) toChangeDTO(ctx context.Context, change *changes.Change) dto.Change {
+	resp := dto.Change{
+		ID:           change.ID,
+		Operation:    string(change.Operation),
+		Key:          change.Key,
+		Value:        change.Value,
+		OldValue:     change.OldValue,
+		BaseRevision: change.BaseRevision,
+		Diff:         change.Diff,
+		Status:       string(change.Status),
+		RequestedBy:  change.RequestedBy,
+		RequestID:    change.RequestID,
+		CreatedAt:    change.CreatedAt,
+		ExpiresAt:    change.ExpiresAt,
+		DecidedBy:    change.DecidedBy,
+		Revision:     change.Revision,
+	}
+	if !change.DecidedAt.IsZero() {
+		resp.DecidedAt = &change.DecidedAt
+	}
+	if (h.redactor.Sensitive(change.Key, change.Value) || h.redactor.Sensitive(change.Key, change.OldValue)) &&
+		!h.redactor.Revealed(ctx, change.Key) {
+		resp.Value, resp.OldValue, resp.Diff = lib.REDACTED_VALUE, lib.REDACTED_VALUE, lib.REDACTED_VALUE
+	}
+	return resp
+}
@@ -3,12 +3,16 @@ package v1
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/etcdfinder/etcdfinder/internal/api/dto"
 	"github.com/etcdfinder/etcdfinder/internal/service"
 	"github.com/gin-gonic/gin"
 )
 
+// period after which a comment is sent on idle watch streams to keep proxies from closing them
+const watchKeepAlivePeriod = 15 * time.Second
+
 type EtcdfinderHandler struct {
 	etcdSvcClt service.Etcdfinder
 }
@@ -55,7 +59,7 @@ func (e *EtcdfinderHandler) SearchKeys(c *gin.Context) {
 		return
 	}
 
-	resp, err := e.etcdSvcClt.SearchKeys(c.Request.Context(), req.SearchStr)
+	resp, err := e.etcdSvcClt.SearchKeys(c.Request.Context(), req.SearchStr, req.Offset, req.Limit)
 	if err != nil {
 		c.Error(err) //nolint
 		return
@@ -66,6 +70,30 @@ func (e *EtcdfinderHandler) SearchKeys(c *gin.Context) {
 	})
 }
 
+func (e *EtcdfinderHandler) ListKeys(c *gin.Context) {
+	var req dto.ListKeysRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(fmt.Errorf("invalid request: %w", err)) //nolint
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	keys, next, err := e.etcdSvcClt.ListKeys(c.Request.Context(), req.Cursor, req.Limit)
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ListKeysResponse{
+		Keys:       keys,
+		NextCursor: next,
+	})
+}
+
 func (e *EtcdfinderHandler) PutKey(c *gin.Context) {
 	var req dto.PutKeyRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -78,7 +106,7 @@ func (e *EtcdfinderHandler) PutKey(c *gin.Context) {
 		return
 	}
 
-	if err := e.etcdSvcClt.PutKey(c.Request.Context(), req.Key, req.Value); err != nil {
+	if _, err := e.etcdSvcClt.PutKey(c.Request.Context(), req.Key, req.Value); err != nil {
 		c.Error(err) //nolint
 		return
 	}
@@ -107,7 +135,96 @@ func (e *EtcdfinderHandler) DeleteKey(c *gin.Context) {
 }
 
 func (e *EtcdfinderHandler) GetIngestionDelay(c *gin.Context) {
-	c.JSON(http.StatusOK, dto.GetIngestionDelayResponse{
-		IngestionDelay: e.etcdSvcClt.GetIngestionDelay(c.Request.Context()),
-	})
+	delay, err := e.etcdSvcClt.GetIngestionDelay(c.Request.Context())
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	resp := dto.GetIngestionDelayResponse{
+		IngestionDelay:  delay.LastLatency.Milliseconds(),
+		AppliedRevision: delay.AppliedRevision,
+		HeadRevision:    delay.HeadRevision,
+		RevisionLag:     delay.RevisionLag,
+		P50Latency:      delay.P50Latency.Milliseconds(),
+		P99Latency:      delay.P99Latency.Milliseconds(),
+		SearchReady:     delay.SearchReady,
+		Degraded:        delay.Health.Degraded,
+		DegradedReason:  delay.Health.Reason,
+	}
+	if !delay.LastAppliedAt.IsZero() {
+		resp.LastAppliedAt = &delay.LastAppliedAt
+	}
+	if delay.Health.Degraded {
+		resp.DegradedSince = &delay.Health.Since
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetReconciliation reports the drift the last run of the reconciler repaired
+func (e *EtcdfinderHandler) GetReconciliation(c *gin.Context) {
+	reconciliation := e.etcdSvcClt.GetReconciliation(c.Request.Context())
+
+	resp := dto.GetReconciliationResponse{
+		Enabled:      reconciliation.Enabled,
+		Interval:     int64(reconciliation.Interval.Seconds()),
+		Runs:         reconciliation.Runs,
+		LastDuration: reconciliation.LastDuration.Milliseconds(),
+		Revision:     reconciliation.Revision,
+		Added:        reconciliation.Drift.Added,
+		Updated:      reconciliation.Drift.Updated,
+		Deleted:      reconciliation.Drift.Deleted,
+		Skipped:      reconciliation.Skipped,
+		Error:        reconciliation.Error,
+	}
+	if !reconciliation.LastRunAt.IsZero() {
+		resp.LastRunAt = &reconciliation.LastRunAt
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// WatchKeys streams key changes as server-sent events until the client disconnects
+func (e *EtcdfinderHandler) WatchKeys(c *gin.Context) {
+	var req dto.WatchKeysRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.Error(fmt.Errorf("invalid request: %w", err)) //nolint
+		return
+	}
+
+	ctx := c.Request.Context()
+	events := e.etcdSvcClt.WatchKeys(ctx, req.Prefix)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("Content-Type", "text/event-stream")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(watchKeepAlivePeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			c.SSEvent("watch", dto.WatchEvent{
+				Type:     event.Type,
+				Key:      event.Key,
+				Value:    event.Value,
+				Revision: event.Revision,
+			})
+			c.Writer.Flush()
+		case <-ticker.C:
+			if _, err := c.Writer.WriteString(": keepalive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-ctx.Done():
+			return
+		}
+	}
 }
@@ -0,0 +1,247 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/etcdfinder/etcdfinder/internal/api/dto"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// maximum size of a value accepted by PUT, etcd rejects requests above 1.5MiB by default
+const maxValueSize = 2 * 1024 * 1024
+
+const mimeOctetStream = "application/octet-stream"
+
+// KeysHandler serves keys as resources addressed by their path, e.g. GET /v2/keys/app/config
+type KeysHandler struct {
+	etcdSvcClt service.Etcdfinder
+}
+
+func NewKeysHandler(etcdSvcClt service.Etcdfinder) *KeysHandler {
+	return &KeysHandler{
+		etcdSvcClt: etcdSvcClt,
+	}
+}
+
+// keyFromPath returns the key addressed by the *path wildcard, which keeps its leading slash
+func keyFromPath(c *gin.Context) (string, error) {
+	key := c.Param("path")
+	if key == "" || key == "/" {
+		return "", customerrors.ErrKeyRequired
+	}
+	return key, nil
+}
+
+// etag returns the entity tag of a key, derived from its mod revision
+func etag(modRevision int64) string {
+	return strconv.Quote(strconv.FormatInt(modRevision, 10))
+}
+
+// parseETags parses an If-Match or If-None-Match header into mod revisions, any is set for "*"
+func parseETags(header string) (revisions []int64, any bool, err error) {
+	for tag := range strings.SplitSeq(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "" {
+			continue
+		}
+		if tag == "*" {
+			any = true
+			continue
+		}
+		rev, err := strconv.ParseInt(strings.Trim(tag, `"`), 10, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid entity tag %s: %w", tag, customerrors.ErrPreconditionFailed)
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, any, nil
+}
+
+func matches(modRevision int64, revisions []int64, any bool) bool {
+	if any {
+		return true
+	}
+	for _, rev := range revisions {
+		if rev == modRevision {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the value of the key, raw or wrapped in JSON depending on the Accept header.
+// It also serves HEAD requests, for which the body is discarded.
+func (k *KeysHandler) Get(c *gin.Context) {
+	key, err := keyFromPath(c)
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	format := c.NegotiateFormat(binding.MIMEPlain, mimeOctetStream, binding.MIMEJSON)
+	if format == "" {
+		c.Error(customerrors.ErrNotAcceptable) //nolint
+		return
+	}
+
+	kv, err := k.etcdSvcClt.GetKeyWithRevision(c.Request.Context(), key)
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	c.Header("ETag", etag(kv.ModRevision))
+	c.Header("Vary", "Accept")
+
+	revisions, any, err := parseETags(c.GetHeader("If-None-Match"))
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+	if matches(kv.ModRevision, revisions, any) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	switch format {
+	case binding.MIMEJSON:
+		c.JSON(http.StatusOK, dto.KeyResponse{
+			Key:         kv.Key,
+			Value:       kv.Value,
+			ModRevision: kv.ModRevision,
+		})
+	case binding.MIMEPlain:
+		c.Data(http.StatusOK, binding.MIMEPlain+"; charset=utf-8", []byte(kv.Value))
+	default:
+		c.Data(http.StatusOK, mimeOctetStream, []byte(kv.Value))
+	}
+}
+
+// Put creates or updates the key from a JSON body or the raw body.
+// If-Match makes the write conditional on the current revision, If-None-Match: * on the key not existing.
+func (k *KeysHandler) Put(c *gin.Context) {
+	key, err := keyFromPath(c)
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	value, err := readValue(c)
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	modRevision, err := k.conditionalRevision(c, key)
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	var newRevision int64
+	if modRevision < 0 {
+		newRevision, err = k.etcdSvcClt.PutKey(c.Request.Context(), key, value)
+	} else {
+		newRevision, err = k.etcdSvcClt.CompareAndPutKey(c.Request.Context(), key, value, modRevision)
+	}
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	c.Header("ETag", etag(newRevision))
+	c.Status(http.StatusNoContent)
+}
+
+// Delete deletes the key, conditionally on its current revision if If-Match is set
+func (k *KeysHandler) Delete(c *gin.Context) {
+	key, err := keyFromPath(c)
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	modRevision, err := k.conditionalRevision(c, key)
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	if modRevision < 0 {
+		err = k.etcdSvcClt.DeleteKey(c.Request.Context(), key)
+	} else {
+		err = k.etcdSvcClt.CompareAndDeleteKey(c.Request.Context(), key, modRevision)
+	}
+	if err != nil {
+		c.Error(err) //nolint
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// conditionalRevision resolves the precondition headers into the mod revision the key must have,
+// 0 if it must not exist, or -1 if the write is unconditional
+func (k *KeysHandler) conditionalRevision(c *gin.Context, key string) (int64, error) {
+	ifMatch, ifMatchAny, err := parseETags(c.GetHeader("If-Match"))
+	if err != nil {
+		return 0, err
+	}
+	ifNoneMatch, ifNoneMatchAny, err := parseETags(c.GetHeader("If-None-Match"))
+	if err != nil {
+		return 0, err
+	}
+
+	// A single entity tag maps directly to a compare-and-swap
+	if len(ifMatch) == 1 && !ifMatchAny && len(ifNoneMatch) == 0 && !ifNoneMatchAny {
+		return ifMatch[0], nil
+	}
+	if ifNoneMatchAny && len(ifMatch) == 0 && !ifMatchAny {
+		return 0, nil
+	}
+	if len(ifMatch) == 0 && !ifMatchAny && len(ifNoneMatch) == 0 && !ifNoneMatchAny {
+		return -1, nil
+	}
+
+	// Otherwise evaluate the preconditions against the current revision and swap on it
+	kv, err := k.etcdSvcClt.GetKeyWithRevision(c.Request.Context(), key)
+	exists := err == nil
+	if err != nil && !errors.Is(err, customerrors.ErrKeyNotFound) {
+		return 0, err
+	}
+
+	if (len(ifMatch) > 0 || ifMatchAny) && (!exists || !matches(kv.ModRevision, ifMatch, ifMatchAny)) {
+		return 0, customerrors.ErrPreconditionFailed
+	}
+	if exists && matches(kv.ModRevision, ifNoneMatch, ifNoneMatchAny) {
+		return 0, customerrors.ErrPreconditionFailed
+	}
+	return kv.ModRevision, nil
+}
+
+// readValue reads the value from a JSON body or, for any other content type, the raw body
+func readValue(c *gin.Context) (string, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxValueSize)
+
+	if c.ContentType() == binding.MIMEJSON {
+		var req dto.PutKeyValueRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			return "", fmt.Errorf("invalid request: %w", err)
+		}
+		return req.Value, nil
+	}
+
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return "", fmt.Errorf("invalid request: %w", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+type Operation string
+
+const (
+	OperationPut    Operation = "put"
+	OperationDelete Operation = "delete"
+)
+
+// Record is an audit log entry describing a mutation made through etcdfinder
+type Record struct {
+	Time         time.Time `json:"time"`
+	RequestID    string    `json:"request_id,omitempty"`
+	Subject      string    `json:"subject,omitempty"`      // empty if authentication is disabled
+	AuthMethod   string    `json:"auth_method,omitempty"`  // how the subject authenticated
+	ChangeID     string    `json:"change_id,omitempty"`    // approved change applied, the subject being its approver
+	RequestedBy  string    `json:"requested_by,omitempty"` // subject that requested the approved change
+	Operation    Operation `json:"operation"`
+	Key          string    `json:"key"`
+	OldValueHash string    `json:"old_value_hash,omitempty"` // HMAC-SHA256 of the previous value, empty if the key did not exist
+	NewValueHash string    `json:"new_value_hash,omitempty"` // HMAC-SHA256 of the new value, empty for deletes
+	PrevRevision int64     `json:"prev_revision,omitempty"`  // mod revision of the previous value
+	Revision     int64     `json:"revision,omitempty"`       // etcd revision of the change
+	PrevHash     string    `json:"prev_hash,omitempty"`      // hash of the previous record, set by chaining sinks
+	Hash         string    `json:"hash,omitempty"`           // hash of this record, set by chaining sinks
+}
+
+// Change describes a mutation to record
+type Change struct {
+	Operation    Operation
+	Key          string
+	OldValue     *string // nil if the key did not exist
+	NewValue     *string // nil for deletes
+	PrevRevision int64
+	Revision     int64
+}
+
+// Sink persists audit records
+type Sink interface {
+	Write(ctx context.Context, record Record) error
+	Close() error
+}
+
+// Querier is implemented by sinks whose records can be read back
+type Querier interface {
+	Query(ctx context.Context, filter Filter) ([]Record, error)
+	// Verify checks the hash chain of the records, returning the number of records checked
+	Verify(ctx context.Context) (int, error)
+	// Head returns the signed head of the records, to be kept elsewhere
+	Head() Head
+}
+
+// Head is the number of records and the hash of the last one, signed with an HMAC so that removing records
+// from the end of the log is detected
+type Head struct {
+	Records   int    `json:"records"`
+	Hash      string `json:"hash,omitempty"`
+	Signature string `json:"signature"`
+}
+
+// Filter selects audit records, zero fields match every record
+type Filter struct {
+	Key       string
+	Subject   string
+	Operation Operation
+	Since     time.Time
+	Until     time.Time
+	Limit     int                 // maximum number of records, the most recent ones are returned
+	Allowed   func(r Record) bool // excludes the records it returns false for, nil allows every record
+}
+
+func (f Filter) matches(r Record) bool {
+	return (f.Key == "" || r.Key == f.Key) &&
+		(f.Subject == "" || r.Subject == f.Subject) &&
+		(f.Operation == "" || r.Operation == f.Operation) &&
+		(f.Since.IsZero() || !r.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || r.Time.Before(f.Until)) &&
+		(f.Allowed == nil || f.Allowed(r))
+}
+
+// Recorder records the changes made by the caller of a request
+type Recorder interface {
+	Record(ctx context.Context, change Change)
+}
+
+type sinkRecorder struct {
+	sink   Sink
+	secret []byte
+}
+
+// NewRecorder creates a recorder writing to the sink. Values are hashed with an HMAC keyed with secret, so that
+// the values of the records cannot be guessed by hashing candidates without it.
+func NewRecorder(sink Sink, secret []byte) Recorder {
+	return &sinkRecorder{sink: sink, secret: secret}
+}
+
+// Record writes the change along with the identity and request ID of ctx. The change has already been
+// applied to etcd by then, so failures to write the record are logged rather than failing the request.
+func (r *sinkRecorder) Record(ctx context.Context, change Change) {
+	record := Record{
+		Time:         time.Now().UTC(),
+		RequestID:    lib.GetRequestID(ctx),
+		Operation:    change.Operation,
+		Key:          change.Key,
+		OldValueHash: r.hashValue(change.OldValue),
+		NewValueHash: r.hashValue(change.NewValue),
+		PrevRevision: change.PrevRevision,
+		Revision:     change.Revision,
+	}
+	if identity := lib.GetIdentity(ctx); identity != nil {
+		record.Subject = identity.Subject
+		record.AuthMethod = string(identity.Method)
+	}
+	if approval := lib.GetApproval(ctx); approval != nil {
+		record.ChangeID = approval.ChangeID
+		record.RequestedBy = approval.RequestedBy
+	}
+
+	if err := r.sink.Write(context.WithoutCancel(ctx), record); err != nil {
+		logger.Errorf("Failed to write audit record for %s %s (request_id=%s): %v", record.Operation, record.Key, record.RequestID, err)
+	}
+}
+
+type noopRecorder struct{}
+
+// NewNoopRecorder returns the recorder used when auditing is disabled
+func NewNoopRecorder() Recorder {
+	return noopRecorder{}
+}
+
+func (noopRecorder) Record(context.Context, Change) {}
+
+func (r *sinkRecorder) hashValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	mac := hmac.New(sha256.New, r.secret)
+	mac.Write([]byte(*value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,281 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+)
+
+// maximum size of a record line, records hold hashes rather than values so they stay small
+const maxRecordSize = 1 << 20
+
+// FileSink appends records as JSON lines to a file. Every record holds the hash of the previous one,
+// so that removing or modifying a record breaks the chain from that record on. The head of the chain is
+// signed into a file next to the log after every record, so that removing the last records is detected too.
+type FileSink struct {
+	path   string
+	secret []byte
+
+	mu   sync.Mutex
+	file *os.File
+	head Head
+}
+
+// NewFileSink opens the audit log at path, creating it if needed, and resumes its hash chain. It fails if the
+// log does not contain its signed head, as records were removed from its end.
+func NewFileSink(path string, secret []byte) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	s := &FileSink{path: path, secret: secret, file: file}
+	if err := s.resume(); err != nil {
+		file.Close() //nolint
+		return nil, err
+	}
+	return s, nil
+}
+
+// resume continues the chain from the last record of the log. The log may hold records past its head, written
+// before the head was updated.
+func (s *FileSink) resume() error {
+	stored, err := s.readHead()
+	if err != nil {
+		return err
+	}
+	var count int
+	var lastHash, headHash string
+	err = s.scan(func(r Record) error {
+		count++
+		lastHash = r.Hash
+		if stored != nil && count == stored.Records {
+			headHash = r.Hash
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.checkHead(stored, count, headHash); err != nil {
+		return err
+	}
+
+	s.head = s.sign(count, lastHash)
+	return s.writeHead()
+}
+
+func (s *FileSink) Write(ctx context.Context, record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record.PrevHash = s.head.Hash
+	hash, err := recordHash(record)
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+
+	s.head = s.sign(s.head.Records+1, hash)
+	return s.writeHead()
+}
+
+// Head returns the signed head of the records written
+func (s *FileSink) Head() Head {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.head
+}
+
+func (s *FileSink) Query(ctx context.Context, filter Filter) ([]Record, error) {
+	var records []Record
+	err := s.scan(func(r Record) error {
+		if !filter.matches(r) {
+			return nil
+		}
+		records = append(records, r)
+		if filter.Limit > 0 && len(records) > filter.Limit {
+			records = records[1:]
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Most recent first
+	slices.Reverse(records)
+	return records, nil
+}
+
+// Verify checks the hash chain of the records and that the log contains its signed head
+func (s *FileSink) Verify(ctx context.Context) (int, error) {
+	// The head is read first, records are appended to the log before its head is updated
+	stored, err := s.readHead()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	var headHash string
+	prevHash := ""
+	err = s.scan(func(r Record) error {
+		count++
+		if stored != nil && count == stored.Records {
+			headHash = r.Hash
+		}
+		if r.PrevHash != prevHash {
+			return fmt.Errorf("audit record %d does not follow the previous record", count)
+		}
+		hash, err := recordHash(r)
+		if err != nil {
+			return err
+		}
+		if hash != r.Hash {
+			return fmt.Errorf("audit record %d was modified", count)
+		}
+		prevHash = r.Hash
+		return ctx.Err()
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, s.checkHead(stored, count, headHash)
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// checkHead checks the signature of the head read from its file, and that the log of count records contains it,
+// headHash being the hash of the record at the position of the head. A missing head is only valid for an empty log.
+func (s *FileSink) checkHead(stored *Head, count int, headHash string) error {
+	if stored == nil {
+		if count > 0 {
+			return fmt.Errorf("audit log has %d records but no signed head", count)
+		}
+		return nil
+	}
+	if !hmac.Equal([]byte(stored.Signature), []byte(s.sign(stored.Records, stored.Hash).Signature)) {
+		return fmt.Errorf("audit head has an invalid signature")
+	}
+	if count < stored.Records {
+		return fmt.Errorf("audit log ends at record %d before its signed head at record %d, records were removed", count, stored.Records)
+	}
+	if stored.Records > 0 && headHash != stored.Hash {
+		return fmt.Errorf("audit record %d does not match the signed head", stored.Records)
+	}
+	return nil
+}
+
+// sign returns the head of the log of count records, the last one having hash
+func (s *FileSink) sign(count int, hash string) Head {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%d:%s", count, hash)
+	return Head{Records: count, Hash: hash, Signature: hex.EncodeToString(mac.Sum(nil))}
+}
+
+// headPath is the file holding the signed head of the log
+func (s *FileSink) headPath() string {
+	return s.path + ".head"
+}
+
+// readHead reads the signed head of the log, nil if it was never written
+func (s *FileSink) readHead() (*Head, error) {
+	data, err := os.ReadFile(s.headPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit head: %w", err)
+	}
+	var head Head
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, fmt.Errorf("malformed audit head: %w", err)
+	}
+	return &head, nil
+}
+
+// writeHead replaces the head file with the current head, through a rename so that it is never partially written
+func (s *FileSink) writeHead() error {
+	data, err := json.Marshal(s.head)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit head: %w", err)
+	}
+	tmp := s.headPath() + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write audit head: %w", err)
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write audit head: %w", err)
+	}
+	if err := os.Rename(tmp, s.headPath()); err != nil {
+		return fmt.Errorf("failed to write audit head: %w", err)
+	}
+	return nil
+}
+
+// scan calls fn with every record of the file, in order
+func (s *FileSink) scan(fn func(Record) error) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close() //nolint
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRecordSize)
+	for line := 1; scanner.Scan(); line++ {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf("malformed audit record on line %d: %w", line, err)
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return nil
+}
+
+// recordHash returns the SHA-256 of the record without its own hash, which includes the hash of the previous record
+func recordHash(r Record) (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
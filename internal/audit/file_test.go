@@ -0,0 +1,223 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("audit-secret")
+
+// writeRecords appends records for the keys to the sink
+func writeRecords(t *testing.T, sink *FileSink, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		record := Record{Time: time.Now().UTC(), Subject: "alice", Operation: OperationPut, Key: key}
+		if err := sink.Write(context.Background(), record); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+}
+
+// newLog writes a log of the records for the keys and returns its path
+func newLog(t *testing.T, keys ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path, testSecret)
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	writeRecords(t, sink, keys...)
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return path
+}
+
+// rewrite replaces the lines of the log with the result of edit
+func rewrite(t *testing.T, path string, edit func(lines []string) []string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	content := strings.Join(edit(lines), "\n") + "\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write log: %v", err)
+	}
+}
+
+func TestFileSinkChain(t *testing.T) {
+	path := newLog(t, "/a", "/b")
+
+	// Reopening continues the chain from the last record
+	sink, err := NewFileSink(path, testSecret)
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	defer sink.Close() //nolint
+	writeRecords(t, sink, "/c")
+
+	count, err := sink.Verify(context.Background())
+	if err != nil || count != 3 {
+		t.Fatalf("Verify = %d, %v, want 3 valid records", count, err)
+	}
+	records, err := sink.Query(context.Background(), Filter{})
+	if err != nil || len(records) != 3 {
+		t.Fatalf("Query = %d records, %v", len(records), err)
+	}
+	// Most recent first, every record follows the previous one
+	if records[0].Key != "/c" || records[0].PrevHash != records[1].Hash || records[1].PrevHash != records[2].Hash || records[2].PrevHash != "" {
+		t.Errorf("records are not chained: %+v", records)
+	}
+	if head := sink.Head(); head.Records != 3 || head.Hash != records[0].Hash || head.Signature == "" {
+		t.Errorf("Head = %+v, want the last of 3 records", head)
+	}
+
+	limited, err := sink.Query(context.Background(), Filter{Key: "/b", Limit: 1})
+	if err != nil || len(limited) != 1 || limited[0].Key != "/b" {
+		t.Errorf("Query(/b) = %+v, %v", limited, err)
+	}
+}
+
+func TestFileSinkTampering(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(t *testing.T, path string)
+		wantErr string // error of the verification, and of reopening if set
+		reopens bool   // whether the sink still opens, the chain being broken before its head
+	}{
+		{name: "record edited", reopens: true, wantErr: "record 2 was modified", tamper: func(t *testing.T, path string) {
+			rewrite(t, path, func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], `"subject":"alice"`, `"subject":"mallory"`, 1)
+				return lines
+			})
+		}},
+		{name: "record removed", wantErr: "records were removed", tamper: func(t *testing.T, path string) {
+			rewrite(t, path, func(lines []string) []string { return append(lines[:1], lines[2:]...) })
+		}},
+		{name: "last records removed", wantErr: "records were removed", tamper: func(t *testing.T, path string) {
+			rewrite(t, path, func(lines []string) []string { return lines[:1] })
+		}},
+		{name: "last record edited", reopens: true, wantErr: "record 3 was modified", tamper: func(t *testing.T, path string) {
+			rewrite(t, path, func(lines []string) []string {
+				lines[2] = strings.Replace(lines[2], `"key":"/c"`, `"key":"/x"`, 1)
+				return lines
+			})
+		}},
+		{name: "last record replaced with a chained record", wantErr: "does not match the signed head", tamper: func(t *testing.T, path string) {
+			rewrite(t, path, func(lines []string) []string {
+				var r Record
+				if err := json.Unmarshal([]byte(lines[2]), &r); err != nil {
+					t.Fatalf("malformed record: %v", err)
+				}
+				r.Key = "/x"
+				r.Hash, _ = recordHash(r)
+				line, _ := json.Marshal(r)
+				lines[2] = string(line)
+				return lines
+			})
+		}},
+		{name: "head removed", wantErr: "no signed head", tamper: func(t *testing.T, path string) {
+			if err := os.Remove(path + ".head"); err != nil {
+				t.Fatalf("failed to remove head: %v", err)
+			}
+		}},
+		{name: "head forged", wantErr: "invalid signature", tamper: func(t *testing.T, path string) {
+			if err := os.WriteFile(path+".head", []byte(`{"records":1,"hash":"00","signature":"00"}`), 0o600); err != nil {
+				t.Fatalf("failed to write head: %v", err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := newLog(t, "/a", "/b", "/c")
+			tt.tamper(t, path)
+
+			sink, err := NewFileSink(path, testSecret)
+			if !tt.reopens {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewFileSink = %v, want an error about %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewFileSink: %v", err)
+			}
+			defer sink.Close() //nolint
+			if _, err := sink.Verify(context.Background()); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Verify = %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Records written before their head, e.g. by a process stopped in between, extend the chain
+func TestFileSinkRecordPastHead(t *testing.T) {
+	path := newLog(t, "/a", "/b")
+	head, err := os.ReadFile(path + ".head")
+	if err != nil {
+		t.Fatalf("failed to read head: %v", err)
+	}
+	sink, err := NewFileSink(path, testSecret)
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	writeRecords(t, sink, "/c")
+	sink.Close() //nolint
+	if err := os.WriteFile(path+".head", head, 0o600); err != nil {
+		t.Fatalf("failed to restore head: %v", err)
+	}
+
+	sink, err = NewFileSink(path, testSecret)
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	defer sink.Close() //nolint
+	if count, err := sink.Verify(context.Background()); err != nil || count != 3 {
+		t.Errorf("Verify = %d, %v, want 3 valid records", count, err)
+	}
+	if head := sink.Head(); head.Records != 3 {
+		t.Errorf("Head = %+v, want 3 records", head)
+	}
+}
+
+func TestRecorderHashesValuesWithSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path, testSecret)
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	defer sink.Close() //nolint
+
+	old, value := "hunter2", "hunter3"
+	NewRecorder(sink, []byte("secret-1")).Record(context.Background(), Change{Operation: OperationPut, Key: "/a", OldValue: &old, NewValue: &value})
+	NewRecorder(sink, []byte("secret-2")).Record(context.Background(), Change{Operation: OperationPut, Key: "/a", OldValue: &old, NewValue: &value})
+	NewRecorder(sink, []byte("secret-2")).Record(context.Background(), Change{Operation: OperationDelete, Key: "/a", OldValue: &value})
+
+	records, err := sink.Query(context.Background(), Filter{})
+	if err != nil || len(records) != 3 {
+		t.Fatalf("Query = %d records, %v", len(records), err)
+	}
+	deleted, second, first := records[0], records[1], records[2]
+	if first.NewValueHash == "" || first.NewValueHash == first.OldValueHash {
+		t.Errorf("values were not hashed: %+v", first)
+	}
+	if first.NewValueHash == second.NewValueHash {
+		t.Errorf("the same value has the same hash with different secrets")
+	}
+	if deleted.OldValueHash != second.NewValueHash || deleted.NewValueHash != "" {
+		t.Errorf("delete hashes = %q, %q, want the hash of the put value and none", deleted.OldValueHash, deleted.NewValueHash)
+	}
+	// The unsalted SHA-256 of a value is not recorded
+	if sum := sha256.Sum256([]byte(value)); first.NewValueHash == hex.EncodeToString(sum[:]) {
+		t.Errorf("value hash is the SHA-256 of the value")
+	}
+}
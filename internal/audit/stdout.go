@@ -0,0 +1,36 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// WriterSink writes records as JSON lines to a writer, typically stdout to be collected with the container logs
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewStdoutSink() *WriterSink {
+	return &WriterSink{w: os.Stdout}
+}
+
+func (s *WriterSink) Write(ctx context.Context, record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+func (s *WriterSink) Close() error {
+	return nil
+}
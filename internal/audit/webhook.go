@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const defaultWebhookTimeout = 5 * time.Second
+
+// WebhookSink posts every record as JSON to a URL
+type WebhookSink struct {
+	url        string
+	headers    map[string]string
+	httpClient *http.Client
+}
+
+func NewWebhookSink(url string, headers map[string]string, timeout time.Duration) (*WebhookSink, error) {
+	if url == "" {
+		return nil, fmt.Errorf("audit webhook url is required")
+	}
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	return &WebhookSink{
+		url:        url,
+		headers:    headers,
+		httpClient: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (s *WebhookSink) Write(ctx context.Context, record Record) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create audit webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("audit webhook request failed: %w", err)
+	}
+	defer resp.Body.Close() //nolint
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("audit webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *WebhookSink) Close() error {
+	return nil
+}
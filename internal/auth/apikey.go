@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+)
+
+type apiKey struct {
+	hash     []byte
+	identity *lib.Identity
+}
+
+// apiKeyStore holds the SHA-256 hashes of the configured API keys, the keys themselves are never stored
+type apiKeyStore struct {
+	keys []apiKey
+}
+
+func newAPIKeyStore(keys []config.APIKeyConfig) (*apiKeyStore, error) {
+	s := &apiKeyStore{}
+	names := map[string]bool{}
+
+	for _, k := range keys {
+		if k.Name == "" {
+			return nil, fmt.Errorf("api key without a name")
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("duplicate api key name %q", k.Name)
+		}
+		names[k.Name] = true
+
+		hash, err := hex.DecodeString(k.SHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("api key %q: sha256 must be a hex encoded SHA-256 hash", k.Name)
+		}
+
+		s.keys = append(s.keys, apiKey{
+			hash: hash,
+			identity: &lib.Identity{
+				Subject: k.Name,
+				Groups:  k.Groups,
+				Method:  lib.AUTH_METHOD_API_KEY,
+			},
+		})
+	}
+
+	return s, nil
+}
+
+// lookup returns the identity of the API key, comparing its hash against every configured key in constant time
+func (s *apiKeyStore) lookup(key string) (*lib.Identity, error) {
+	hash := sha256.Sum256([]byte(key))
+
+	var identity *lib.Identity
+	for _, k := range s.keys {
+		if subtle.ConstantTimeCompare(hash[:], k.hash) == 1 {
+			identity = k.identity
+		}
+	}
+	if identity == nil {
+		return nil, fmt.Errorf("invalid api key: %w", customerrors.ErrUnauthenticated)
+	}
+	return identity, nil
+}
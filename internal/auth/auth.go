@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+// Authenticator resolves the credentials presented with a request to the identity of the caller
+type Authenticator struct {
+	apiKeys    *apiKeyStore
+	jwt        *jwtVerifier // nil if bearer tokens are not accepted
+	oidc       *oidcLogin   // nil if users cannot log in through OIDC
+	signer     *signer      // signs the session and login state cookies
+	sessionTTL time.Duration
+}
+
+// NewAuthenticator creates an authenticator accepting the configured API keys and, if a JWKS is configured, bearer tokens.
+// If an OIDC issuer is configured, users can also log in with their browser and are identified by a session cookie.
+func NewAuthenticator(ctx context.Context, conf config.AuthConfig) (*Authenticator, error) {
+	apiKeys, err := newAPIKeyStore(conf.APIKeys)
+	if err != nil {
+		return nil, err
+	}
+	a := &Authenticator{apiKeys: apiKeys}
+
+	if conf.JWT.JWKSFile != "" || conf.JWT.JWKSURL != "" {
+		a.jwt, err = newJWTVerifier(ctx, conf.JWT.JWKSFile, conf.JWT.JWKSURL,
+			time.Duration(conf.JWT.JWKSRefreshInterval)*time.Second,
+			conf.JWT.Issuer, conf.JWT.Audience, conf.JWT.GroupsClaim)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.OIDC.IssuerURL != "" {
+		a.oidc, err = newOIDCLogin(ctx, conf.OIDC)
+		if err != nil {
+			return nil, err
+		}
+
+		var persistent bool
+		a.signer, persistent, err = newSigner(conf.OIDC.SessionSecret)
+		if err != nil {
+			return nil, err
+		}
+		if !persistent {
+			logger.Warnf("No oidc session_secret configured, sessions are lost on restart and not shared between instances")
+		}
+
+		a.sessionTTL = time.Duration(conf.OIDC.SessionTTL) * time.Second
+		if a.sessionTTL <= 0 {
+			a.sessionTTL = defaultSessionTTL
+		}
+	}
+
+	return a, nil
+}
+
+// AuthenticateAPIKey returns the identity the API key was issued to
+func (a *Authenticator) AuthenticateAPIKey(key string) (*lib.Identity, error) {
+	return a.apiKeys.lookup(key)
+}
+
+// AuthenticateToken validates the bearer token and returns the identity of its subject.
+// Tokens that are not JWTs are looked up as API keys, so that clients only able to send
+// an Authorization header can still use them.
+func (a *Authenticator) AuthenticateToken(ctx context.Context, token string) (*lib.Identity, error) {
+	if strings.Count(token, ".") != 2 {
+		return a.apiKeys.lookup(token)
+	}
+	if a.jwt == nil {
+		return nil, fmt.Errorf("bearer tokens are not accepted: %w", customerrors.ErrUnauthenticated)
+	}
+	return a.jwt.verify(ctx, token)
+}
@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultJWKSRefreshInterval = time.Hour
+	// minimum time between two fetches of the JWKS, bounds the fetches triggered by tokens with unknown key IDs
+	minJWKSRefreshInterval = time.Minute
+	jwksFetchTimeout       = 10 * time.Second
+	maxJWKSSize            = 1 << 20
+	defaultGroupsClaim     = "groups"
+)
+
+// signing algorithms accepted in tokens, "none" and HMAC are never accepted as the keys are public
+var validMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// jwtVerifier validates bearer tokens against the keys of a JWKS read from a file or fetched from a URL
+type jwtVerifier struct {
+	jwksFile        string
+	jwksURL         string
+	refreshInterval time.Duration
+	groupsClaim     string
+	parser          *jwt.Parser
+	httpClient      *http.Client
+
+	mu        sync.Mutex
+	keys      jose.JSONWebKeySet
+	fetchedAt time.Time
+}
+
+func newJWTVerifier(ctx context.Context, jwksFile, jwksURL string, refreshInterval time.Duration, issuer, audience, groupsClaim string) (*jwtVerifier, error) {
+	if jwksFile != "" && jwksURL != "" {
+		return nil, fmt.Errorf("only one of jwks_file and jwks_url can be set")
+	}
+	if refreshInterval <= 0 {
+		refreshInterval = defaultJWKSRefreshInterval
+	}
+	if groupsClaim == "" {
+		groupsClaim = defaultGroupsClaim
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(validMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		opts = append(opts, jwt.WithAudience(audience))
+	}
+
+	v := &jwtVerifier{
+		jwksFile:        jwksFile,
+		jwksURL:         jwksURL,
+		refreshInterval: refreshInterval,
+		groupsClaim:     groupsClaim,
+		parser:          jwt.NewParser(opts...),
+		httpClient:      &http.Client{Timeout: jwksFetchTimeout},
+	}
+
+	// Fail at startup rather than rejecting every token if the JWKS cannot be loaded
+	keys, err := v.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v.keys = keys
+	v.fetchedAt = time.Now()
+
+	return v, nil
+}
+
+// verify validates the signature and claims of the token and returns the identity of its subject
+func (v *jwtVerifier) verify(ctx context.Context, token string) (*lib.Identity, error) {
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w: %w", err, customerrors.ErrUnauthenticated)
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return nil, fmt.Errorf("invalid token: no subject: %w", customerrors.ErrUnauthenticated)
+	}
+	email, _ := claims["email"].(string)
+	issuer, _ := claims.GetIssuer()
+
+	return &lib.Identity{
+		Subject: subject,
+		Email:   email,
+		Groups:  stringsClaim(claims[v.groupsClaim]),
+		Method:  lib.AUTH_METHOD_JWT,
+		Issuer:  issuer,
+	}, nil
+}
+
+// key returns the public key with the key ID, refreshing the JWKS when it is stale or does not hold the key
+func (v *jwtVerifier) key(ctx context.Context, kid string) (any, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if time.Since(v.fetchedAt) > v.refreshInterval {
+		v.refresh(ctx)
+	}
+
+	key, ok := findKey(v.keys, kid)
+	if !ok && time.Since(v.fetchedAt) > minJWKSRefreshInterval {
+		// The issuer may have rotated its keys
+		v.refresh(ctx)
+		key, ok = findKey(v.keys, kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key.Key, nil
+}
+
+// refresh reloads the JWKS, keeping the current keys if it fails. Callers must hold mu.
+func (v *jwtVerifier) refresh(ctx context.Context) {
+	v.fetchedAt = time.Now()
+
+	keys, err := v.load(ctx)
+	if err != nil {
+		logger.Errorf("Failed to refresh JWKS, keeping the current keys: %v", err)
+		return
+	}
+	v.keys = keys
+}
+
+func (v *jwtVerifier) load(ctx context.Context) (jose.JSONWebKeySet, error) {
+	var keys jose.JSONWebKeySet
+
+	var data []byte
+	var err error
+	if v.jwksFile != "" {
+		data, err = os.ReadFile(v.jwksFile)
+	} else {
+		data, err = v.fetch(ctx)
+	}
+	if err != nil {
+		return keys, fmt.Errorf("failed to load jwks: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return keys, fmt.Errorf("failed to parse jwks: %w", err)
+	}
+	for _, key := range keys.Keys {
+		if !key.IsPublic() {
+			return keys, fmt.Errorf("jwks key %q is not a public key", key.KeyID)
+		}
+	}
+	return keys, nil
+}
+
+func (v *jwtVerifier) fetch(ctx context.Context) ([]byte, error) {
+	// Do not let a cancelled request abort a fetch whose result is shared by all requests
+	ctx = context.WithoutCancel(ctx)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
+
+// findKey returns the signing key with the key ID, or the only key of the set for tokens without one
+func findKey(keys jose.JSONWebKeySet, kid string) (jose.JSONWebKey, bool) {
+	if kid == "" {
+		if len(keys.Keys) == 1 {
+			return keys.Keys[0], true
+		}
+		return jose.JSONWebKey{}, false
+	}
+	for _, key := range keys.Key(kid) {
+		if key.Use == "" || key.Use == "sig" {
+			return key, true
+		}
+	}
+	return jose.JSONWebKey{}, false
+}
+
+// stringsClaim returns a claim holding either a string or a list of strings as a list
+func stringsClaim(claim any) []string {
+	switch c := claim.(type) {
+	case string:
+		return []string{c}
+	case []any:
+		values := make([]string, 0, len(c))
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"golang.org/x/oauth2"
+)
+
+const (
+	defaultSessionTTL = 8 * time.Hour
+	// LoginStateTTL is the time a user has to complete the login at the provider
+	LoginStateTTL = 10 * time.Minute
+	// minimum length of the session secret, shorter secrets could be brute forced from a session cookie
+	minSessionSecretLength = 32
+)
+
+// oidcLogin runs the authorization code flow with PKCE against an OIDC provider
+type oidcLogin struct {
+	oauth2      oauth2.Config
+	verifier    *oidc.IDTokenVerifier
+	groupsClaim string
+}
+
+// loginState is the content of the login state cookie
+type loginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Redirect string `json:"redirect"`
+	Expiry   int64  `json:"exp"` // unix seconds
+}
+
+// newOIDCLogin discovers the provider at the issuer URL, which must serve /.well-known/openid-configuration
+func newOIDCLogin(ctx context.Context, conf config.OIDCConfig) (*oidcLogin, error) {
+	if conf.ClientID == "" || conf.RedirectURL == "" {
+		return nil, fmt.Errorf("oidc client_id and redirect_url are required")
+	}
+
+	provider, err := oidc.NewProvider(ctx, conf.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover oidc provider %s: %w", conf.IssuerURL, err)
+	}
+
+	scopes := conf.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	groupsClaim := conf.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = defaultGroupsClaim
+	}
+
+	return &oidcLogin{
+		oauth2: oauth2.Config{
+			ClientID:     conf.ClientID,
+			ClientSecret: conf.ClientSecret,
+			RedirectURL:  conf.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier:    provider.Verifier(&oidc.Config{ClientID: conf.ClientID}),
+		groupsClaim: groupsClaim,
+	}, nil
+}
+
+// OIDCEnabled returns whether users can log in through an OIDC provider
+func (a *Authenticator) OIDCEnabled() bool {
+	return a.oidc != nil
+}
+
+// SessionTTL returns how long sessions are valid
+func (a *Authenticator) SessionTTL() time.Duration {
+	return a.sessionTTL
+}
+
+// LoginURL returns the URL of the provider to send the user to, and the signed state to keep in the
+// login state cookie until the callback. redirect is where the user is sent back after the login.
+func (a *Authenticator) LoginURL(redirect string) (string, string, error) {
+	state := loginState{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: oauth2.GenerateVerifier(),
+		Redirect: redirect,
+		Expiry:   time.Now().Add(LoginStateTTL).Unix(),
+	}
+	cookie, err := a.signer.encode(loginStatePurpose, state)
+	if err != nil {
+		return "", "", err
+	}
+
+	url := a.oidc.oauth2.AuthCodeURL(state.State, oidc.Nonce(state.Nonce), oauth2.S256ChallengeOption(state.Verifier))
+	return url, cookie, nil
+}
+
+// Callback completes the login, exchanging the code for an ID token, and returns the session cookie
+// value of the user along with the redirect given to LoginURL
+func (a *Authenticator) Callback(ctx context.Context, code, state, stateCookie string) (string, string, error) {
+	var login loginState
+	if err := a.signer.decode(loginStatePurpose, stateCookie, &login); err != nil {
+		return "", "", fmt.Errorf("invalid login state: %w: %w", err, customerrors.ErrUnauthenticated)
+	}
+	if time.Now().Unix() >= login.Expiry {
+		return "", "", fmt.Errorf("login expired: %w", customerrors.ErrUnauthenticated)
+	}
+	if state == "" || state != login.State {
+		return "", "", fmt.Errorf("login state mismatch: %w", customerrors.ErrUnauthenticated)
+	}
+
+	token, err := a.oidc.oauth2.Exchange(ctx, code, oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to exchange code: %w: %w", err, customerrors.ErrUnauthenticated)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", "", fmt.Errorf("no id_token in token response: %w", customerrors.ErrUnauthenticated)
+	}
+	idToken, err := a.oidc.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid id_token: %w: %w", err, customerrors.ErrUnauthenticated)
+	}
+	if idToken.Nonce != login.Nonce {
+		return "", "", fmt.Errorf("id_token nonce mismatch: %w", customerrors.ErrUnauthenticated)
+	}
+
+	claims := map[string]any{}
+	if err := idToken.Claims(&claims); err != nil {
+		return "", "", fmt.Errorf("invalid id_token claims: %w: %w", err, customerrors.ErrUnauthenticated)
+	}
+	email, _ := claims["email"].(string)
+	identity := &lib.Identity{
+		Subject: idToken.Subject,
+		Email:   email,
+		Groups:  stringsClaim(claims[a.oidc.groupsClaim]),
+		Method:  lib.AUTH_METHOD_SESSION,
+		Issuer:  idToken.Issuer,
+	}
+
+	// The session does not outlive the ID token it was created from
+	expiry := time.Now().Add(a.sessionTTL)
+	if idToken.Expiry.Before(expiry) && !idToken.Expiry.IsZero() {
+		expiry = idToken.Expiry
+	}
+	session, err := a.signer.encodeSession(identity, expiry)
+	if err != nil {
+		return "", "", err
+	}
+	return session, login.Redirect, nil
+}
+
+// AuthenticateSession returns the identity of the user the session cookie was issued to
+func (a *Authenticator) AuthenticateSession(cookie string) (*lib.Identity, error) {
+	if a.oidc == nil {
+		return nil, fmt.Errorf("sessions are not accepted: %w", customerrors.ErrUnauthenticated)
+	}
+	return a.signer.decodeSession(cookie)
+}
+
+// newSigner returns a signer keyed with the session secret, or with a random key if none is configured
+func newSigner(secret string) (*signer, bool, error) {
+	if secret == "" {
+		key := make([]byte, minSessionSecretLength)
+		rand.Read(key) //nolint
+		return &signer{key: key}, false, nil
+	}
+	if len(secret) < minSessionSecretLength {
+		return nil, false, fmt.Errorf("oidc session_secret must be at least %d characters", minSessionSecretLength)
+	}
+	return &signer{key: []byte(secret)}, true, nil
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b) //nolint
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// IsLocalRedirect returns whether the redirect is a path on this server, so that the login
+// cannot be used to send users to another site
+func IsLocalRedirect(redirect string) bool {
+	return strings.HasPrefix(redirect, "/") && !strings.HasPrefix(redirect, "//") && !strings.HasPrefix(redirect, "/\\")
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+)
+
+const (
+	// SessionCookie holds the identity of users logged in through OIDC
+	SessionCookie = "etcdfinder_session"
+	// LoginStateCookie holds the state of a login in progress, between the redirect to the provider and the callback
+	LoginStateCookie = "etcdfinder_login"
+
+	sessionPurpose    = "session"
+	loginStatePurpose = "login"
+)
+
+// signer signs cookie values with HMAC-SHA256. The purpose is part of the signed data,
+// so that a value signed for one cookie is rejected when presented as another.
+type signer struct {
+	key []byte
+}
+
+func (s *signer) encode(purpose string, v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(s.mac(purpose, payload)), nil
+}
+
+func (s *signer) decode(purpose string, value string, v any) error {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return errors.New("malformed cookie")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, s.mac(purpose, payload)) {
+		return errors.New("invalid cookie signature")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return errors.New("malformed cookie")
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (s *signer) mac(purpose string, payload string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(purpose + "." + payload)) //nolint
+	return h.Sum(nil)
+}
+
+// session is the content of the session cookie
+type session struct {
+	Subject string   `json:"sub"`
+	Email   string   `json:"email,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	Issuer  string   `json:"iss,omitempty"`
+	Expiry  int64    `json:"exp"` // unix seconds
+}
+
+func (s *signer) encodeSession(identity *lib.Identity, expiry time.Time) (string, error) {
+	return s.encode(sessionPurpose, session{
+		Subject: identity.Subject,
+		Email:   identity.Email,
+		Groups:  identity.Groups,
+		Issuer:  identity.Issuer,
+		Expiry:  expiry.Unix(),
+	})
+}
+
+func (s *signer) decodeSession(value string) (*lib.Identity, error) {
+	var sess session
+	if err := s.decode(sessionPurpose, value, &sess); err != nil {
+		return nil, fmt.Errorf("invalid session: %w: %w", err, customerrors.ErrUnauthenticated)
+	}
+	if time.Now().Unix() >= sess.Expiry {
+		return nil, fmt.Errorf("session expired: %w", customerrors.ErrUnauthenticated)
+	}
+	return &lib.Identity{
+		Subject: sess.Subject,
+		Email:   sess.Email,
+		Groups:  sess.Groups,
+		Method:  lib.AUTH_METHOD_SESSION,
+		Issuer:  sess.Issuer,
+	}, nil
+}
@@ -0,0 +1,169 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+)
+
+type Permission string
+
+const (
+	PermissionRead   Permission = "read"
+	PermissionSearch Permission = "search"
+	PermissionWrite  Permission = "write"
+	PermissionDelete Permission = "delete"
+	PermissionReveal Permission = "reveal" // read the values of keys matched by redaction rules
+)
+
+var permissions = []Permission{PermissionRead, PermissionSearch, PermissionWrite, PermissionDelete, PermissionReveal}
+
+// Authorizer decides which keys the caller of a request may access
+type Authorizer interface {
+	// Authorize returns ErrForbidden unless the caller is granted the permission on the key
+	Authorize(ctx context.Context, permission Permission, key string) error
+	// Allowed returns whether the caller is granted the permission on the key
+	Allowed(ctx context.Context, permission Permission, key string) bool
+	// Unrestricted returns whether the caller is granted the permission on every key
+	Unrestricted(ctx context.Context, permission Permission) bool
+}
+
+// allowAll is the authorizer used when authorization is disabled
+type allowAll struct{}
+
+func NewAllowAll() Authorizer {
+	return allowAll{}
+}
+
+func (allowAll) Authorize(context.Context, Permission, string) error { return nil }
+func (allowAll) Allowed(context.Context, Permission, string) bool    { return true }
+func (allowAll) Unrestricted(context.Context, Permission) bool       { return true }
+
+// grant gives permissions on the keys starting with prefix, or matching glob if set
+type grant struct {
+	prefix      string
+	glob        *regexp.Regexp
+	all         bool // the glob is **, matching every key
+	permissions []Permission
+}
+
+func (g grant) matches(key string) bool {
+	if g.glob != nil {
+		return g.glob.MatchString(key)
+	}
+	return strings.HasPrefix(key, g.prefix)
+}
+
+// Policy grants the permissions of roles to the groups and subjects they are bound to
+type Policy struct {
+	roles        map[string][]grant
+	groupRoles   map[string][]string
+	subjectRoles map[string][]string
+}
+
+func NewPolicy(conf config.AuthzConfig) (*Policy, error) {
+	p := &Policy{
+		roles:        map[string][]grant{},
+		groupRoles:   map[string][]string{},
+		subjectRoles: map[string][]string{},
+	}
+
+	for _, role := range conf.Roles {
+		if role.Name == "" {
+			return nil, fmt.Errorf("role without a name")
+		}
+		if _, ok := p.roles[role.Name]; ok {
+			return nil, fmt.Errorf("duplicate role %q", role.Name)
+		}
+
+		grants := []grant{}
+		for _, g := range role.Grants {
+			if (g.Prefix == "") == (g.Glob == "") {
+				return nil, fmt.Errorf("role %q: a grant must set exactly one of prefix and glob", role.Name)
+			}
+			parsed := grant{prefix: g.Prefix, all: g.Glob == "**"}
+			if g.Glob != "" {
+				var err error
+				if parsed.glob, err = lib.CompileGlob(g.Glob); err != nil {
+					return nil, fmt.Errorf("role %q: invalid glob %q: %w", role.Name, g.Glob, err)
+				}
+			}
+			for _, perm := range g.Permissions {
+				if !slices.Contains(permissions, Permission(perm)) {
+					return nil, fmt.Errorf("role %q: unknown permission %q", role.Name, perm)
+				}
+				parsed.permissions = append(parsed.permissions, Permission(perm))
+			}
+			grants = append(grants, parsed)
+		}
+		p.roles[role.Name] = grants
+	}
+
+	for _, binding := range conf.Bindings {
+		if _, ok := p.roles[binding.Role]; !ok {
+			return nil, fmt.Errorf("binding to unknown role %q", binding.Role)
+		}
+		for _, group := range binding.Groups {
+			p.groupRoles[group] = append(p.groupRoles[group], binding.Role)
+		}
+		for _, subject := range binding.Subjects {
+			p.subjectRoles[subject] = append(p.subjectRoles[subject], binding.Role)
+		}
+	}
+
+	return p, nil
+}
+
+func (p *Policy) Authorize(ctx context.Context, permission Permission, key string) error {
+	if !p.Allowed(ctx, permission, key) {
+		return fmt.Errorf("%s %s: %w", permission, key, customerrors.ErrForbidden)
+	}
+	return nil
+}
+
+func (p *Policy) Allowed(ctx context.Context, permission Permission, key string) bool {
+	for g := range p.grants(ctx, permission) {
+		if g.matches(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) Unrestricted(ctx context.Context, permission Permission) bool {
+	for g := range p.grants(ctx, permission) {
+		if g.all {
+			return true
+		}
+	}
+	return false
+}
+
+// grants yields the grants of the caller's roles giving the permission, none for anonymous callers
+func (p *Policy) grants(ctx context.Context, permission Permission) func(yield func(grant) bool) {
+	return func(yield func(grant) bool) {
+		identity := lib.GetIdentity(ctx)
+		if identity == nil {
+			return
+		}
+
+		roles := slices.Clone(p.subjectRoles[identity.Subject])
+		for _, group := range identity.Groups {
+			roles = append(roles, p.groupRoles[group]...)
+		}
+
+		for _, role := range roles {
+			for _, g := range p.roles[role] {
+				if slices.Contains(g.permissions, permission) && !yield(g) {
+					return
+				}
+			}
+		}
+	}
+}
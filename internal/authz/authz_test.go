@@ -0,0 +1,153 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+)
+
+func as(subject string, groups ...string) context.Context {
+	return context.WithValue(context.Background(), lib.CtxIdentity, &lib.Identity{Subject: subject, Groups: groups})
+}
+
+func newTestPolicy(t *testing.T) *Policy {
+	t.Helper()
+	policy, err := NewPolicy(config.AuthzConfig{
+		Roles: []config.RoleConfig{
+			{Name: "app-reader", Grants: []config.GrantConfig{
+				{Prefix: "/app/", Permissions: []string{"read", "search"}},
+			}},
+			{Name: "config-editor", Grants: []config.GrantConfig{
+				{Glob: "/shared/*/config", Permissions: []string{"read", "write"}},
+			}},
+			{Name: "admin", Grants: []config.GrantConfig{
+				{Glob: "**", Permissions: []string{"read", "search", "write", "delete", "reveal"}},
+			}},
+		},
+		Bindings: []config.BindingConfig{
+			{Role: "app-reader", Groups: []string{"developers"}},
+			{Role: "config-editor", Subjects: []string{"deployer"}},
+			{Role: "admin", Groups: []string{"ops"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	return policy
+}
+
+func TestPolicyAllowed(t *testing.T) {
+	policy := newTestPolicy(t)
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		permission Permission
+		key        string
+		want       bool
+	}{
+		{name: "granted prefix", ctx: as("alice", "developers"), permission: PermissionRead, key: "/app/db/host", want: true},
+		{name: "permission not granted", ctx: as("alice", "developers"), permission: PermissionWrite, key: "/app/db/host"},
+		{name: "outside of the prefix", ctx: as("alice", "developers"), permission: PermissionRead, key: "/apps/db"},
+		{name: "prefix is not a glob", ctx: as("alice", "developers"), permission: PermissionRead, key: "/app"},
+		{name: "glob within a segment", ctx: as("deployer"), permission: PermissionWrite, key: "/shared/team/config", want: true},
+		{name: "glob across segments", ctx: as("deployer"), permission: PermissionWrite, key: "/shared/a/b/config"},
+		{name: "glob is anchored", ctx: as("deployer"), permission: PermissionWrite, key: "/shared/team/config/extra"},
+		{name: "subject binding does not apply to groups", ctx: as("bob", "deployer"), permission: PermissionWrite, key: "/shared/team/config"},
+		{name: "roles of every group", ctx: as("carol", "developers", "ops"), permission: PermissionDelete, key: "/any/key", want: true},
+		{name: "unbound caller", ctx: as("mallory", "guests"), permission: PermissionRead, key: "/app/db/host"},
+		{name: "anonymous caller", ctx: context.Background(), permission: PermissionRead, key: "/app/db/host"},
+		{name: "reveal is granted on its own", ctx: as("alice", "developers"), permission: PermissionReveal, key: "/app/db/password"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Allowed(tt.ctx, tt.permission, tt.key); got != tt.want {
+				t.Errorf("Allowed = %t, want %t", got, tt.want)
+			}
+			err := policy.Authorize(tt.ctx, tt.permission, tt.key)
+			if tt.want != (err == nil) || (err != nil && !errors.Is(err, customerrors.ErrForbidden)) {
+				t.Errorf("Authorize = %v, want forbidden: %t", err, !tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyUnrestricted(t *testing.T) {
+	policy := newTestPolicy(t)
+
+	if !policy.Unrestricted(as("carol", "ops"), PermissionRead) {
+		t.Errorf("** grant is not unrestricted")
+	}
+	if policy.Unrestricted(as("alice", "developers"), PermissionRead) {
+		t.Errorf("prefix grant is unrestricted")
+	}
+	if policy.Unrestricted(context.Background(), PermissionRead) {
+		t.Errorf("anonymous caller is unrestricted")
+	}
+}
+
+func TestNewPolicyRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		conf config.AuthzConfig
+	}{
+		{name: "role without name", conf: config.AuthzConfig{Roles: []config.RoleConfig{{}}}},
+		{name: "duplicate role", conf: config.AuthzConfig{Roles: []config.RoleConfig{{Name: "a"}, {Name: "a"}}}},
+		{name: "grant without prefix or glob", conf: config.AuthzConfig{Roles: []config.RoleConfig{
+			{Name: "a", Grants: []config.GrantConfig{{Permissions: []string{"read"}}}},
+		}}},
+		{name: "grant with prefix and glob", conf: config.AuthzConfig{Roles: []config.RoleConfig{
+			{Name: "a", Grants: []config.GrantConfig{{Prefix: "/a", Glob: "/a/*", Permissions: []string{"read"}}}},
+		}}},
+		{name: "unknown permission", conf: config.AuthzConfig{Roles: []config.RoleConfig{
+			{Name: "a", Grants: []config.GrantConfig{{Prefix: "/a", Permissions: []string{"admin"}}}},
+		}}},
+		{name: "binding to unknown role", conf: config.AuthzConfig{Bindings: []config.BindingConfig{{Role: "a"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPolicy(tt.conf); err == nil {
+				t.Errorf("NewPolicy succeeded")
+			}
+		})
+	}
+}
+
+func TestProtected(t *testing.T) {
+	authorizer := NewProtected([]string{"/registry/"}, NewAllowAll())
+	ctx := context.Background()
+
+	for _, permission := range []Permission{PermissionWrite, PermissionDelete} {
+		if err := authorizer.Authorize(ctx, permission, "/registry/pods/a"); !errors.Is(err, customerrors.ErrKeyProtected) {
+			t.Errorf("%s of a protected key = %v, want ErrKeyProtected", permission, err)
+		}
+		if authorizer.Allowed(ctx, permission, "/registry/pods/a") {
+			t.Errorf("%s of a protected key is allowed", permission)
+		}
+		if authorizer.Unrestricted(ctx, permission) {
+			t.Errorf("%s is unrestricted with protected prefixes", permission)
+		}
+		if err := authorizer.Authorize(ctx, permission, "/app/a"); err != nil {
+			t.Errorf("%s of an unprotected key = %v", permission, err)
+		}
+	}
+	if err := authorizer.Authorize(ctx, PermissionRead, "/registry/pods/a"); err != nil {
+		t.Errorf("read of a protected key = %v", err)
+	}
+	if !authorizer.Unrestricted(ctx, PermissionRead) {
+		t.Errorf("read is restricted by protected prefixes")
+	}
+
+	// Protected prefixes also apply on top of the grants of a policy
+	policy := NewProtected([]string{"/app/secret/"}, newTestPolicy(t))
+	if policy.Allowed(as("carol", "ops"), PermissionWrite, "/app/secret/key") {
+		t.Errorf("admin may write a protected key")
+	}
+	if !policy.Allowed(as("carol", "ops"), PermissionWrite, "/app/key") {
+		t.Errorf("admin may not write an unprotected key")
+	}
+}
@@ -0,0 +1,55 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+)
+
+// protected refuses writes and deletes of keys under the protected prefixes, whoever the caller is,
+// and delegates everything else to the wrapped authorizer
+type protected struct {
+	prefixes []string
+	next     Authorizer
+}
+
+// NewProtected wraps the authorizer to refuse modifications of keys under the prefixes
+func NewProtected(prefixes []string, next Authorizer) Authorizer {
+	if len(prefixes) == 0 {
+		return next
+	}
+	return &protected{prefixes: prefixes, next: next}
+}
+
+func (p *protected) Authorize(ctx context.Context, permission Permission, key string) error {
+	if p.refuses(permission, key) {
+		return fmt.Errorf("%s %s: %w", permission, key, customerrors.ErrKeyProtected)
+	}
+	return p.next.Authorize(ctx, permission, key)
+}
+
+func (p *protected) Allowed(ctx context.Context, permission Permission, key string) bool {
+	return !p.refuses(permission, key) && p.next.Allowed(ctx, permission, key)
+}
+
+func (p *protected) Unrestricted(ctx context.Context, permission Permission) bool {
+	return !isModification(permission) && p.next.Unrestricted(ctx, permission)
+}
+
+func (p *protected) refuses(permission Permission, key string) bool {
+	if !isModification(permission) {
+		return false
+	}
+	for _, prefix := range p.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isModification(permission Permission) bool {
+	return permission == PermissionWrite || permission == PermissionDelete
+}
@@ -0,0 +1,557 @@
+package changes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/authz"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/envelope"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+const defaultChangeTTL = 24 * time.Hour
+
+// applyTimeout bounds the apply of an approved change, a change applying for longer was abandoned by its approver
+const applyTimeout = time.Minute
+
+// AnyRevision requests a change whatever the current revision of the key
+const AnyRevision int64 = -1
+
+type Operation string
+
+const (
+	OperationPut    Operation = "put"
+	OperationDelete Operation = "delete"
+)
+
+type Status string
+
+const (
+	StatusPending  Status = "pending"
+	StatusApplied  Status = "applied"
+	StatusRejected Status = "rejected"
+	StatusStale    Status = "stale"   // the key changed since the change was requested
+	StatusExpired  Status = "expired" // not decided within the TTL
+)
+
+// Change is a put or delete of a critical key waiting for the approval of a second user
+type Change struct {
+	ID           string    `json:"id"`
+	Operation    Operation `json:"operation"`
+	Key          string    `json:"key"`
+	Value        string    `json:"value,omitempty"` // new value of puts
+	OldValue     string    `json:"old_value,omitempty"`
+	OldExists    bool      `json:"old_exists"`
+	BaseRevision int64     `json:"base_revision"` // mod revision the change was requested against, 0 if the key did not exist
+	Diff         string    `json:"diff"`
+	Status       Status    `json:"status"`
+	RequestedBy  string    `json:"requested_by"`
+	Requester    string    `json:"requester"` // principal of the requester, compared with the approver's
+	RequestID    string    `json:"request_id,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	DecidedBy    string    `json:"decided_by,omitempty"`
+	DecidedAt    time.Time `json:"decided_at"`
+	Revision     int64     `json:"revision,omitempty"`  // revision the change was applied at
+	Encrypted    bool      `json:"encrypted,omitempty"` // values and diff are stored encrypted, as the values of the key are
+}
+
+// Applier applies approved changes, with the permissions of the approver
+type Applier interface {
+	GetKeyWithRevision(ctx context.Context, key string) (common.KV, error)
+	CompareAndPutKey(ctx context.Context, key string, value string, modRevision int64) (int64, error)
+	CompareAndDeleteKey(ctx context.Context, key string, modRevision int64) error
+}
+
+// Store persists the changes, so that they survive restarts and are shared by the replicas
+type Store interface {
+	GetKV(ctx context.Context, key string) (common.KV, error)
+	GetPrefix(ctx context.Context, prefix string) ([]common.KV, error)
+	CompareAndPut(ctx context.Context, key string, value string, modRevision int64) (etcd.Mutation, error)
+	CompareAndDelete(ctx context.Context, key string, modRevision int64) (etcd.Mutation, error)
+}
+
+// Manager holds the changes requested on critical keys until a second user approves or rejects them.
+// Changes are stored as JSON under a prefix of etcd, every decision being a compare-and-swap of the
+// stored change so that concurrent decisions, possibly on different replicas, are taken once.
+type Manager struct {
+	critical   []*regexp.Regexp
+	ttl        time.Duration
+	authorizer authz.Authorizer
+	store      Store
+	prefix     string
+	keyring    *envelope.Keyring
+	encrypted  []string // prefixes of the keys whose values are encrypted in etcd
+}
+
+// NewManager creates a manager for the keys matching one of the critical globs, storing the changes under prefix
+func NewManager(store Store, prefix string, criticalPatterns []string, ttl time.Duration, authorizer authz.Authorizer) (*Manager, error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("changes prefix must not be empty")
+	}
+	m := &Manager{
+		ttl:        ttl,
+		authorizer: authorizer,
+		store:      store,
+		prefix:     strings.TrimSuffix(prefix, "/") + "/",
+	}
+	if m.ttl <= 0 {
+		m.ttl = defaultChangeTTL
+	}
+
+	for _, pattern := range criticalPatterns {
+		re, err := lib.CompileGlob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid critical pattern %q: %w", pattern, err)
+		}
+		m.critical = append(m.critical, re)
+	}
+
+	return m, nil
+}
+
+// EncryptWith stores the values and diff of the changes of keys under the prefixes encrypted with the keyring,
+// so that the values encrypted in etcd are not readable in plaintext from the changes
+func (m *Manager) EncryptWith(keyring *envelope.Keyring, prefixes []string) {
+	m.keyring = keyring
+	m.encrypted = prefixes
+}
+
+// IsCritical returns whether changes of the key need an approval
+func (m *Manager) IsCritical(key string) bool {
+	for _, re := range m.critical {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Request records a change of the key by the caller, against the current value of the key. Unless modRevision
+// is AnyRevision, it fails with ErrPreconditionFailed if the key is not at modRevision, 0 meaning it does not exist.
+func (m *Manager) Request(ctx context.Context, applier Applier, op Operation, key, value string, modRevision int64) (*Change, error) {
+	identity := lib.GetIdentity(ctx)
+	if identity == nil {
+		return nil, fmt.Errorf("changes of critical keys require an authenticated caller: %w", customerrors.ErrUnauthenticated)
+	}
+	if err := m.authorizer.Authorize(ctx, permissionOf(op), key); err != nil {
+		return nil, err
+	}
+
+	current, err := applier.GetKeyWithRevision(ctx, key)
+	exists := err == nil
+	if err != nil && !errors.Is(err, customerrors.ErrKeyNotFound) {
+		return nil, err
+	}
+	if modRevision != AnyRevision && current.ModRevision != modRevision {
+		return nil, customerrors.ErrPreconditionFailed
+	}
+	if op == OperationDelete && !exists {
+		return nil, customerrors.ErrKeyNotFound
+	}
+
+	change := &Change{
+		ID:           lib.GenerateUUID(),
+		Operation:    op,
+		Key:          key,
+		Value:        value,
+		OldValue:     current.Value,
+		OldExists:    exists,
+		BaseRevision: current.ModRevision,
+		Diff:         Diff(current.Value, value),
+		Status:       StatusPending,
+		RequestedBy:  identity.Subject,
+		Requester:    identity.Principal(),
+		RequestID:    lib.GetRequestID(ctx),
+		CreatedAt:    time.Now().UTC(),
+		Encrypted:    m.encrypts(key),
+	}
+	change.ExpiresAt = change.CreatedAt.Add(m.ttl)
+
+	// The change does not exist yet, it is created at mod revision 0
+	if _, err := m.save(ctx, change, 0); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// Approve applies the change with a compare-and-swap against the revision it was requested against,
+// so that it is rejected if the key changed in the meantime. The approver must not be the requester.
+func (m *Manager) Approve(ctx context.Context, applier Applier, id string) (*Change, error) {
+	identity, change, modRevision, err := m.decide(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	applyCtx, cancel := context.WithTimeout(ctx, applyTimeout)
+	defer cancel()
+	// The audit record of the apply names the requester along with the approver
+	applyCtx = context.WithValue(applyCtx, lib.CtxApproval, &lib.Approval{ChangeID: change.ID, RequestedBy: change.RequestedBy})
+	var revision int64
+	switch change.Operation {
+	case OperationPut:
+		revision, err = applier.CompareAndPutKey(applyCtx, change.Key, change.Value, change.BaseRevision)
+	case OperationDelete:
+		err = applier.CompareAndDeleteKey(applyCtx, change.Key, change.BaseRevision)
+	}
+
+	// The outcome is recorded even if the caller went away, the change would be left applying otherwise
+	ctx = context.WithoutCancel(ctx)
+
+	switch {
+	case errors.Is(err, customerrors.ErrPreconditionFailed):
+		m.finish(change, StatusStale, identity)
+		if _, saveErr := m.save(ctx, change, modRevision); saveErr != nil {
+			logger.Warnf("Failed to record stale change %s: %v", id, saveErr)
+		}
+		return nil, fmt.Errorf("change %s: %w", id, customerrors.ErrChangeStale)
+	case err != nil:
+		// Leave the change pending so that it can be approved again, e.g. by a user with the permissions
+		reopen(change)
+		if _, saveErr := m.save(ctx, change, modRevision); saveErr != nil {
+			logger.Warnf("Failed to record change %s as pending: %v", id, saveErr)
+		}
+		return nil, err
+	}
+
+	m.finish(change, StatusApplied, identity)
+	change.Revision = revision
+	if _, err := m.save(ctx, change, modRevision); err != nil {
+		// The key was changed, failing would have the change approved again
+		logger.Warnf("Failed to record applied change %s: %v", id, err)
+	}
+	return change, nil
+}
+
+// Reject discards the change, the requester may reject their own changes
+func (m *Manager) Reject(ctx context.Context, id string) (*Change, error) {
+	identity := lib.GetIdentity(ctx)
+	if identity == nil {
+		return nil, customerrors.ErrUnauthenticated
+	}
+
+	change, modRevision, err := m.pending(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if change.Requester != identity.Principal() {
+		if err := m.authorizer.Authorize(ctx, permissionOf(change.Operation), change.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	m.finish(change, StatusRejected, identity)
+	if _, err := m.save(ctx, change, modRevision); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// Get returns the change, if the caller may read its key
+func (m *Manager) Get(ctx context.Context, id string) (*Change, error) {
+	change, _, err := m.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !m.authorizer.Allowed(ctx, authz.PermissionRead, change.Key) {
+		return nil, customerrors.ErrChangeNotFound
+	}
+	return change, nil
+}
+
+// List returns the changes with the status, or all of them if empty, on keys the caller may read, most recent first.
+// It deletes the changes decided more than a TTL ago.
+func (m *Manager) List(ctx context.Context, status Status) ([]*Change, error) {
+	kvs, err := m.store.GetPrefix(ctx, m.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changes: %w", err)
+	}
+
+	now := time.Now()
+	var changes []*Change
+	for _, kv := range kvs {
+		change, err := m.decode(kv)
+		if err != nil {
+			logger.Warnf("Skipping change %s: %v", kv.Key, err)
+			continue
+		}
+		change, _ = m.settleAbandoned(ctx, change, kv.ModRevision)
+		if change.Status != StatusPending && change.Status != statusApplying && now.Sub(change.DecidedAt) > m.ttl {
+			// Another replica may be deleting it as well, the first one wins
+			if _, err := m.store.CompareAndDelete(ctx, kv.Key, kv.ModRevision); err != nil &&
+				!errors.Is(err, customerrors.ErrPreconditionFailed) {
+				logger.Warnf("Failed to delete change %s: %v", change.ID, err)
+			}
+			continue
+		}
+		if status != "" && change.Status != status {
+			continue
+		}
+		if !m.authorizer.Allowed(ctx, authz.PermissionRead, change.Key) {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	slices.SortFunc(changes, func(a, b *Change) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return changes, nil
+}
+
+// decide checks that the caller may approve the change and marks it as being applied, so that concurrent
+// approvals do not apply it twice. It returns the mod revision of the change marked as applying.
+func (m *Manager) decide(ctx context.Context, id string) (*lib.Identity, *Change, int64, error) {
+	identity := lib.GetIdentity(ctx)
+	if identity == nil {
+		return nil, nil, 0, customerrors.ErrUnauthenticated
+	}
+
+	change, modRevision, err := m.pending(ctx, id)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if change.Requester == identity.Principal() {
+		return nil, nil, 0, fmt.Errorf("changes must be approved by another user than the requester: %w", customerrors.ErrForbidden)
+	}
+	if err := m.authorizer.Authorize(ctx, permissionOf(change.Operation), change.Key); err != nil {
+		return nil, nil, 0, err
+	}
+
+	change.Status = statusApplying
+	change.DecidedBy = identity.Subject
+	change.DecidedAt = time.Now().UTC()
+	modRevision, err = m.save(ctx, change, modRevision)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return identity, change, modRevision, nil
+}
+
+// statusApplying marks a change being applied by an approver
+const statusApplying Status = "applying"
+
+// settleAbandoned settles the change if it was left applying past applyTimeout, its approver having failed
+// before recording the outcome. It returns the change unchanged if it could not be settled.
+func (m *Manager) settleAbandoned(ctx context.Context, change *Change, modRevision int64) (*Change, int64) {
+	if change.Status != statusApplying || time.Since(change.DecidedAt) <= applyTimeout {
+		return change, modRevision
+	}
+	settled, settledRevision, err := m.settle(ctx, *change, modRevision)
+	if err != nil {
+		logger.Warnf("Failed to settle abandoned change %s: %v", change.ID, err)
+		return change, modRevision
+	}
+	logger.Infof("Settled change %s abandoned while applying as %s", change.ID, settled.Status)
+	expire(settled)
+	return settled, settledRevision
+}
+
+// settle compares the key with the base revision of the change: the change is pending again if the key is still
+// at its base revision, applied if the key holds the outcome of the change, and stale otherwise
+func (m *Manager) settle(ctx context.Context, change Change, modRevision int64) (*Change, int64, error) {
+	kv, err := m.store.GetKV(ctx, change.Key)
+	exists := err == nil
+	if err != nil && !errors.Is(err, customerrors.ErrKeyNotFound) {
+		return nil, 0, fmt.Errorf("failed to read key %s: %w", change.Key, err)
+	}
+
+	switch {
+	case kv.ModRevision == change.BaseRevision:
+		reopen(&change)
+	case change.Operation == OperationDelete && !exists:
+		change.Status = StatusApplied
+	case change.Operation == OperationPut && exists && m.holds(kv, change.Value):
+		change.Status = StatusApplied
+		change.Revision = kv.ModRevision
+	default:
+		change.Status = StatusStale
+	}
+
+	modRevision, err = m.save(ctx, &change, modRevision)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &change, modRevision, nil
+}
+
+// holds returns whether the key read from etcd holds the value, decrypting it if needed
+func (m *Manager) holds(kv common.KV, value string) bool {
+	if !envelope.IsEncrypted(kv.Value) {
+		return kv.Value == value
+	}
+	if m.keyring == nil {
+		return false
+	}
+	decrypted, err := m.keyring.Decrypt(kv.Key, kv.Value)
+	return err == nil && decrypted == value
+}
+
+// reopen returns the change to pending, after its apply failed
+func reopen(change *Change) {
+	change.Status = StatusPending
+	change.DecidedBy = ""
+	change.DecidedAt = time.Time{}
+}
+
+// pending returns the pending change along with its mod revision
+func (m *Manager) pending(ctx context.Context, id string) (*Change, int64, error) {
+	change, modRevision, err := m.load(ctx, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !m.authorizer.Allowed(ctx, authz.PermissionRead, change.Key) {
+		return nil, 0, customerrors.ErrChangeNotFound
+	}
+	if change.Status != StatusPending {
+		return nil, 0, fmt.Errorf("change %s is %s: %w", id, change.Status, customerrors.ErrChangeNotPending)
+	}
+	return change, modRevision, nil
+}
+
+// load reads the change and its mod revision, the pending changes past their TTL being returned as expired
+// and the changes abandoned while applying being settled
+func (m *Manager) load(ctx context.Context, id string) (*Change, int64, error) {
+	if id == "" || strings.ContainsAny(id, "/.") {
+		return nil, 0, customerrors.ErrChangeNotFound
+	}
+	kv, err := m.store.GetKV(ctx, m.prefix+id)
+	if errors.Is(err, customerrors.ErrKeyNotFound) {
+		return nil, 0, customerrors.ErrChangeNotFound
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read change %s: %w", id, err)
+	}
+	change, err := m.decode(kv)
+	if err != nil {
+		return nil, 0, err
+	}
+	change, modRevision := m.settleAbandoned(ctx, change, kv.ModRevision)
+	return change, modRevision, nil
+}
+
+// save writes the change if its stored mod revision is still modRevision, 0 creating it, and returns its new
+// mod revision. It fails with ErrChangeNotPending if the change was decided in the meantime.
+func (m *Manager) save(ctx context.Context, change *Change, modRevision int64) (int64, error) {
+	stored, err := m.seal(change)
+	if err != nil {
+		return 0, err
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode change %s: %w", change.ID, err)
+	}
+	mutation, err := m.store.CompareAndPut(ctx, m.prefix+change.ID, string(data), modRevision)
+	if errors.Is(err, customerrors.ErrPreconditionFailed) {
+		return 0, fmt.Errorf("change %s was decided concurrently: %w", change.ID, customerrors.ErrChangeNotPending)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to store change %s: %w", change.ID, err)
+	}
+	return mutation.Revision, nil
+}
+
+// finish records the decision on the change
+func (m *Manager) finish(change *Change, status Status, identity *lib.Identity) {
+	change.Status = status
+	change.DecidedBy = identity.Subject
+	change.DecidedAt = time.Now().UTC()
+}
+
+// decode parses and decrypts the stored change, marking it as expired if it is still pending past its TTL.
+// Expiring on read leaves the stored change untouched, so that it never conflicts with a decision.
+func (m *Manager) decode(kv common.KV) (*Change, error) {
+	var change Change
+	if err := json.Unmarshal([]byte(kv.Value), &change); err != nil {
+		return nil, fmt.Errorf("invalid change %s: %w", kv.Key, err)
+	}
+	if err := m.open(&change); err != nil {
+		return nil, err
+	}
+	expire(&change)
+	return &change, nil
+}
+
+// expire marks the change as expired if it is still pending past its TTL
+func expire(change *Change) {
+	if change.Status == StatusPending && time.Now().After(change.ExpiresAt) {
+		change.Status = StatusExpired
+		change.DecidedAt = change.ExpiresAt
+	}
+}
+
+// encrypts returns whether the values of the key are encrypted in etcd
+func (m *Manager) encrypts(key string) bool {
+	if m.keyring == nil {
+		return false
+	}
+	for _, prefix := range m.encrypted {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// seal returns the change to store, a copy with the values and diff encrypted if the change is encrypted
+func (m *Manager) seal(change *Change) (*Change, error) {
+	if !change.Encrypted {
+		return change, nil
+	}
+	if m.keyring == nil {
+		return nil, fmt.Errorf("change %s is encrypted but no keyring is configured", change.ID)
+	}
+	sealed := *change
+	for field, value := range payload(&sealed) {
+		encrypted, err := m.keyring.Encrypt(m.payloadKey(change, field), *value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt change %s: %w", change.ID, err)
+		}
+		*value = encrypted
+	}
+	return &sealed, nil
+}
+
+// open decrypts the values and diff of the stored change
+func (m *Manager) open(change *Change) error {
+	if !change.Encrypted {
+		return nil
+	}
+	if m.keyring == nil {
+		return fmt.Errorf("change %s is encrypted but no keyring is configured", change.ID)
+	}
+	for field, value := range payload(change) {
+		decrypted, err := m.keyring.Decrypt(m.payloadKey(change, field), *value)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt change %s: %w", change.ID, err)
+		}
+		*value = decrypted
+	}
+	return nil
+}
+
+// payload returns the fields of the change holding values of its key, by name
+func payload(change *Change) map[string]*string {
+	return map[string]*string{"value": &change.Value, "old_value": &change.OldValue, "diff": &change.Diff}
+}
+
+// payloadKey binds the encrypted field to the change, so that it cannot be moved to another change or field
+func (m *Manager) payloadKey(change *Change, field string) string {
+	return m.prefix + change.ID + "#" + field
+}
+
+func permissionOf(op Operation) authz.Permission {
+	if op == OperationDelete {
+		return authz.PermissionDelete
+	}
+	return authz.PermissionWrite
+}
@@ -0,0 +1,415 @@
+package changes
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/authz"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/envelope"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// fakeEtcd keeps the keys in memory along with their mod revisions. It stores the changes and applies them.
+type fakeEtcd struct {
+	mu       sync.Mutex
+	keys     map[string]common.KV
+	revision int64
+}
+
+func newFakeEtcd(values map[string]string) *fakeEtcd {
+	e := &fakeEtcd{keys: map[string]common.KV{}}
+	for key, value := range values {
+		e.revision++
+		e.keys[key] = common.KV{Key: key, Value: value, ModRevision: e.revision}
+	}
+	return e
+}
+
+func (e *fakeEtcd) GetKV(ctx context.Context, key string) (common.KV, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	kv, ok := e.keys[key]
+	if !ok {
+		return common.KV{}, customerrors.ErrKeyNotFound
+	}
+	return kv, nil
+}
+
+func (e *fakeEtcd) GetPrefix(ctx context.Context, prefix string) ([]common.KV, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var kvs []common.KV
+	for key, kv := range e.keys {
+		if strings.HasPrefix(key, prefix) {
+			kvs = append(kvs, kv)
+		}
+	}
+	return kvs, nil
+}
+
+// CompareAndPut puts the key if it is at modRevision, 0 meaning absent and AnyRevision any revision
+func (e *fakeEtcd) CompareAndPut(ctx context.Context, key string, value string, modRevision int64) (etcd.Mutation, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if modRevision != AnyRevision && e.keys[key].ModRevision != modRevision {
+		return etcd.Mutation{}, customerrors.ErrPreconditionFailed
+	}
+	e.revision++
+	e.keys[key] = common.KV{Key: key, Value: value, ModRevision: e.revision}
+	return etcd.Mutation{Key: key, Revision: e.revision}, nil
+}
+
+func (e *fakeEtcd) CompareAndDelete(ctx context.Context, key string, modRevision int64) (etcd.Mutation, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	kv, ok := e.keys[key]
+	if modRevision != AnyRevision && kv.ModRevision != modRevision {
+		return etcd.Mutation{}, customerrors.ErrPreconditionFailed
+	}
+	if !ok {
+		return etcd.Mutation{}, customerrors.ErrKeyNotFound
+	}
+	e.revision++
+	delete(e.keys, key)
+	return etcd.Mutation{Key: key, Revision: e.revision, Prev: &kv}, nil
+}
+
+func (e *fakeEtcd) GetKeyWithRevision(ctx context.Context, key string) (common.KV, error) {
+	return e.GetKV(ctx, key)
+}
+
+func (e *fakeEtcd) CompareAndPutKey(ctx context.Context, key string, value string, modRevision int64) (int64, error) {
+	mutation, err := e.CompareAndPut(ctx, key, value, modRevision)
+	return mutation.Revision, err
+}
+
+func (e *fakeEtcd) CompareAndDeleteKey(ctx context.Context, key string, modRevision int64) error {
+	_, err := e.CompareAndDelete(ctx, key, modRevision)
+	return err
+}
+
+// put writes the key as another etcd client would
+func (e *fakeEtcd) put(key, value string) {
+	if _, err := e.CompareAndPut(context.Background(), key, value, AnyRevision); err != nil {
+		panic(err)
+	}
+}
+
+func as(subject string) context.Context {
+	return context.WithValue(context.Background(), lib.CtxIdentity, &lib.Identity{Subject: subject})
+}
+
+func newTestManager(t *testing.T, store Store) *Manager {
+	t.Helper()
+	logger.L = &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}
+	manager, err := NewManager(store, "/etcdfinder/changes", []string{"/prod/**"}, 0, authz.NewAllowAll())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	return manager
+}
+
+func newTestKeyring(t *testing.T) *envelope.Keyring {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "keyring.yaml")
+	content := "primary: k1\nkeys:\n  k1: " + base64.StdEncoding.EncodeToString(key) + "\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write keyring: %v", err)
+	}
+	keyring, err := envelope.LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	return keyring
+}
+
+// The changes of keys whose values are encrypted in etcd do not store them in plaintext
+func TestEncryptedChanges(t *testing.T) {
+	keyring := newTestKeyring(t)
+	store := newFakeEtcd(nil)
+	manager := newTestManager(t, store)
+	manager.EncryptWith(keyring, []string{"/prod/secret/"})
+
+	// The applier decrypts the values, as the encrypting service does
+	applier := newFakeEtcd(map[string]string{"/prod/secret/db": "old-password", "/prod/host": "db-1"})
+	secret, err := manager.Request(as("alice"), applier, OperationPut, "/prod/secret/db", "new-password", AnyRevision)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	plain, err := manager.Request(as("alice"), applier, OperationPut, "/prod/host", "db-2", AnyRevision)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+
+	stored, _ := store.GetKV(context.Background(), manager.prefix+secret.ID)
+	for _, value := range []string{"old-password", "new-password"} {
+		if strings.Contains(stored.Value, value) {
+			t.Errorf("stored change contains %q in plaintext: %s", value, stored.Value)
+		}
+	}
+	if stored, _ := store.GetKV(context.Background(), manager.prefix+plain.ID); !strings.Contains(stored.Value, "db-2") {
+		t.Errorf("change of a key that is not encrypted was encrypted: %s", stored.Value)
+	}
+
+	got, err := manager.Get(as("bob"), secret.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Value != "new-password" || got.OldValue != "old-password" || got.Diff != secret.Diff {
+		t.Errorf("Get = %+v, want the decrypted change", got)
+	}
+	listed, err := manager.List(as("bob"), StatusPending)
+	if err != nil || len(listed) != 2 {
+		t.Fatalf("List = %d changes, %v", len(listed), err)
+	}
+
+	// Approving decrypts the change to apply it and stores the decision encrypted
+	applied, err := manager.Approve(as("bob"), applier, secret.ID)
+	if err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	if kv, _ := applier.GetKV(context.Background(), "/prod/secret/db"); kv.Value != "new-password" || applied.Status != StatusApplied {
+		t.Errorf("applied %q as %s", kv.Value, applied.Status)
+	}
+	if stored, _ := store.GetKV(context.Background(), manager.prefix+secret.ID); strings.Contains(stored.Value, "new-password") {
+		t.Errorf("decided change contains the value in plaintext: %s", stored.Value)
+	}
+
+	// Without the keyring, encrypted changes cannot be read
+	if _, err := newTestManager(t, store).Get(as("bob"), secret.ID); err == nil {
+		t.Errorf("read an encrypted change without the keyring")
+	}
+
+	// The encrypted fields are bound to their change
+	other, err := manager.Request(as("alice"), applier, OperationPut, "/prod/secret/db", "other-password", AnyRevision)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	copied, _ := store.GetKV(context.Background(), manager.prefix+secret.ID)
+	store.put(manager.prefix+other.ID, strings.ReplaceAll(copied.Value, secret.ID, other.ID))
+	if _, err := manager.Get(as("bob"), other.ID); err == nil {
+		t.Errorf("read a change whose encrypted fields were copied from another change")
+	}
+}
+
+// abandon stores the change as left applying by an approver at decidedAt
+func abandon(t *testing.T, store *fakeEtcd, manager *Manager, change *Change, decidedAt time.Time) {
+	t.Helper()
+	abandoned := *change
+	abandoned.Status = statusApplying
+	abandoned.DecidedBy = "bob"
+	abandoned.DecidedAt = decidedAt
+	data, err := json.Marshal(&abandoned)
+	if err != nil {
+		t.Fatalf("failed to encode change: %v", err)
+	}
+	store.put(manager.prefix+change.ID, string(data))
+}
+
+func TestSettleAbandonedChanges(t *testing.T) {
+	tests := []struct {
+		name       string
+		op         Operation
+		apply      func(e *fakeEtcd) // what the approver did before failing
+		decidedAt  time.Duration     // age of the apply
+		wantStatus Status
+	}{
+		{name: "put not applied", op: OperationPut, apply: func(e *fakeEtcd) {}, decidedAt: 2 * applyTimeout, wantStatus: StatusPending},
+		{name: "put applied", op: OperationPut, apply: func(e *fakeEtcd) { e.put("/prod/db", "new") }, decidedAt: 2 * applyTimeout, wantStatus: StatusApplied},
+		{name: "key changed by another client", op: OperationPut, apply: func(e *fakeEtcd) { e.put("/prod/db", "other") }, decidedAt: 2 * applyTimeout, wantStatus: StatusStale},
+		{name: "delete applied", op: OperationDelete, apply: func(e *fakeEtcd) {
+			_, _ = e.CompareAndDelete(context.Background(), "/prod/db", AnyRevision)
+		}, decidedAt: 2 * applyTimeout, wantStatus: StatusApplied},
+		{name: "delete not applied", op: OperationDelete, apply: func(e *fakeEtcd) {}, decidedAt: 2 * applyTimeout, wantStatus: StatusPending},
+		{name: "still applying", op: OperationPut, apply: func(e *fakeEtcd) {}, decidedAt: applyTimeout / 2, wantStatus: statusApplying},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The manager stores its changes in the etcd holding the keys
+			store := newFakeEtcd(map[string]string{"/prod/db": "old"})
+			manager := newTestManager(t, store)
+			change, err := manager.Request(as("alice"), store, tt.op, "/prod/db", "new", AnyRevision)
+			if err != nil {
+				t.Fatalf("Request: %v", err)
+			}
+			abandon(t, store, manager, change, time.Now().Add(-tt.decidedAt))
+			tt.apply(store)
+
+			got, err := manager.Get(as("carol"), change.ID)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Fatalf("change is %s, want %s", got.Status, tt.wantStatus)
+			}
+			if tt.wantStatus == StatusApplied && tt.op == OperationPut {
+				if kv, _ := store.GetKV(context.Background(), "/prod/db"); got.Revision != kv.ModRevision {
+					t.Errorf("change applied at revision %d, want %d", got.Revision, kv.ModRevision)
+				}
+			}
+
+			// The outcome is stored, a change pending again can be approved
+			listed, err := manager.List(as("carol"), tt.wantStatus)
+			if err != nil || len(listed) != 1 {
+				t.Fatalf("List(%s) = %d changes, %v", tt.wantStatus, len(listed), err)
+			}
+			if tt.wantStatus == StatusPending {
+				if _, err := manager.Approve(as("carol"), store, change.ID); err != nil {
+					t.Errorf("Approve: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// approvalRecorder records the approval the change is applied for, as the audit does
+type approvalRecorder struct {
+	*fakeEtcd
+	approval *lib.Approval
+}
+
+func (r *approvalRecorder) CompareAndPutKey(ctx context.Context, key string, value string, modRevision int64) (int64, error) {
+	r.approval = lib.GetApproval(ctx)
+	return r.fakeEtcd.CompareAndPutKey(ctx, key, value, modRevision)
+}
+
+func TestApproveNamesTheRequester(t *testing.T) {
+	store := newFakeEtcd(map[string]string{"/prod/db": "old"})
+	manager := newTestManager(t, store)
+	change, err := manager.Request(as("alice"), store, OperationPut, "/prod/db", "new", AnyRevision)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+
+	applier := &approvalRecorder{fakeEtcd: store}
+	if _, err := manager.Approve(as("bob"), applier, change.ID); err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	if applier.approval == nil || applier.approval.ChangeID != change.ID || applier.approval.RequestedBy != "alice" {
+		t.Errorf("applied with approval %+v, want change %s requested by alice", applier.approval, change.ID)
+	}
+}
+
+func TestApprovalWorkflow(t *testing.T) {
+	approve := func(m *Manager, e *fakeEtcd, ctx context.Context, id string) (*Change, error) {
+		return m.Approve(ctx, e, id)
+	}
+	reject := func(m *Manager, e *fakeEtcd, ctx context.Context, id string) (*Change, error) {
+		return m.Reject(ctx, id)
+	}
+
+	tests := []struct {
+		name       string
+		op         Operation
+		revision   int64                                                                          // revision the change is requested against
+		before     func(e *fakeEtcd)                                                              // run between the request and the decision
+		decide     func(m *Manager, e *fakeEtcd, ctx context.Context, id string) (*Change, error) // nil leaves the change pending
+		decider    string
+		wantErr    error
+		wantStatus Status
+		wantValue  string // value of the key after the decision, empty if deleted
+	}{
+		{name: "put approved", op: OperationPut, revision: AnyRevision, decide: approve, decider: "bob", wantStatus: StatusApplied, wantValue: "new"},
+		{name: "conditional put approved", op: OperationPut, revision: 1, decide: approve, decider: "bob", wantStatus: StatusApplied, wantValue: "new"},
+		{name: "delete approved", op: OperationDelete, revision: AnyRevision, decide: approve, decider: "bob", wantStatus: StatusApplied},
+		{name: "approved by the requester", op: OperationPut, revision: AnyRevision, decide: approve, decider: "alice", wantErr: customerrors.ErrForbidden, wantStatus: StatusPending, wantValue: "old"},
+		{name: "approved without authentication", op: OperationPut, revision: AnyRevision, decide: approve, wantErr: customerrors.ErrUnauthenticated, wantStatus: StatusPending, wantValue: "old"},
+		{name: "key changed since the request", op: OperationPut, revision: AnyRevision, before: func(e *fakeEtcd) { e.put("/prod/db", "other") },
+			decide: approve, decider: "bob", wantErr: customerrors.ErrChangeStale, wantStatus: StatusStale, wantValue: "other"},
+		{name: "key deleted since the request", op: OperationDelete, revision: AnyRevision, before: func(e *fakeEtcd) {
+			_, _ = e.CompareAndDelete(context.Background(), "/prod/db", AnyRevision)
+		}, decide: approve, decider: "bob", wantErr: customerrors.ErrChangeStale, wantStatus: StatusStale},
+		{name: "rejected by another user", op: OperationPut, revision: AnyRevision, decide: reject, decider: "bob", wantStatus: StatusRejected, wantValue: "old"},
+		{name: "rejected by the requester", op: OperationPut, revision: AnyRevision, decide: reject, decider: "alice", wantStatus: StatusRejected, wantValue: "old"},
+		{name: "pending", op: OperationPut, revision: AnyRevision, wantStatus: StatusPending, wantValue: "old"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeEtcd(map[string]string{"/prod/db": "old"})
+			manager := newTestManager(t, store)
+			change, err := manager.Request(as("alice"), store, tt.op, "/prod/db", "new", tt.revision)
+			if err != nil {
+				t.Fatalf("Request: %v", err)
+			}
+			if change.Status != StatusPending || change.BaseRevision != 1 || change.OldValue != "old" {
+				t.Fatalf("requested %+v, want a pending change against revision 1", change)
+			}
+			if kv, _ := store.GetKV(context.Background(), "/prod/db"); kv.Value != "old" {
+				t.Fatalf("key was modified by the request: %q", kv.Value)
+			}
+
+			if tt.before != nil {
+				tt.before(store)
+			}
+			if tt.decide != nil {
+				ctx := context.Background()
+				if tt.decider != "" {
+					ctx = as(tt.decider)
+				}
+				_, err := tt.decide(manager, store, ctx, change.ID)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("decision = %v, want %v", err, tt.wantErr)
+				}
+			}
+
+			got, err := manager.Get(as("carol"), change.ID)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("change is %s, want %s", got.Status, tt.wantStatus)
+			}
+			if got.Status != StatusPending && got.DecidedBy != tt.decider {
+				t.Errorf("change decided by %q, want %q", got.DecidedBy, tt.decider)
+			}
+			kv, err := store.GetKV(context.Background(), "/prod/db")
+			if kv.Value != tt.wantValue || (tt.wantValue == "") != errors.Is(err, customerrors.ErrKeyNotFound) {
+				t.Errorf("key is %q, %v, want %q", kv.Value, err, tt.wantValue)
+			}
+
+			// Decided changes cannot be decided again
+			if got.Status != StatusPending {
+				if _, err := manager.Approve(as("carol"), store, change.ID); !errors.Is(err, customerrors.ErrChangeNotPending) {
+					t.Errorf("second approval = %v, want ErrChangeNotPending", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestPreconditions(t *testing.T) {
+	store := newFakeEtcd(map[string]string{"/prod/db": "old"})
+	manager := newTestManager(t, store)
+
+	if _, err := manager.Request(context.Background(), store, OperationPut, "/prod/db", "new", AnyRevision); !errors.Is(err, customerrors.ErrUnauthenticated) {
+		t.Errorf("unauthenticated request = %v, want ErrUnauthenticated", err)
+	}
+	if _, err := manager.Request(as("alice"), store, OperationPut, "/prod/db", "new", 7); !errors.Is(err, customerrors.ErrPreconditionFailed) {
+		t.Errorf("request against another revision = %v, want ErrPreconditionFailed", err)
+	}
+	if _, err := manager.Request(as("alice"), store, OperationDelete, "/prod/missing", "", AnyRevision); !errors.Is(err, customerrors.ErrKeyNotFound) {
+		t.Errorf("delete of a missing key = %v, want ErrKeyNotFound", err)
+	}
+	created, err := manager.Request(as("alice"), store, OperationPut, "/prod/new", "value", 0)
+	if err != nil || created.OldExists || created.BaseRevision != 0 {
+		t.Errorf("request creating a key = %+v, %v", created, err)
+	}
+}
@@ -0,0 +1,71 @@
+package changes
+
+import (
+	"strings"
+)
+
+// maximum number of lines compared line by line, larger values are diffed as a whole
+const maxDiffLines = 1000
+
+// Diff returns a line diff from old to new, with removed lines prefixed by "-", added lines by "+"
+// and unchanged lines by " "
+func Diff(old, new string) string {
+	oldLines, newLines := splitLines(old), splitLines(new)
+
+	if len(oldLines) > maxDiffLines || len(newLines) > maxDiffLines {
+		var b strings.Builder
+		writeLines(&b, "-", oldLines)
+		writeLines(&b, "+", newLines)
+		return b.String()
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of oldLines[i:] and newLines[j:]
+	lcs := make([][]int, len(oldLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var b strings.Builder
+	i, j := 0, 0
+	for i < len(oldLines) && j < len(newLines) {
+		switch {
+		case oldLines[i] == newLines[j]:
+			writeLines(&b, " ", oldLines[i:i+1])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			writeLines(&b, "-", oldLines[i:i+1])
+			i++
+		default:
+			writeLines(&b, "+", newLines[j:j+1])
+			j++
+		}
+	}
+	writeLines(&b, "-", oldLines[i:])
+	writeLines(&b, "+", newLines[j:])
+	return b.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func writeLines(b *strings.Builder, prefix string, lines []string) {
+	for _, line := range lines {
+		b.WriteString(prefix)
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+}
@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/etcdfinder/etcdfinder/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+// awaitingApproval reports the changes of critical keys held for approval, which are not failures
+func awaitingApproval(cmd *cobra.Command, err error) error {
+	if !errors.Is(err, client.ErrApprovalRequired) {
+		return err
+	}
+	_, err = fmt.Fprintf(cmd.ErrOrStderr(), "change %s awaits approval by another user\n", client.ChangeID(err))
+	return err
+}
+
+func newChangesCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "changes",
+		Short: "Review the changes of critical keys awaiting approval",
+	}
+
+	completeChanges := func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		clt, err := opts.client()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		changes, err := clt.ListChanges(cmd.Context(), "pending")
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		ids := make([]string, 0, len(changes))
+		for _, change := range changes {
+			ids = append(ids, change.ID+"\t"+change.Operation+" "+change.Key)
+		}
+		return ids, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var status string
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List changes, most recent first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clt, err := opts.client()
+			if err != nil {
+				return err
+			}
+			changes, err := clt.ListChanges(cmd.Context(), status)
+			if err != nil {
+				return err
+			}
+			return opts.printer(cmd).print(changes)
+		},
+	}
+	listCmd.Flags().StringVar(&status, "status", "pending", "Status of the changes to list: pending, applied, rejected, stale or expired, empty for all")
+	cmd.AddCommand(listCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:               "show <id>",
+		Short:             "Print a change with its diff",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeChanges,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clt, err := opts.client()
+			if err != nil {
+				return err
+			}
+			change, err := clt.GetChange(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			return opts.printer(cmd).print(change)
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:               "approve <id>",
+		Short:             "Apply a change requested by another user",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeChanges,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clt, err := opts.client()
+			if err != nil {
+				return err
+			}
+			change, err := clt.ApproveChange(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			return opts.printer(cmd).print(change)
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:               "reject <id>",
+		Short:             "Discard a change",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeChanges,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clt, err := opts.client()
+			if err != nil {
+				return err
+			}
+			change, err := clt.RejectChange(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			return opts.printer(cmd).print(change)
+		},
+	})
+
+	return cmd
+}
@@ -0,0 +1,296 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"iter"
+	"sort"
+	"strings"
+
+	"github.com/etcdfinder/etcdfinder/internal/tui"
+	"github.com/etcdfinder/etcdfinder/pkg/client"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/spf13/cobra"
+)
+
+// maximum number of keys suggested by shell completion
+const maxCompletions = 50
+
+// completeKeys completes the first argument with keys found on the server
+func completeKeys(opts *options) cobra.CompletionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		clt, err := opts.client()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		keys, err := clt.SearchKeys(cmd.Context(), toComplete, 0, maxCompletions)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		return keys, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func newSearchCommand(opts *options) *cobra.Command {
+	var limit int64
+
+	cmd := &cobra.Command{
+		Use:   "search <query>",
+		Short: "Fuzzy-find keys matching the query",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clt, err := opts.client()
+			if err != nil {
+				return err
+			}
+			query := ""
+			if len(args) > 0 {
+				query = args[0]
+			}
+
+			keys := []string{}
+			for key, err := range clt.SearchKeysIter(cmd.Context(), query, 0) {
+				if err != nil {
+					return err
+				}
+				keys = append(keys, key)
+				if limit > 0 && int64(len(keys)) >= limit {
+					break
+				}
+			}
+			return opts.printer(cmd).print(keys)
+		},
+	}
+	cmd.Flags().Int64VarP(&limit, "limit", "l", 100, "Maximum number of keys to return, 0 for all")
+
+	return cmd
+}
+
+func newGetCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:               "get <key>",
+		Short:             "Print the value of a key",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeKeys(opts),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clt, err := opts.client()
+			if err != nil {
+				return err
+			}
+			value, err := clt.GetKey(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			return opts.printer(cmd).print(common.KV{Key: args[0], Value: value})
+		},
+	}
+}
+
+func newPutCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:               "put <key> [value]",
+		Short:             "Create or update a key, reading the value from stdin if omitted or \"-\"",
+		Args:              cobra.RangeArgs(1, 2),
+		ValidArgsFunction: completeKeys(opts),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clt, err := opts.client()
+			if err != nil {
+				return err
+			}
+
+			var value string
+			if len(args) == 2 && args[1] != "-" {
+				value = args[1]
+			} else {
+				data, err := io.ReadAll(cmd.InOrStdin())
+				if err != nil {
+					return fmt.Errorf("failed to read value from stdin: %w", err)
+				}
+				value = string(data)
+			}
+
+			if err := clt.PutKey(cmd.Context(), args[0], value); err != nil {
+				return awaitingApproval(cmd, err)
+			}
+			return opts.printer(cmd).print(common.KV{Key: args[0], Value: value})
+		},
+	}
+}
+
+func newRmCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:               "rm <key>",
+		Aliases:           []string{"delete"},
+		Short:             "Delete a key",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeKeys(opts),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clt, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if err := clt.DeleteKey(cmd.Context(), args[0]); err != nil {
+				return awaitingApproval(cmd, err)
+			}
+			return opts.printer(cmd).print([]string{args[0]})
+		},
+	}
+}
+
+func newLsCommand(opts *options) *cobra.Command {
+	var recursive bool
+
+	cmd := &cobra.Command{
+		Use:               "ls [prefix]",
+		Short:             "List the indexed keys directly under a prefix",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeKeys(opts),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clt, err := opts.client()
+			if err != nil {
+				return err
+			}
+			prefix := ""
+			if len(args) > 0 {
+				prefix = args[0]
+			}
+
+			seen := map[string]struct{}{}
+			for key, err := range keysUnder(cmd.Context(), clt, prefix) {
+				if err != nil {
+					return err
+				}
+				if !recursive {
+					key = childOf(prefix, key)
+				}
+				seen[key] = struct{}{}
+			}
+
+			keys := make([]string, 0, len(seen))
+			for key := range seen {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			return opts.printer(cmd).print(keys)
+		},
+	}
+	cmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "List all keys under the prefix instead of its direct children")
+
+	return cmd
+}
+
+// keysUnder iterates over the indexed keys under prefix. The index has no prefix listing, so every key is listed
+// and filtered, searches being capped by the maximum number of hits of the index.
+func keysUnder(ctx context.Context, clt *client.Client, prefix string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		for key, err := range clt.ListKeysIter(ctx, 0) {
+			if err != nil {
+				yield("", err)
+				return
+			}
+			if strings.HasPrefix(key, prefix) && !yield(key, nil) {
+				return
+			}
+		}
+	}
+}
+
+// childOf returns the direct child of prefix that key belongs to, ending with "/" if it has children itself
+func childOf(prefix, key string) string {
+	rest := key[len(prefix):]
+	// Skip a leading separator so that "/app" lists "/app/config/" rather than "/app/"
+	start := 0
+	if strings.HasPrefix(rest, "/") {
+		start = 1
+	}
+	if idx := strings.Index(rest[start:], "/"); idx >= 0 {
+		return prefix + rest[:start+idx+1]
+	}
+	return key
+}
+
+func newExportCommand(opts *options) *cobra.Command {
+	var prefix string
+
+	cmd := &cobra.Command{
+		Use:               "export [prefix]",
+		Short:             "Export the keys under a prefix together with their values",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeKeys(opts),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clt, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if len(args) > 0 {
+				prefix = args[0]
+			}
+
+			kvs := []common.KV{}
+			for key, err := range keysUnder(cmd.Context(), clt, prefix) {
+				if err != nil {
+					return err
+				}
+				// Values are read from etcd rather than the index so that the export is authoritative
+				value, err := clt.GetKey(cmd.Context(), key)
+				if err != nil {
+					return fmt.Errorf("failed to export %s: %w", key, err)
+				}
+				kvs = append(kvs, common.KV{Key: key, Value: value})
+			}
+
+			sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+			return opts.printer(cmd).print(kvs)
+		},
+	}
+	cmd.Flags().StringVar(&prefix, "prefix", "", "Only export keys under this prefix")
+	_ = cmd.Flags().MarkDeprecated("prefix", "pass the prefix as argument instead")
+
+	return cmd
+}
+
+func newWatchCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "watch [prefix]",
+		Short: "Stream changes of keys under a prefix",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clt, err := opts.client()
+			if err != nil {
+				return err
+			}
+			prefix := ""
+			if len(args) > 0 {
+				prefix = args[0]
+			}
+
+			p := opts.printer(cmd)
+			eventCh, errCh := clt.WatchKeys(cmd.Context(), prefix)
+			for event := range eventCh {
+				if err := p.printStreamed(event); err != nil {
+					return err
+				}
+			}
+			return <-errCh
+		},
+	}
+}
+
+func newTUICommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "tui",
+		Short: "Browse and fuzzy-find keys in a full-screen terminal UI",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clt, err := opts.client()
+			if err != nil {
+				return err
+			}
+			return tui.Run(cmd.Context(), clt)
+		},
+	}
+}
@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/etcdfinder/etcdfinder/internal/api/dto"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+)
+
+// maximum number of hits of the searches of fakeServer, as capped by Meilisearch
+const searchCap = 1000
+
+// fakeServer serves the sorted keys, the list cursor being the index of the first key of the page plus one.
+// Searches match keys containing the search string, never past searchCap hits.
+func fakeServer(t *testing.T, keys []string) *httptest.Server {
+	t.Helper()
+	slices.Sort(keys)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/list-keys", func(w http.ResponseWriter, r *http.Request) {
+		var req dto.ListKeysRequest
+		decode(t, r, &req)
+		if err := req.Validate(); err != nil {
+			t.Errorf("invalid list request: %v", err)
+		}
+		start := max(req.Cursor-1, 0)
+		end := min(start+req.Limit, int64(len(keys)))
+		resp := dto.ListKeysResponse{Keys: keys[start:end]}
+		if end < int64(len(keys)) {
+			resp.NextCursor = end + 1
+		}
+		encode(t, w, resp)
+	})
+	mux.HandleFunc("POST /v1/search-keys", func(w http.ResponseWriter, r *http.Request) {
+		var req dto.SearchKeysRequest
+		decode(t, r, &req)
+		if err := req.Validate(); err != nil {
+			t.Errorf("invalid search request: %v", err)
+		}
+		var hits []string
+		for _, key := range keys {
+			if strings.Contains(key, req.SearchStr) && len(hits) < searchCap {
+				hits = append(hits, key)
+			}
+		}
+		hits = hits[min(req.Offset, int64(len(hits))):min(req.Offset+req.Limit, int64(len(hits)))]
+		encode(t, w, dto.SearchKeysResponse{Keys: hits})
+	})
+	mux.HandleFunc("POST /v1/get-key", func(w http.ResponseWriter, r *http.Request) {
+		var req dto.GetKeyRequest
+		decode(t, r, &req)
+		encode(t, w, dto.GetKeyResponse{Key: req.Key, Value: "value of " + req.Key})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func decode(t *testing.T, r *http.Request, v any) {
+	t.Helper()
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		t.Errorf("failed to decode request: %v", err)
+	}
+}
+
+func encode(t *testing.T, w http.ResponseWriter, v any) {
+	t.Helper()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Errorf("failed to encode response: %v", err)
+	}
+}
+
+// run executes the command line client against the server and decodes its JSON output into v
+func run(t *testing.T, server *httptest.Server, v any, args ...string) {
+	t.Helper()
+	var out bytes.Buffer
+	cmd := NewRootCommand()
+	cmd.SetOut(&out)
+	cmd.SetArgs(append([]string{"--server", server.URL, "--cli-config", t.TempDir() + "/config.yaml", "-o", "json"}, args...))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("%v failed: %v", args, err)
+	}
+	if err := json.Unmarshal(out.Bytes(), v); err != nil {
+		t.Fatalf("failed to decode the output of %v: %v", args, err)
+	}
+}
+
+// More keys are under the prefix than a search returns
+func TestListingCommandsAreNotCappedBySearch(t *testing.T) {
+	var keys []string
+	var want []string
+	for n := range 2*searchCap + 500 {
+		key := fmt.Sprintf("/app/%02d/key-%05d", n%20, n)
+		keys = append(keys, key)
+		want = append(want, key)
+	}
+	keys = append(keys, "/other/app/key", "/apps/key")
+	slices.Sort(want)
+	server := fakeServer(t, keys)
+
+	t.Run("ls recursive", func(t *testing.T) {
+		var got []string
+		run(t, server, &got, "ls", "-r", "/app/")
+		if !slices.Equal(got, want) {
+			t.Errorf("listed %d keys, want %d", len(got), len(want))
+		}
+	})
+
+	t.Run("ls", func(t *testing.T) {
+		var got []string
+		run(t, server, &got, "ls", "/app/")
+		if len(got) != 20 || got[0] != "/app/00/" || got[19] != "/app/19/" {
+			t.Errorf("listed %v, want the 20 directories under /app/", got)
+		}
+	})
+
+	t.Run("export", func(t *testing.T) {
+		var got []common.KV
+		run(t, server, &got, "export", "/app/")
+		if len(got) != len(want) {
+			t.Fatalf("exported %d keys, want %d", len(got), len(want))
+		}
+		for i, kv := range got {
+			if kv.Key != want[i] || kv.Value != "value of "+want[i] {
+				t.Fatalf("exported %+v, want %s with its value", kv, want[i])
+			}
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/pkg/client"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"go.yaml.in/yaml/v3"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+var outputFormats = []string{outputTable, outputJSON, outputYAML}
+
+// printer renders command results in the selected output format
+type printer struct {
+	out    io.Writer
+	format string
+}
+
+func (p *printer) print(v any) error {
+	switch p.format {
+	case outputJSON:
+		enc := json.NewEncoder(p.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outputYAML:
+		enc := yaml.NewEncoder(p.out)
+		defer enc.Close() //nolint
+		return enc.Encode(v)
+	case outputTable:
+		return p.printTable(v)
+	default:
+		return fmt.Errorf("unsupported output format %q, expected one of %v", p.format, outputFormats)
+	}
+}
+
+// printStreamed renders one item of a stream: JSON as one object per line, YAML as one document per item
+func (p *printer) printStreamed(v any) error {
+	switch p.format {
+	case outputJSON:
+		return json.NewEncoder(p.out).Encode(v)
+	case outputYAML:
+		if _, err := fmt.Fprintln(p.out, "---"); err != nil {
+			return err
+		}
+		return yaml.NewEncoder(p.out).Encode(v)
+	default:
+		return p.print(v)
+	}
+}
+
+func (p *printer) printTable(v any) error {
+	w := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+
+	switch rows := v.(type) {
+	case []string:
+		fmt.Fprintln(w, "KEY") //nolint
+		for _, key := range rows {
+			fmt.Fprintln(w, key) //nolint
+		}
+	case common.KV:
+		fmt.Fprintln(w, "KEY\tVALUE")                    //nolint
+		fmt.Fprintf(w, "%s\t%s\n", rows.Key, rows.Value) //nolint
+	case []common.KV:
+		fmt.Fprintln(w, "KEY\tVALUE") //nolint
+		for _, kv := range rows {
+			fmt.Fprintf(w, "%s\t%s\n", kv.Key, kv.Value) //nolint
+		}
+	case client.WatchEvent:
+		fmt.Fprintf(w, "%s\t%s\t%s\n", rows.Type, rows.Key, rows.Value) //nolint
+	case []client.Change:
+		fmt.Fprintln(w, "ID\tSTATUS\tOPERATION\tKEY\tREQUESTED BY\tCREATED") //nolint
+		for _, change := range rows {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", change.ID, change.Status, change.Operation, change.Key, //nolint
+				change.RequestedBy, change.CreatedAt.Format(time.RFC3339))
+		}
+	case client.Change:
+		fmt.Fprintf(w, "ID:\t%s\n", rows.ID)                                  //nolint
+		fmt.Fprintf(w, "STATUS:\t%s\n", rows.Status)                          //nolint
+		fmt.Fprintf(w, "OPERATION:\t%s %s\n", rows.Operation, rows.Key)       //nolint
+		fmt.Fprintf(w, "BASE REVISION:\t%d\n", rows.BaseRevision)             //nolint
+		fmt.Fprintf(w, "REQUESTED BY:\t%s\n", rows.RequestedBy)               //nolint
+		fmt.Fprintf(w, "CREATED:\t%s\n", rows.CreatedAt.Format(time.RFC3339)) //nolint
+		fmt.Fprintf(w, "EXPIRES:\t%s\n", rows.ExpiresAt.Format(time.RFC3339)) //nolint
+		if rows.DecidedBy != "" {
+			fmt.Fprintf(w, "DECIDED BY:\t%s\n", rows.DecidedBy) //nolint
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(p.out, "\n%s", rows.Diff)
+		return err
+	case []profileRow:
+		fmt.Fprintln(w, "CURRENT\tNAME\tSERVER") //nolint
+		for _, row := range rows {
+			current := ""
+			if row.Current {
+				current = "*"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", current, row.Name, row.Server) //nolint
+		}
+	default:
+		return fmt.Errorf("table output is not supported for %T", v)
+	}
+
+	return w.Flush()
+}
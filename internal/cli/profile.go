@@ -0,0 +1,229 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v3"
+)
+
+const (
+	defaultServer     = "http://localhost:8080"
+	defaultProfile    = "default"
+	envCLIConfig      = "ETCDFINDER_CLI_CONFIG"
+	envServer         = "ETCDFINDER_SERVER"
+	envProfile        = "ETCDFINDER_PROFILE"
+	envAPIKey         = "ETCDFINDER_API_KEY"
+	envToken          = "ETCDFINDER_TOKEN"
+	cliConfigFileName = "cli.yaml"
+)
+
+// Profile holds the connection settings of one etcdfinder server
+type Profile struct {
+	Server string `yaml:"server"`
+	APIKey string `yaml:"api_key,omitempty"`
+	Token  string `yaml:"token,omitempty"`
+}
+
+// Config is the CLI configuration file holding named server profiles
+type Config struct {
+	CurrentProfile string             `yaml:"current_profile"`
+	Profiles       map[string]Profile `yaml:"profiles"`
+}
+
+// defaultConfigPath returns the CLI config path, $XDG_CONFIG_HOME/etcdfinder/cli.yaml on Linux
+func defaultConfigPath() string {
+	if path := os.Getenv(envCLIConfig); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return cliConfigFileName
+	}
+	return filepath.Join(dir, "etcdfinder", cliConfigFileName)
+}
+
+// loadConfig reads the CLI config, a missing file yields an empty config
+func loadConfig(path string) (*Config, error) {
+	conf := &Config{Profiles: map[string]Profile{}}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return conf, nil
+		}
+		return nil, fmt.Errorf("failed to read cli config: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("failed to parse cli config %s: %w", path, err)
+	}
+	if conf.Profiles == nil {
+		conf.Profiles = map[string]Profile{}
+	}
+
+	return conf, nil
+}
+
+func (c *Config) save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode cli config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create cli config directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write cli config: %w", err)
+	}
+	return nil
+}
+
+// resolveProfile returns the profile selected by flag, environment or config, in that order
+func (c *Config) resolveProfile(name string) (string, Profile, error) {
+	if name == "" {
+		name = os.Getenv(envProfile)
+	}
+	if name == "" {
+		name = c.CurrentProfile
+	}
+	if name == "" {
+		name = defaultProfile
+	}
+
+	profile, ok := c.Profiles[name]
+	if !ok && name != defaultProfile {
+		return "", Profile{}, fmt.Errorf("profile %q not found in cli config", name)
+	}
+	return name, profile, nil
+}
+
+func (c *Config) profileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newProfileCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Manage server profiles",
+	}
+
+	completeProfiles := func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		conf, err := loadConfig(opts.configPath)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		return conf.profileNames(), cobra.ShellCompDirectiveNoFileComp
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List profiles",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conf, err := loadConfig(opts.configPath)
+			if err != nil {
+				return err
+			}
+			rows := make([]profileRow, 0, len(conf.Profiles))
+			for _, name := range conf.profileNames() {
+				rows = append(rows, profileRow{
+					Name:    name,
+					Server:  conf.Profiles[name].Server,
+					Current: name == conf.CurrentProfile,
+				})
+			}
+			return opts.printer(cmd).print(rows)
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:               "use <name>",
+		Short:             "Select the profile used by default",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeProfiles,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conf, err := loadConfig(opts.configPath)
+			if err != nil {
+				return err
+			}
+			if _, ok := conf.Profiles[args[0]]; !ok {
+				return fmt.Errorf("profile %q not found in cli config", args[0])
+			}
+			conf.CurrentProfile = args[0]
+			return conf.save(opts.configPath)
+		},
+	})
+
+	var server, apiKey, token string
+	setCmd := &cobra.Command{
+		Use:               "set <name>",
+		Short:             "Create or update a profile",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeProfiles,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conf, err := loadConfig(opts.configPath)
+			if err != nil {
+				return err
+			}
+			profile := conf.Profiles[args[0]]
+			if cmd.Flags().Changed("server") {
+				profile.Server = server
+			}
+			if cmd.Flags().Changed("api-key") {
+				profile.APIKey = apiKey
+			}
+			if cmd.Flags().Changed("token") {
+				profile.Token = token
+			}
+			conf.Profiles[args[0]] = profile
+			if conf.CurrentProfile == "" {
+				conf.CurrentProfile = args[0]
+			}
+			return conf.save(opts.configPath)
+		},
+	}
+	setCmd.Flags().StringVar(&server, "server", "", "URL of the etcdfinder server")
+	setCmd.Flags().StringVar(&apiKey, "api-key", "", "API key to authenticate with")
+	setCmd.Flags().StringVar(&token, "token", "", "bearer token to authenticate with")
+	cmd.AddCommand(setCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:               "delete <name>",
+		Short:             "Delete a profile",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeProfiles,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conf, err := loadConfig(opts.configPath)
+			if err != nil {
+				return err
+			}
+			delete(conf.Profiles, args[0])
+			if conf.CurrentProfile == args[0] {
+				conf.CurrentProfile = ""
+			}
+			return conf.save(opts.configPath)
+		},
+	})
+
+	return cmd
+}
+
+type profileRow struct {
+	Name    string `json:"name" yaml:"name"`
+	Server  string `json:"server" yaml:"server"`
+	Current bool   `json:"current" yaml:"current"`
+}
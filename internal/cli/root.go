@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+
+	"github.com/etcdfinder/etcdfinder/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+// options holds the global flags shared by all commands
+type options struct {
+	configPath string
+	profile    string
+	server     string
+	output     string
+}
+
+// printer returns a printer writing to the command output in the selected format
+func (o *options) printer(cmd *cobra.Command) *printer {
+	return &printer{
+		out:    cmd.OutOrStdout(),
+		format: o.output,
+	}
+}
+
+// client returns a client for the server selected by flag, environment or profile, in that order.
+// Credentials come from the environment, or from the profile if the server does too, so that
+// they are never sent to a server other than the one they were configured for.
+func (o *options) client() (*client.Client, error) {
+	server := o.server
+	if server == "" {
+		server = os.Getenv(envServer)
+	}
+
+	var profile Profile
+	if server == "" {
+		conf, err := loadConfig(o.configPath)
+		if err != nil {
+			return nil, err
+		}
+		_, profile, err = conf.resolveProfile(o.profile)
+		if err != nil {
+			return nil, err
+		}
+		server = profile.Server
+	}
+	if server == "" {
+		server = defaultServer
+	}
+
+	apiKey, token := os.Getenv(envAPIKey), os.Getenv(envToken)
+	if apiKey == "" && token == "" {
+		apiKey, token = profile.APIKey, profile.Token
+	}
+
+	var clientOpts []client.Option
+	if apiKey != "" {
+		clientOpts = append(clientOpts, client.WithAPIKey(apiKey))
+	}
+	if token != "" {
+		clientOpts = append(clientOpts, client.WithBearerToken(token))
+	}
+	return client.New(server, clientOpts...)
+}
+
+// NewRootCommand creates the etcdfinder command line client
+func NewRootCommand() *cobra.Command {
+	opts := &options{}
+
+	cmd := &cobra.Command{
+		Use:           "etcdfinder",
+		Short:         "Search and edit etcd keys through an etcdfinder server",
+		Long:          "Search and edit etcd keys through an etcdfinder server.\nRun without a command (optionally with --config) to start the server.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&opts.configPath, "cli-config", defaultConfigPath(), "Path to the cli configuration file holding server profiles")
+	flags.StringVarP(&opts.profile, "profile", "p", "", "Server profile to use (env "+envProfile+")")
+	flags.StringVarP(&opts.server, "server", "s", "", "URL of the etcdfinder server, overrides the profile (env "+envServer+")")
+	flags.StringVarP(&opts.output, "output", "o", outputTable, fmt.Sprintf("Output format, one of %v", outputFormats))
+
+	_ = cmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions(outputFormats, cobra.ShellCompDirectiveNoFileComp))
+	_ = cmd.RegisterFlagCompletionFunc("profile", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		conf, err := loadConfig(opts.configPath)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		return conf.profileNames(), cobra.ShellCompDirectiveNoFileComp
+	})
+
+	cmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if !slices.Contains(outputFormats, opts.output) {
+			return fmt.Errorf("unsupported output format %q, expected one of %v", opts.output, outputFormats)
+		}
+		return nil
+	}
+
+	cmd.AddCommand(
+		newSearchCommand(opts),
+		newGetCommand(opts),
+		newPutCommand(opts),
+		newRmCommand(opts),
+		newLsCommand(opts),
+		newExportCommand(opts),
+		newWatchCommand(opts),
+		newTUICommand(opts),
+		newChangesCommand(opts),
+		newProfileCommand(opts),
+	)
+
+	return cmd
+}
+
+// IsCommand reports whether name is a command of the command line client rather than a server flag
+func IsCommand(name string) bool {
+	switch name {
+	case "help", "completion", cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+		return true
+	}
+	for _, cmd := range NewRootCommand().Commands() {
+		if cmd.Name() == name || cmd.HasAlias(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Execute runs the command line client with args and returns the process exit code
+func Execute(args []string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cmd := NewRootCommand()
+	cmd.SetArgs(args)
+	if err := cmd.ExecuteContext(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err) //nolint
+		return 1
+	}
+	return 0
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/etcdfinder/etcdfinder/internal/lib"
@@ -8,20 +10,55 @@ import (
 )
 
 type Config struct {
-	Server    ServerConfig    `mapstructure:"server"`
-	Log       LogConfig       `mapstructure:"log"`
-	Etcd      EtcdConfig      `mapstructure:"etcd"`
-	Datastore DatastoreConfig `mapstructure:"datastore"`
+	Server     ServerConfig     `mapstructure:"server"`
+	Log        LogConfig        `mapstructure:"log"`
+	Etcd       EtcdConfig       `mapstructure:"etcd"`
+	Ingestion  IngestionConfig  `mapstructure:"ingestion"`
+	HA         HAConfig         `mapstructure:"ha"`
+	Datastore  DatastoreConfig  `mapstructure:"datastore"`
+	Auth       AuthConfig       `mapstructure:"auth"`
+	Authz      AuthzConfig      `mapstructure:"authz"`
+	Audit      AuditConfig      `mapstructure:"audit"`
+	Approvals  ApprovalsConfig  `mapstructure:"approvals"`
+	Redaction  RedactionConfig  `mapstructure:"redaction"`
+	Encryption EncryptionConfig `mapstructure:"encryption"`
 }
 
 type ServerConfig struct {
-	Port string `mapstructure:"port"`
+	Port                string   `mapstructure:"port"`
+	ReadOnly            bool     `mapstructure:"read_only"`             // removes the routes modifying keys
+	ProtectedPrefixes   []string `mapstructure:"protected_prefixes"`    // keys that cannot be modified through etcdfinder
+	ReadyMaxLag         int64    `mapstructure:"ready_max_lag"`         // revisions the index may lag behind etcd while ready, 0 for no limit
+	ShutdownGracePeriod int64    `mapstructure:"shutdown_grace_period"` // in seconds
 }
 
 type LogConfig struct {
 	Level lib.LogLevel `mapstructure:"level"`
 }
 
+type IngestionConfig struct {
+	BatchWindow     int64 `mapstructure:"batch_window"` // in milliseconds
+	BatchSize       int   `mapstructure:"batch_size"`
+	SyncPartitions  int   `mapstructure:"sync_partitions"`  // ranges of keys read concurrently by the initial sync
+	SyncConcurrency int   `mapstructure:"sync_concurrency"` // pages written concurrently by the initial sync
+
+	ReconcileInterval  int64 `mapstructure:"reconcile_interval"`   // in seconds, 0 disables the reconciler
+	ReconcileRateLimit int   `mapstructure:"reconcile_rate_limit"` // keys read per second, 0 for no limit
+	CheckpointInterval int64 `mapstructure:"checkpoint_interval"`  // in seconds
+
+	RetryBackoff    int64 `mapstructure:"retry_backoff"`     // in milliseconds, doubled after every failed restart of the watch
+	MaxRetryBackoff int64 `mapstructure:"max_retry_backoff"` // in milliseconds
+	OutageBudget    int64 `mapstructure:"outage_budget"`     // in seconds, 0 never gives up
+}
+
+// HAConfig elects a single replica to ingest into the datastore shared by all of them
+type HAConfig struct {
+	Enabled        bool   `mapstructure:"enabled"`
+	ElectionPrefix string `mapstructure:"election_prefix"` // etcd prefix the replicas campaign on, required, outside of root_etcd_prefix and of the grants
+	LeaseTTL       int64  `mapstructure:"lease_ttl"`       // in seconds, until another replica takes over a stopped leader
+	Identity       string `mapstructure:"identity"`        // name of the replica in the election, the hostname if empty
+}
+
 type DatastoreConfig struct {
 	Type        string            `mapstructure:"type"`
 	Meilisearch MeilisearchConfig `mapstructure:"meilisearch"`
@@ -41,6 +78,111 @@ type MeilisearchConfig struct {
 	Host             string `mapstructure:"host"`
 	IndexName        string `mapstructure:"index_name"`
 	MatchingStrategy string `mapstructure:"matching_strategy"`
+	TaskTimeout      int64  `mapstructure:"task_timeout"`       // in seconds
+	TaskPollInterval int64  `mapstructure:"task_poll_interval"` // in milliseconds
+}
+
+type AuthConfig struct {
+	Enabled bool           `mapstructure:"enabled"`
+	APIKeys []APIKeyConfig `mapstructure:"api_keys"`
+	JWT     JWTConfig      `mapstructure:"jwt"`
+	OIDC    OIDCConfig     `mapstructure:"oidc"`
+}
+
+type APIKeyConfig struct {
+	Name   string   `mapstructure:"name"`
+	SHA256 string   `mapstructure:"sha256"` // hex encoded SHA-256 of the key, the key itself is never configured
+	Groups []string `mapstructure:"groups"`
+}
+
+type JWTConfig struct {
+	JWKSFile            string `mapstructure:"jwks_file"`
+	JWKSURL             string `mapstructure:"jwks_url"`
+	JWKSRefreshInterval int64  `mapstructure:"jwks_refresh_interval"` // in seconds
+	Issuer              string `mapstructure:"issuer"`
+	Audience            string `mapstructure:"audience"`
+	GroupsClaim         string `mapstructure:"groups_claim"`
+}
+
+type OIDCConfig struct {
+	IssuerURL     string   `mapstructure:"issuer_url"`
+	ClientID      string   `mapstructure:"client_id"`
+	ClientSecret  string   `mapstructure:"client_secret"`
+	RedirectURL   string   `mapstructure:"redirect_url"` // URL of /auth/callback as reached by browsers
+	Scopes        []string `mapstructure:"scopes"`
+	GroupsClaim   string   `mapstructure:"groups_claim"`
+	SessionSecret string   `mapstructure:"session_secret"` // key signing the session cookies
+	SessionTTL    int64    `mapstructure:"session_ttl"`    // in seconds
+	SecureCookie  bool     `mapstructure:"secure_cookie"`
+}
+
+type AuthzConfig struct {
+	Enabled  bool            `mapstructure:"enabled"`
+	Roles    []RoleConfig    `mapstructure:"roles"`
+	Bindings []BindingConfig `mapstructure:"bindings"`
+}
+
+// RoleConfig grants permissions on the keys matched by its grants
+type RoleConfig struct {
+	Name   string        `mapstructure:"name"`
+	Grants []GrantConfig `mapstructure:"grants"`
+}
+
+// GrantConfig matches keys by either prefix or glob
+type GrantConfig struct {
+	Prefix      string   `mapstructure:"prefix"`
+	Glob        string   `mapstructure:"glob"`
+	Permissions []string `mapstructure:"permissions"`
+}
+
+// BindingConfig assigns a role to groups and subjects
+type BindingConfig struct {
+	Role     string   `mapstructure:"role"`
+	Groups   []string `mapstructure:"groups"`
+	Subjects []string `mapstructure:"subjects"`
+}
+
+type AuditConfig struct {
+	Enabled    bool               `mapstructure:"enabled"`
+	Sink       lib.AuditSink      `mapstructure:"sink"`
+	HashSecret string             `mapstructure:"hash_secret"` // keys the HMAC of the values in the records, required
+	File       AuditFileConfig    `mapstructure:"file"`
+	Webhook    AuditWebhookConfig `mapstructure:"webhook"`
+}
+
+type AuditFileConfig struct {
+	Path string `mapstructure:"path"`
+}
+
+type AuditWebhookConfig struct {
+	URL     string            `mapstructure:"url"`
+	Headers map[string]string `mapstructure:"headers"`
+	Timeout int64             `mapstructure:"timeout"` // in seconds
+}
+
+type ApprovalsConfig struct {
+	Enabled          bool     `mapstructure:"enabled"`
+	CriticalPatterns []string `mapstructure:"critical_patterns"` // globs of the keys whose changes need an approval
+	TTL              int64    `mapstructure:"ttl"`               // in seconds
+	Prefix           string   `mapstructure:"prefix"`            // etcd prefix the changes are stored under, required, outside of root_etcd_prefix and of the grants
+}
+
+type RedactionConfig struct {
+	Enabled     bool                    `mapstructure:"enabled"`
+	KeyPatterns []string                `mapstructure:"key_patterns"` // globs of the keys whose values are always redacted
+	Detectors   []lib.RedactionDetector `mapstructure:"detectors"`
+	Entropy     EntropyConfig           `mapstructure:"entropy"`
+}
+
+type EntropyConfig struct {
+	MinLength int     `mapstructure:"min_length"` // shorter tokens are never redacted
+	Threshold float64 `mapstructure:"threshold"`  // in bits per character
+}
+
+type EncryptionConfig struct {
+	Enabled     bool     `mapstructure:"enabled"`
+	Prefixes    []string `mapstructure:"prefixes"`     // keys whose values are encrypted when written through etcdfinder
+	KeyringFile string   `mapstructure:"keyring_file"` // key-encryption keys wrapping the data keys
 }
 
 func Load(configPath string) (*Config, error) {
@@ -65,6 +207,86 @@ func Load(configPath string) (*Config, error) {
 	if err := viper.Unmarshal(&config); err != nil {
 		return nil, err
 	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 
 	return &config, nil
 }
+
+// Validate checks the settings etcdfinder cannot run safely without, such as the keys it stores in etcd for itself
+// being out of reach of the API
+func (c *Config) Validate() error {
+	if c.Approvals.Enabled {
+		if err := c.validateInternalPrefix("approvals.prefix", c.Approvals.Prefix); err != nil {
+			return err
+		}
+	}
+	if c.Audit.Enabled && c.Audit.HashSecret == "" {
+		return fmt.Errorf("audit.hash_secret must be set")
+	}
+	if c.HA.Enabled {
+		if err := c.validateInternalPrefix("ha.election_prefix", c.HA.ElectionPrefix); err != nil {
+			return err
+		}
+		if c.Approvals.Enabled && overlaps(c.HA.ElectionPrefix, c.Approvals.Prefix) {
+			return fmt.Errorf("ha.election_prefix %q overlaps approvals.prefix %q", c.HA.ElectionPrefix, c.Approvals.Prefix)
+		}
+	}
+	return nil
+}
+
+// InternalPrefixes returns the prefixes of the keys etcdfinder stores in etcd for itself, which cannot be
+// modified through the API
+func (c *Config) InternalPrefixes() []string {
+	var prefixes []string
+	if c.Approvals.Enabled {
+		prefixes = append(prefixes, c.Approvals.Prefix)
+	}
+	if c.HA.Enabled {
+		prefixes = append(prefixes, c.HA.ElectionPrefix)
+	}
+	return prefixes
+}
+
+// validateInternalPrefix checks that the keys under the prefix are neither indexed nor granted to be modified
+func (c *Config) validateInternalPrefix(name, prefix string) error {
+	if prefix == "" {
+		return fmt.Errorf("%s must be set", name)
+	}
+	if overlaps(prefix, c.Etcd.RootPrefixEtcd) {
+		return fmt.Errorf("%s %q overlaps etcd.root_etcd_prefix %q, its keys would be indexed", name, prefix, c.Etcd.RootPrefixEtcd)
+	}
+	if !c.Authz.Enabled {
+		return nil
+	}
+	for _, role := range c.Authz.Roles {
+		for _, grant := range role.Grants {
+			if !slices.Contains(grant.Permissions, "write") && !slices.Contains(grant.Permissions, "delete") {
+				continue
+			}
+			if grant.reaches(prefix) {
+				return fmt.Errorf("%s %q overlaps a grant of role %q modifying %q", name, prefix, role.Name, grant.Prefix+grant.Glob)
+			}
+		}
+	}
+	return nil
+}
+
+// reaches returns whether the grant may match keys under the prefix. Globs are compared by their part before
+// the first wildcard, a glob without wildcards matching a single key.
+func (g GrantConfig) reaches(prefix string) bool {
+	if g.Prefix != "" {
+		return overlaps(prefix, g.Prefix)
+	}
+	wildcard := strings.IndexAny(g.Glob, "*?")
+	if wildcard < 0 {
+		return strings.HasPrefix(g.Glob, prefix)
+	}
+	return overlaps(prefix, g.Glob[:wildcard])
+}
+
+// overlaps returns whether some keys are under both prefixes
+func overlaps(a, b string) bool {
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
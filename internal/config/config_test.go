@@ -0,0 +1,100 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateInternalPrefixes(t *testing.T) {
+	grant := func(prefix, glob string, permissions ...string) AuthzConfig {
+		return AuthzConfig{Enabled: true, Roles: []RoleConfig{{
+			Name:   "editor",
+			Grants: []GrantConfig{{Prefix: prefix, Glob: glob, Permissions: permissions}},
+		}}}
+	}
+
+	tests := []struct {
+		name    string
+		root    string
+		prefix  string
+		authz   AuthzConfig
+		wantErr string
+	}{
+		{name: "outside of the root", root: "/app/", prefix: "/etcdfinder/changes"},
+		{name: "not set", root: "/app/", wantErr: "must be set"},
+		{name: "under the root", root: "", prefix: "/etcdfinder/changes", wantErr: "root_etcd_prefix"},
+		{name: "containing the root", root: "/etcdfinder/changes/app/", prefix: "/etcdfinder/changes", wantErr: "root_etcd_prefix"},
+		{name: "prefix granted read", root: "/app/", prefix: "/etcdfinder/changes", authz: grant("/etcdfinder/", "", "read")},
+		{name: "prefix granted write", root: "/app/", prefix: "/etcdfinder/changes", authz: grant("/etcdfinder/", "", "read", "write"), wantErr: `role "editor"`},
+		{name: "under a glob granted delete", root: "/app/", prefix: "/etcdfinder/changes", authz: grant("", "/etcdfinder/**", "delete"), wantErr: `role "editor"`},
+		{name: "catch-all glob", root: "/app/", prefix: "/etcdfinder/changes", authz: grant("", "**", "write"), wantErr: `role "editor"`},
+		{name: "other glob", root: "/app/", prefix: "/etcdfinder/changes", authz: grant("", "/app/*/config", "write")},
+		{name: "glob without wildcards", root: "/app/", prefix: "/etcdfinder/changes", authz: grant("", "/etcdfinder", "write")},
+		{name: "grants ignored without authz", root: "/app/", prefix: "/etcdfinder/changes", authz: AuthzConfig{Roles: grant("", "**", "write").Roles}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{
+				Etcd:      EtcdConfig{RootPrefixEtcd: tt.root},
+				Authz:     tt.authz,
+				Approvals: ApprovalsConfig{Enabled: true, Prefix: tt.prefix},
+			}
+			err := c.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Validate: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate = %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+
+	// The prefixes are only checked when their feature is enabled
+	if err := (&Config{}).Validate(); err != nil {
+		t.Errorf("Validate with approvals and high availability disabled: %v", err)
+	}
+}
+
+func TestValidateElectionPrefix(t *testing.T) {
+	tests := []struct {
+		name      string
+		election  string
+		approvals string
+		wantErr   string
+	}{
+		{name: "outside of the root", election: "/etcdfinder/election", approvals: "/etcdfinder/changes"},
+		{name: "not set", wantErr: "must be set"},
+		{name: "under the root", election: "/app/election", wantErr: "root_etcd_prefix"},
+		{name: "overlapping the changes", election: "/etcdfinder/", approvals: "/etcdfinder/changes", wantErr: "approvals.prefix"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{
+				Etcd:      EtcdConfig{RootPrefixEtcd: "/app/"},
+				HA:        HAConfig{Enabled: true, ElectionPrefix: tt.election},
+				Approvals: ApprovalsConfig{Enabled: tt.approvals != "", Prefix: tt.approvals},
+			}
+			err := c.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Validate: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate = %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+
+	c := &Config{HA: HAConfig{Enabled: true, ElectionPrefix: "/etcdfinder/election"}, Approvals: ApprovalsConfig{Enabled: true, Prefix: "/etcdfinder/changes"}}
+	if got := c.InternalPrefixes(); len(got) != 2 || got[0] != "/etcdfinder/changes" || got[1] != "/etcdfinder/election" {
+		t.Errorf("InternalPrefixes = %v, want the approvals and election prefixes", got)
+	}
+}
+
+func TestValidateAuditSecret(t *testing.T) {
+	if err := (&Config{Audit: AuditConfig{Enabled: true}}).Validate(); err == nil || !strings.Contains(err.Error(), "audit.hash_secret") {
+		t.Errorf("Validate without a secret = %v, want an error about audit.hash_secret", err)
+	}
+	if err := (&Config{Audit: AuditConfig{Enabled: true, HashSecret: "secret"}}).Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
@@ -1,9 +1,12 @@
 package customerrors
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+
+	cerrors "github.com/cockroachdb/errors"
 )
 
 var (
@@ -13,6 +16,17 @@ var (
 	ErrKeyNotFound           = new(ErrKeyNotFoundCode, "key not found")
 	ErrKeyNotPut             = new(ErrKeyNotPutCode, "key not put")
 	ErrKeyNotDeleted         = new(ErrKeyNotDeletedCode, "key not deleted")
+	ErrInvalidPagination     = new(ErrInvalidPaginationCode, "invalid pagination parameters")
+	ErrPreconditionFailed    = new(ErrPreconditionFailedCode, "precondition failed")
+	ErrNotAcceptable         = new(ErrNotAcceptableCode, "none of the accepted content types can be produced")
+	ErrUnauthenticated       = new(ErrUnauthenticatedCode, "authentication required")
+	ErrForbidden             = new(ErrForbiddenCode, "permission denied")
+	ErrKeyProtected          = new(ErrKeyProtectedCode, "key is protected")
+	ErrApprovalRequired      = new(ErrApprovalRequiredCode, "change awaits approval")
+	ErrChangeNotFound        = new(ErrChangeNotFoundCode, "change not found")
+	ErrChangeNotPending      = new(ErrChangeNotPendingCode, "change is not pending")
+	ErrChangeStale           = new(ErrChangeStaleCode, "key changed since the change was requested")
+	ErrValueRedacted         = new(ErrValueRedactedCode, "the redacted placeholder cannot be written")
 )
 
 var statusCodeMap = map[error]int{
@@ -22,6 +36,17 @@ var statusCodeMap = map[error]int{
 	ErrKeyNotFound:           http.StatusNotFound,
 	ErrKeyNotPut:             http.StatusInternalServerError,
 	ErrKeyNotDeleted:         http.StatusInternalServerError,
+	ErrInvalidPagination:     http.StatusBadRequest,
+	ErrPreconditionFailed:    http.StatusPreconditionFailed,
+	ErrNotAcceptable:         http.StatusNotAcceptable,
+	ErrUnauthenticated:       http.StatusUnauthorized,
+	ErrForbidden:             http.StatusForbidden,
+	ErrKeyProtected:          http.StatusForbidden,
+	ErrApprovalRequired:      http.StatusAccepted,
+	ErrChangeNotFound:        http.StatusNotFound,
+	ErrChangeNotPending:      http.StatusConflict,
+	ErrChangeStale:           http.StatusConflict,
+	ErrValueRedacted:         http.StatusBadRequest,
 }
 
 const (
@@ -31,6 +56,17 @@ const (
 	ErrKeyNotFoundCode           = "KEY_NOT_FOUND"
 	ErrKeyNotPutCode             = "KEY_NOT_PUT"
 	ErrKeyNotDeletedCode         = "KEY_NOT_DELETED"
+	ErrInvalidPaginationCode     = "INVALID_PAGINATION"
+	ErrPreconditionFailedCode    = "PRECONDITION_FAILED"
+	ErrNotAcceptableCode         = "NOT_ACCEPTABLE"
+	ErrUnauthenticatedCode       = "UNAUTHENTICATED"
+	ErrForbiddenCode             = "FORBIDDEN"
+	ErrKeyProtectedCode          = "KEY_PROTECTED"
+	ErrApprovalRequiredCode      = "APPROVAL_REQUIRED"
+	ErrChangeNotFoundCode        = "CHANGE_NOT_FOUND"
+	ErrChangeNotPendingCode      = "CHANGE_NOT_PENDING"
+	ErrChangeStaleCode           = "CHANGE_STALE"
+	ErrValueRedactedCode         = "VALUE_REDACTED"
 )
 
 // InternalError represents a domain error
@@ -60,6 +96,25 @@ func new(code string, message string) *InternalError {
 	}
 }
 
+// CodeFromErr returns the machine-readable code of the first InternalError in the chain
+func CodeFromErr(err error) string {
+	var internalErr *InternalError
+	if errors.As(err, &internalErr) {
+		return internalErr.Code
+	}
+	return ""
+}
+
+// ErrFromCode returns the sentinel error registered for the given code, or nil if unknown
+func ErrFromCode(code string) error {
+	for e := range statusCodeMap {
+		if internalErr, ok := e.(*InternalError); ok && internalErr.Code == code {
+			return internalErr
+		}
+	}
+	return nil
+}
+
 func HTTPStatusFromErr(err error) int {
 	for e, status := range statusCodeMap {
 		if errors.Is(err, e) {
@@ -68,3 +123,12 @@ func HTTPStatusFromErr(err error) int {
 	}
 	return http.StatusInternalServerError
 }
+
+// WithDetails attaches details to err, returned in the details of the error response
+func WithDetails(err error, details map[string]any) error {
+	payload, jsonErr := json.Marshal(details)
+	if jsonErr != nil {
+		return err
+	}
+	return cerrors.WithSafeDetails(err, "__json__:%s", cerrors.Safe(string(payload)))
+}
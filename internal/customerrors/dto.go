@@ -8,6 +8,7 @@ type ErrorResponse struct {
 
 // ErrorDetail contains error information
 type ErrorDetail struct {
+	Code          string         `json:"code,omitempty"`
 	Display       string         `json:"message"`
 	InternalError string         `json:"internal_error,omitempty"`
 	Details       map[string]any `json:"details,omitempty"`
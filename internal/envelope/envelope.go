@@ -0,0 +1,108 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// prefix marks the values encrypted by etcdfinder, followed by the base64 encoded JSON envelope
+const prefix = "etcdfinder:enc:v1:"
+
+// sealed is an encrypted value along with its data key, wrapped by a key-encryption key
+type sealed struct {
+	KeyID      string `json:"kid"`
+	DataKey    []byte `json:"dk"` // nonce followed by the data key sealed with the key-encryption key
+	Ciphertext []byte `json:"ct"` // nonce followed by the value sealed with the data key
+}
+
+// IsEncrypted returns whether the value was encrypted by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Encrypt seals the value of the key with a new data key, wrapped with the primary key of the keyring.
+// The key is authenticated, so that the value cannot be decrypted once copied to another key.
+func (k *Keyring) Encrypt(key, value string) (string, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	wrapped, err := seal(k.keys[k.primary], dataKey, []byte(k.primary))
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(value), []byte(key))
+	if err != nil {
+		return "", err
+	}
+
+	envelope, err := json.Marshal(sealed{KeyID: k.primary, DataKey: wrapped, Ciphertext: ciphertext})
+	if err != nil {
+		return "", err
+	}
+	return prefix + base64.StdEncoding.EncodeToString(envelope), nil
+}
+
+// Decrypt opens a value of the key returned by Encrypt
+func (k *Keyring) Decrypt(key, value string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, prefix))
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value of %s: %w", key, err)
+	}
+	var envelope sealed
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return "", fmt.Errorf("malformed encrypted value of %s: %w", key, err)
+	}
+
+	kek, ok := k.keys[envelope.KeyID]
+	if !ok {
+		return "", fmt.Errorf("value of %s is encrypted with key %q, which is not in the keyring", key, envelope.KeyID)
+	}
+	dataKey, err := open(kek, envelope.DataKey, []byte(envelope.KeyID))
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap the data key of %s: %w", key, err)
+	}
+	plaintext, err := open(dataKey, envelope.Ciphertext, []byte(key))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt the value of %s: %w", key, err)
+	}
+	return string(plaintext), nil
+}
+
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
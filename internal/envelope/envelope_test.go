@@ -0,0 +1,243 @@
+package envelope
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+	"go.yaml.in/yaml/v3"
+)
+
+// newKeyring writes a keyring file with the keys and loads it
+func newKeyring(t *testing.T, primary string, keys map[string][]byte) *Keyring {
+	t.Helper()
+	file := keyringFile{Primary: primary, Keys: map[string]string{}}
+	for id, key := range keys {
+		file.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	data, err := yaml.Marshal(file)
+	if err != nil {
+		t.Fatalf("failed to encode keyring: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "keyring.yaml")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write keyring: %v", err)
+	}
+	keyring, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	return keyring
+}
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return key
+}
+
+// envelopeOf decodes the envelope of an encrypted value
+func envelopeOf(t *testing.T, value string) sealed {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, prefix))
+	if err != nil {
+		t.Fatalf("malformed value: %v", err)
+	}
+	var envelope sealed
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		t.Fatalf("malformed envelope: %v", err)
+	}
+	return envelope
+}
+
+// encode encodes the envelope as an encrypted value
+func encode(t *testing.T, envelope sealed) string {
+	t.Helper()
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatalf("failed to encode envelope: %v", err)
+	}
+	return prefix + base64.StdEncoding.EncodeToString(data)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	keyring := newKeyring(t, "k1", map[string][]byte{"k1": newKey(t)})
+
+	for _, value := range []string{"hunter2", "", strings.Repeat("x", 1<<16)} {
+		encrypted, err := keyring.Encrypt("/app/secret", value)
+		if err != nil {
+			t.Fatalf("Encrypt: %v", err)
+		}
+		if !IsEncrypted(encrypted) || (value != "" && strings.Contains(encrypted, value)) {
+			t.Errorf("value is not encrypted: %.40s", encrypted)
+		}
+		decrypted, err := keyring.Decrypt("/app/secret", encrypted)
+		if err != nil {
+			t.Fatalf("Decrypt: %v", err)
+		}
+		if decrypted != value {
+			t.Errorf("decrypted %.40q, want %.40q", decrypted, value)
+		}
+	}
+
+	// Every value has its own data key and nonces
+	a, _ := keyring.Encrypt("/app/secret", "hunter2")
+	b, _ := keyring.Encrypt("/app/secret", "hunter2")
+	if a == b {
+		t.Errorf("the same value encrypted twice gives the same ciphertext")
+	}
+	if IsEncrypted("hunter2") {
+		t.Errorf("plaintext reported encrypted")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, newKeyBytes := newKey(t), newKey(t)
+	before := newKeyring(t, "k1", map[string][]byte{"k1": oldKey})
+	encrypted, err := before.Encrypt("/app/secret", "hunter2")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	// The old key stays in the keyring to decrypt the values written before the rotation
+	rotated := newKeyring(t, "k2", map[string][]byte{"k1": oldKey, "k2": newKeyBytes})
+	if decrypted, err := rotated.Decrypt("/app/secret", encrypted); err != nil || decrypted != "hunter2" {
+		t.Errorf("Decrypt after rotation = %q, %v", decrypted, err)
+	}
+	reencrypted, err := rotated.Encrypt("/app/secret", "hunter2")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if kid := envelopeOf(t, reencrypted).KeyID; kid != "k2" {
+		t.Errorf("encrypted with key %q, want the primary key k2", kid)
+	}
+
+	// Once the old key is removed, its values cannot be decrypted anymore
+	removed := newKeyring(t, "k2", map[string][]byte{"k2": newKeyBytes})
+	if _, err := removed.Decrypt("/app/secret", encrypted); err == nil {
+		t.Errorf("decrypted a value whose key was removed from the keyring")
+	}
+	if decrypted, err := removed.Decrypt("/app/secret", reencrypted); err != nil || decrypted != "hunter2" {
+		t.Errorf("Decrypt with the new key = %q, %v", decrypted, err)
+	}
+}
+
+func TestTamperDetection(t *testing.T) {
+	keyring := newKeyring(t, "k1", map[string][]byte{"k1": newKey(t), "k2": newKey(t)})
+	encrypted, err := keyring.Encrypt("/app/secret", "hunter2")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		key   string
+		value func() string
+	}{
+		{name: "flipped ciphertext", key: "/app/secret", value: func() string {
+			envelope := envelopeOf(t, encrypted)
+			envelope.Ciphertext[len(envelope.Ciphertext)-1] ^= 1
+			return encode(t, envelope)
+		}},
+		{name: "flipped data key", key: "/app/secret", value: func() string {
+			envelope := envelopeOf(t, encrypted)
+			envelope.DataKey[len(envelope.DataKey)-1] ^= 1
+			return encode(t, envelope)
+		}},
+		{name: "swapped key ID", key: "/app/secret", value: func() string {
+			envelope := envelopeOf(t, encrypted)
+			envelope.KeyID = "k2"
+			return encode(t, envelope)
+		}},
+		{name: "unknown key ID", key: "/app/secret", value: func() string {
+			envelope := envelopeOf(t, encrypted)
+			envelope.KeyID = "k3"
+			return encode(t, envelope)
+		}},
+		{name: "copied to another key", key: "/app/other", value: func() string { return encrypted }},
+		{name: "truncated", key: "/app/secret", value: func() string { return encrypted[:len(encrypted)/2] }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if decrypted, err := keyring.Decrypt(tt.key, tt.value()); err == nil {
+				t.Errorf("decrypted tampered value as %q", decrypted)
+			}
+		})
+	}
+}
+
+func TestLoadKeyringRejectsInvalidKeys(t *testing.T) {
+	for name, content := range map[string]string{
+		"missing primary": "primary: k2\nkeys:\n  k1: " + base64.StdEncoding.EncodeToString(newKey(t)),
+		"short key":       "primary: k1\nkeys:\n  k1: " + base64.StdEncoding.EncodeToString([]byte("short")),
+		"not base64":      "primary: k1\nkeys:\n  k1: '%%%'",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keyring.yaml")
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatalf("failed to write keyring: %v", err)
+			}
+			if _, err := LoadKeyring(path); err == nil {
+				t.Errorf("LoadKeyring succeeded")
+			}
+		})
+	}
+}
+
+// recordingStore records the values put, the methods the tests do not use panic
+type recordingStore struct {
+	kvstore.KVStore
+	values map[string]string
+}
+
+func (s *recordingStore) Put(ctx context.Context, key, value string) error {
+	s.values[key] = value
+	return nil
+}
+
+func (s *recordingStore) PutBatch(ctx context.Context, kvs []common.KV) error {
+	for _, kv := range kvs {
+		s.values[kv.Key] = kv.Value
+	}
+	return nil
+}
+
+func TestKVStoreStripsEncryptedValues(t *testing.T) {
+	keyring := newKeyring(t, "k1", map[string][]byte{"k1": newKey(t)})
+	encrypted, err := keyring.Encrypt("/app/secret", "hunter2")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	next := &recordingStore{values: map[string]string{}}
+	store := NewKVStore(next)
+	ctx := context.Background()
+	if err := store.Put(ctx, "/app/secret", encrypted); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	kvs := []common.KV{{Key: "/app/batch-secret", Value: encrypted, ModRevision: 3}, {Key: "/app/host", Value: "db.local", ModRevision: 4}}
+	if err := store.PutBatch(ctx, kvs); err != nil {
+		t.Fatalf("PutBatch: %v", err)
+	}
+
+	want := map[string]string{"/app/secret": "", "/app/batch-secret": "", "/app/host": "db.local"}
+	for key, value := range want {
+		if got, ok := next.values[key]; !ok || got != value {
+			t.Errorf("indexed %s = %.40q, want %q", key, got, value)
+		}
+	}
+	// The batch of the caller is left untouched
+	if kvs[0].Value != encrypted {
+		t.Errorf("PutBatch modified the batch of the caller")
+	}
+}
@@ -0,0 +1,55 @@
+package envelope
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// size of the key-encryption keys and data keys, selecting AES-256
+const keySize = 32
+
+// keyringFile is the format of the keyring file, keys are base64 encoded
+type keyringFile struct {
+	Primary string            `yaml:"primary"`
+	Keys    map[string]string `yaml:"keys"`
+}
+
+// Keyring holds the key-encryption keys by ID. New data keys are wrapped with the primary key, the other
+// keys only unwrap the data keys of values written before the primary key was rotated.
+type Keyring struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// LoadKeyring reads the keyring file
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+
+	var file keyringFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring: %w", err)
+	}
+
+	k := &Keyring{primary: file.Primary, keys: map[string][]byte{}}
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q is not base64 encoded: %w", id, err)
+		}
+		if len(key) != keySize {
+			return nil, fmt.Errorf("key %q is %d bytes long, expected %d", id, len(key), keySize)
+		}
+		k.keys[id] = key
+	}
+	if _, ok := k.keys[k.primary]; !ok {
+		return nil, fmt.Errorf("primary key %q not found in keyring", k.primary)
+	}
+
+	return k, nil
+}
@@ -0,0 +1,38 @@
+package envelope
+
+import (
+	"context"
+
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+)
+
+// kvStore indexes the encrypted values with an empty value, so that only their key can be searched
+type kvStore struct {
+	kvstore.KVStore
+}
+
+// NewKVStore wraps the store to keep encrypted values out of it
+func NewKVStore(next kvstore.KVStore) kvstore.KVStore {
+	return &kvStore{KVStore: next}
+}
+
+func (s *kvStore) Put(ctx context.Context, key, value string) error {
+	return s.KVStore.Put(ctx, key, strip(value))
+}
+
+func (s *kvStore) PutBatch(ctx context.Context, kvs []common.KV) error {
+	stripped := make([]common.KV, len(kvs))
+	for i, kv := range kvs {
+		stripped[i] = kv
+		stripped[i].Value = strip(kv.Value)
+	}
+	return s.KVStore.PutBatch(ctx, stripped)
+}
+
+func strip(value string) string {
+	if IsEncrypted(value) {
+		return ""
+	}
+	return value
+}
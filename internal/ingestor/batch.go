@@ -0,0 +1,58 @@
+package ingestor
+
+import (
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+)
+
+// eventBatch accumulates watch events to write them to the KV store at once
+type eventBatch struct {
+	events []etcd.WatchEvent // all events in the order received, to be published
+	latest map[string]int    // index in events of the latest event of each key
+	// revision etcd reported every event up to, applied once the events are written, 0 if none
+	progress int64
+}
+
+func newEventBatch() *eventBatch {
+	return &eventBatch{latest: map[string]int{}}
+}
+
+func (b *eventBatch) add(event etcd.WatchEvent) {
+	b.latest[event.Key] = len(b.events)
+	b.events = append(b.events, event)
+}
+
+func (b *eventBatch) len() int {
+	return len(b.events)
+}
+
+// changes returns the latest value of the keys put and the keys deleted by the batch,
+// earlier events of the same key being superseded
+func (b *eventBatch) changes() ([]common.KV, []string) {
+	var puts []common.KV
+	var deletes []string
+	for idx, event := range b.events {
+		if b.latest[event.Key] != idx {
+			continue
+		}
+		switch event.Type {
+		case "PUT":
+			puts = append(puts, common.KV{
+				Key:         event.Key,
+				Value:       event.Value,
+				ModRevision: event.Revision,
+				ValueHash:   kvstore.HashValue(event.Value),
+			})
+		case "DELETE":
+			deletes = append(deletes, event.Key)
+		}
+	}
+	return puts, deletes
+}
+
+func (b *eventBatch) reset() {
+	b.events = nil
+	b.progress = 0
+	clear(b.latest)
+}
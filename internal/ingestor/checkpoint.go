@@ -0,0 +1,166 @@
+package ingestor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+// Checkpointer persists the last applied revision to the KV store every checkpoint interval once it is searchable,
+// so that the process taking over the ingestion resumes from it. Failed saves are logged and retried at the next
+// interval.
+func (i *Ingestor) Checkpointer(ctx context.Context) error {
+	select {
+	case <-i.readyCh:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	ticker := time.NewTicker(i.checkpointInterval)
+	defer ticker.Stop()
+
+	var saved int64
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			saved = i.checkpoint(ctx, saved)
+		}
+	}
+}
+
+// Checkpoint saves the last applied revision, nothing is saved while the initial sync runs
+func (i *Ingestor) Checkpoint(ctx context.Context) error {
+	revision := i.lastApplied()
+	if revision == 0 {
+		return nil
+	}
+	if err := i.kvStore.SaveCheckpoint(ctx, revision); err != nil {
+		return err
+	}
+	logger.Infof("Saved the checkpoint at revision %d", revision)
+	return nil
+}
+
+// checkpoint saves the last applied revision if newer than the saved one, and returns the revision saved last
+func (i *Ingestor) checkpoint(ctx context.Context, saved int64) int64 {
+	revision := i.lastApplied()
+	if revision <= saved {
+		return saved
+	}
+	if err := i.kvStore.SaveCheckpoint(ctx, revision); err != nil {
+		logger.Errorf("Failed to save the checkpoint at revision %d: %v", revision, err)
+		return saved
+	}
+	logger.Debugf("Saved the checkpoint at revision %d", revision)
+	return revision
+}
+
+// Follow reads the checkpoint persisted by the process ingesting into the KV store every checkpoint interval,
+// reporting the KV store searchable and up to date with that revision, until ctx is done. The changes of etcd are
+// published to the subscribers meanwhile, as the process ingesting only publishes to its own.
+func (i *Ingestor) Follow(ctx context.Context) error {
+	// Relay the changes after the last applied revision, if this process ingested before, or the current ones
+	fromRevision := i.lastApplied()
+	if fromRevision > 0 {
+		fromRevision++
+	}
+	relayed := make(chan struct{})
+	go func() {
+		defer close(relayed)
+		i.relay(ctx, fromRevision)
+	}()
+	defer func() { <-relayed }()
+
+	ticker := time.NewTicker(i.checkpointInterval)
+	defer ticker.Stop()
+
+	for {
+		revision, err := i.kvStore.LoadCheckpoint(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			logger.Errorf("Failed to load the checkpoint: %v", err)
+		case revision > 0:
+			i.setApplied(revision)
+			i.markReady()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay publishes the changes of etcd from fromRevision, 0 for the current one, to the subscribers without writing
+// them to the KV store, until ctx is done. The watch is restarted after the last published event with an exponential
+// backoff whenever it fails.
+func (i *Ingestor) relay(ctx context.Context, fromRevision int64) {
+	attempt := 0
+	for {
+		revision, err := i.relayWatch(ctx, fromRevision)
+		if ctx.Err() != nil {
+			return
+		}
+		if revision > 0 {
+			fromRevision = revision + 1
+			attempt = 0
+		}
+		if errors.Is(err, etcd.ErrCompacted) {
+			// The changes since the last published event are lost, carry on with the current ones
+			fromRevision = 0
+		}
+		if err == nil {
+			err = errWatchClosed
+		}
+
+		delay := i.backoff(attempt)
+		attempt++
+		logger.Warnf("Relayed watch failed, restarting it in %s: %v", delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// relayWatch publishes the changes from fromRevision until the watch fails or ctx is done, and returns the revision
+// of the last event published, 0 if none
+func (i *Ingestor) relayWatch(ctx context.Context, fromRevision int64) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	eventCh, errCh := i.etcdClt.Watch(ctx, fromRevision)
+	var revision int64
+	for {
+		select {
+		case event, ok := <-eventCh:
+			if !ok {
+				return revision, nil
+			}
+			if event.Type == etcd.EventTypeProgress {
+				continue
+			}
+			i.publish(event)
+			revision = event.Revision
+
+		case err, ok := <-errCh:
+			if !ok {
+				return revision, nil
+			}
+			return revision, err
+
+		case <-ctx.Done():
+			return revision, ctx.Err()
+		}
+	}
+}
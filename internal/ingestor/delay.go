@@ -0,0 +1,48 @@
+package ingestor
+
+import (
+	"slices"
+	"time"
+)
+
+// number of recent events the apply latency percentiles are computed over
+const latencyWindowSize = 1024
+
+// Delay describes how far the KV store is behind etcd
+type Delay struct {
+	AppliedRevision int64         // revision of the last event applied to the KV store, 0 if none yet
+	HeadRevision    int64         // current revision of etcd
+	RevisionLag     int64         // revisions between the applied and head revisions, 0 if no event was applied yet
+	LastAppliedAt   time.Time     // time the last event was applied, zero if none yet
+	LastLatency     time.Duration // time between receiving the last event from etcd and the KV store acknowledging it
+	P50Latency      time.Duration
+	P99Latency      time.Duration
+	SearchReady     bool // whether the initial sync is fully indexed
+	Health          Health
+}
+
+// latencyWindow is a ring buffer of the apply latencies of the most recent events
+type latencyWindow struct {
+	latencies []time.Duration
+	next      int
+}
+
+func (w *latencyWindow) add(latency time.Duration) {
+	if len(w.latencies) < latencyWindowSize {
+		w.latencies = append(w.latencies, latency)
+		return
+	}
+	w.latencies[w.next] = latency
+	w.next = (w.next + 1) % latencyWindowSize
+}
+
+// percentile returns the latency below which p percent of the latencies fall, 0 if there is none
+func (w *latencyWindow) percentile(p float64) time.Duration {
+	if len(w.latencies) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(w.latencies)
+	slices.Sort(sorted)
+	i := int(float64(len(sorted)-1) * p / 100)
+	return sorted[i]
+}
@@ -0,0 +1,174 @@
+package ingestor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/metrics"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+var (
+	errWatchClosed          = errors.New("watch closed")
+	errOutageBudgetExceeded = errors.New("outage budget exceeded")
+)
+
+// Health describes whether the KV store is kept up to date with etcd
+type Health struct {
+	Degraded   bool      // whether the watch or the etcd connection checks are failing
+	Since      time.Time // time the ingestion became degraded, zero if it is not
+	Reason     string    // error degrading the ingestion, empty if it is not
+	EtcdError  string    // error of the last etcd connection check, empty if it succeeded
+	WatchError string    // error the watch failed with, empty once it is reconnected
+}
+
+func (i *Ingestor) Health() Health {
+	i.healthMu.Lock()
+	defer i.healthMu.Unlock()
+
+	var health Health
+	switch {
+	case !i.checked:
+		health.EtcdError = "etcd connection not checked yet"
+	case i.checkErr != nil:
+		health.EtcdError = i.checkErr.Error()
+	}
+	if i.watchErr != nil {
+		health.WatchError = i.watchErr.Error()
+	}
+	if !i.degradedSince.IsZero() {
+		health.Degraded = true
+		health.Since = i.degradedSince
+		health.Reason = i.reason().Error()
+	}
+	return health
+}
+
+// ReportHealthCheck records the result of an etcd connection check. A successful check while the watch runs
+// again after failing means it is reconnected.
+func (i *Ingestor) ReportHealthCheck(err error) {
+	i.healthMu.Lock()
+	defer i.healthMu.Unlock()
+
+	i.checkErr = err
+	i.checked = true
+	if err == nil && i.watching {
+		i.watchErr = nil
+	}
+	i.updateHealth()
+}
+
+// superviseWith sets the function stopping ChangeUpdater once the outage budget is spent, nil once it returned
+func (i *Ingestor) superviseWith(giveUp context.CancelCauseFunc) {
+	i.healthMu.Lock()
+	defer i.healthMu.Unlock()
+
+	i.giveUp = giveUp
+	if giveUp == nil {
+		i.stopBudget()
+		return
+	}
+	if !i.degradedSince.IsZero() {
+		i.startBudget()
+	}
+}
+
+// watchStarted records that the watch is (re)started, it is only known to be reconnected once it applies events
+// or an etcd connection check succeeds
+func (i *Ingestor) watchStarted() {
+	i.healthMu.Lock()
+	defer i.healthMu.Unlock()
+	i.watching = true
+}
+
+// watchFailed degrades the ingestion until the watch recovers, and returns whether it was already failing
+func (i *Ingestor) watchFailed(err error) bool {
+	i.healthMu.Lock()
+	defer i.healthMu.Unlock()
+
+	failing := i.watchErr != nil
+	i.watchErr = err
+	i.watching = false
+	i.updateHealth()
+	return failing
+}
+
+// watchRecovered records that the watch applied changes to the KV store
+func (i *Ingestor) watchRecovered() {
+	i.healthMu.Lock()
+	defer i.healthMu.Unlock()
+
+	if i.watchErr != nil {
+		i.watchErr = nil
+		i.updateHealth()
+	}
+}
+
+// reason returns the error degrading the ingestion, nil if it is not degraded
+func (i *Ingestor) reason() error {
+	if i.watchErr != nil {
+		return i.watchErr
+	}
+	return i.checkErr
+}
+
+// updateHealth degrades the ingestion or marks it recovered following the errors, must be called with healthMu held
+func (i *Ingestor) updateHealth() {
+	err := i.reason()
+	switch {
+	case err != nil && i.degradedSince.IsZero():
+		i.degradedSince = time.Now()
+		metrics.IngestionDegraded.Set(1)
+		logger.Warnf("Ingestion degraded: %v", err)
+		i.startBudget()
+	case err == nil && !i.degradedSince.IsZero():
+		logger.Infof("Ingestion recovered after being degraded for %s", time.Since(i.degradedSince))
+		i.degradedSince = time.Time{}
+		metrics.IngestionDegraded.Set(0)
+		i.stopBudget()
+	}
+}
+
+// startBudget stops ChangeUpdater if the ingestion is still degraded when the outage budget is spent,
+// must be called with healthMu held
+func (i *Ingestor) startBudget() {
+	if i.outageBudget <= 0 || i.giveUp == nil || i.budgetTimer != nil {
+		return
+	}
+	since := i.degradedSince
+	giveUp := i.giveUp
+	i.budgetTimer = time.AfterFunc(i.outageBudget-time.Since(since), func() {
+		i.healthMu.Lock()
+		defer i.healthMu.Unlock()
+		// The ingestion recovered meanwhile
+		if !i.degradedSince.Equal(since) {
+			return
+		}
+		giveUp(fmt.Errorf("%w: degraded for %s: %v", errOutageBudgetExceeded, i.outageBudget, i.reason()))
+	})
+}
+
+// stopBudget must be called with healthMu held
+func (i *Ingestor) stopBudget() {
+	if i.budgetTimer != nil {
+		i.budgetTimer.Stop()
+		i.budgetTimer = nil
+	}
+}
+
+// backoff returns the delay before restarting the watch after attempt failed restarts, doubling the retry
+// backoff up to the max retry backoff with half of it randomized so that instances do not retry together
+func (i *Ingestor) backoff(attempt int) time.Duration {
+	delay := min(i.retryBackoff, i.maxRetryBackoff)
+	for range attempt {
+		if delay >= i.maxRetryBackoff/2 {
+			delay = i.maxRetryBackoff
+			break
+		}
+		delay *= 2
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
@@ -2,7 +2,12 @@ package ingestor
 
 import (
 	"context"
+	"errors"
+	"sync"
+	"time"
 
+	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/etcdfinder/etcdfinder/internal/metrics"
 	"github.com/etcdfinder/etcdfinder/pkg/etcd"
 	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
 	"github.com/etcdfinder/etcdfinder/pkg/logger"
@@ -10,113 +15,537 @@ import (
 
 type Base interface {
 	InitKVStore(context.Context) error
+	// resumes from the checkpoint persisted in the KV store instead of InitKVStore, returns false if there is none
+	Resume(context.Context) (bool, error)
 	ChangeUpdater(context.Context) error
-	GetIngestionDelay(context.Context) int
+	GetIngestionDelay(context.Context) (Delay, error)
+	// returns a channel receiving every event applied to the KVStore until ctx is done
+	Subscribe(context.Context) <-chan etcd.WatchEvent
+	// closes the channels of the subscribers, and of those subscribing afterwards
+	CloseSubscribers()
+	// returns a channel closed once the initial sync is fully indexed and searchable
+	SearchReady() <-chan struct{}
+	// periodically repairs the drift between etcd and the KV store until ctx is done
+	Reconciler(context.Context) error
+	GetReconciliation() Reconciliation
+	// periodically persists the last applied revision to the KV store until ctx is done
+	Checkpointer(context.Context) error
+	// persists the last applied revision to the KV store
+	Checkpoint(context.Context) error
+	// tracks the checkpoint persisted by another process ingesting into the KV store, and publishes the changes of
+	// etcd to the subscribers, until ctx is done
+	Follow(context.Context) error
+	// returns whether the watch or the etcd connection checks are failing
+	Health() Health
+	// records the result of an etcd connection check
+	ReportHealthCheck(error)
 }
 
+// size of the channel buffering events for a single subscriber
+const subscriberChannelSize = 100
+
+// defaults of the ingestion settings left unset
+const (
+	defaultBatchSize       = 500 // maximum number of watch events written to the KV store at once
+	defaultSyncPartitions  = 4
+	defaultSyncConcurrency = 4
+	defaultRetryBackoff    = 500 * time.Millisecond
+	defaultMaxRetryBackoff = 30 * time.Second
+
+	defaultCheckpointInterval = 5 * time.Second
+)
+
 type Ingestor struct {
-	kvStore    kvstore.KVStore
-	etcdClt    etcd.BaseClient
-	watchChan  <-chan etcd.WatchEvent
-	initDoneCh chan struct{}
+	kvStore     kvstore.KVStore
+	etcdClt     etcd.BaseClient
+	batchWindow time.Duration
+	batchSize   int
+
+	syncPartitions  int
+	syncConcurrency int
+	syncRevision    int64 // revision the initial sync read etcd at, set before initDoneCh is closed
+
+	reconcileInterval  time.Duration
+	reconcileRateLimit int
+	checkpointInterval time.Duration
+
+	// syncMu serializes the resyncs and reconciliations diffing etcd against the KV store
+	syncMu sync.Mutex
+	// writeMu serializes the writes of watch events and of reconciliation repairs
+	writeMu sync.Mutex
+	// keys written by watch events since the current reconciliation started, nil outside of reconciliations
+	dirty map[string]struct{}
+
+	reconciliationMu sync.Mutex
+	reconciliation   Reconciliation
+
+	retryBackoff    time.Duration
+	maxRetryBackoff time.Duration
+	outageBudget    time.Duration
+
+	healthMu      sync.Mutex
+	watchErr      error // error the watch failed with, until it is known to be reconnected
+	checkErr      error // error of the last etcd connection check, nil if it succeeded
+	checked       bool  // whether an etcd connection check completed
+	watching      bool  // whether the watch is running, as opposed to waiting to be restarted
+	degradedSince time.Time
+	giveUp        context.CancelCauseFunc // stops ChangeUpdater, nil while it is not running
+	budgetTimer   *time.Timer             // fires once the outage budget is spent, nil while not degraded
+
+	initDoneCh  chan struct{}
+	initOnce    sync.Once
+	readyCh     chan struct{}
+	readyOnce   sync.Once
+	subscribers map[chan etcd.WatchEvent]struct{}
+	subsClosed  bool
+	subsMu      sync.Mutex
+
+	delayMu         sync.Mutex
+	appliedRevision int64
+	lastAppliedAt   time.Time
+	lastLatency     time.Duration
+	latencies       latencyWindow
 }
 
-func NewIngestor(kvStore kvstore.KVStore, etcdClt etcd.BaseClient) Base {
-	return &Ingestor{
-		kvStore:    kvStore,
-		etcdClt:    etcdClt,
-		initDoneCh: make(chan struct{}),
+// NewIngestor creates an ingestor writing the watch events received within the batch window to the KV store at once,
+// or as soon as the batch size is reached. Events are written one by one if the batch window is 0.
+func NewIngestor(kvStore kvstore.KVStore, etcdClt etcd.BaseClient, conf config.IngestionConfig) Base {
+	i := &Ingestor{
+		kvStore:         kvStore,
+		etcdClt:         etcdClt,
+		batchWindow:     time.Duration(conf.BatchWindow) * time.Millisecond,
+		batchSize:       conf.BatchSize,
+		syncPartitions:  conf.SyncPartitions,
+		syncConcurrency: conf.SyncConcurrency,
+
+		reconcileInterval:  time.Duration(conf.ReconcileInterval) * time.Second,
+		reconcileRateLimit: conf.ReconcileRateLimit,
+		checkpointInterval: time.Duration(conf.CheckpointInterval) * time.Second,
+
+		retryBackoff:    time.Duration(conf.RetryBackoff) * time.Millisecond,
+		maxRetryBackoff: time.Duration(conf.MaxRetryBackoff) * time.Millisecond,
+		outageBudget:    time.Duration(conf.OutageBudget) * time.Second,
+
+		initDoneCh:  make(chan struct{}),
+		readyCh:     make(chan struct{}),
+		subscribers: make(map[chan etcd.WatchEvent]struct{}),
+	}
+	if i.batchSize <= 0 {
+		i.batchSize = defaultBatchSize
+	}
+	if i.syncPartitions <= 0 {
+		i.syncPartitions = defaultSyncPartitions
+	}
+	if i.syncConcurrency <= 0 {
+		i.syncConcurrency = defaultSyncConcurrency
 	}
+	if i.checkpointInterval <= 0 {
+		i.checkpointInterval = defaultCheckpointInterval
+	}
+	if i.retryBackoff <= 0 {
+		i.retryBackoff = defaultRetryBackoff
+	}
+	if i.maxRetryBackoff <= 0 {
+		i.maxRetryBackoff = defaultMaxRetryBackoff
+	}
+	return i
 }
 
+// InitKVStore empties the KV store and copies the keys of etcd to it, reading the partitions of the keyspace
+// concurrently at a single revision. The watch of ChangeUpdater starts right after that revision.
 func (i *Ingestor) InitKVStore(ctx context.Context) error {
-	defer close(i.initDoneCh)
-	nextKey := ""
+	defer i.initDone()
+	start := time.Now()
 
-	for {
-		keys, returnedNextKey, err := i.etcdClt.GetKeysWithPagination(ctx, nextKey)
-		if err != nil {
-			return err
-		}
-		// If no keys returned, we've reached the end
-		if len(keys) == 0 {
-			break
-		}
-		// Insert all keys from this page into the KVStore
-		if err := i.kvStore.PutBatch(ctx, keys); err != nil {
-			return err
-		}
-		logger.Debugf("Inserting %d keys into KVStore", len(keys))
-		// Update nextKey for the next iteration
-		nextKey = returnedNextKey
-		// If no nextKey is returned, we've reached the end
-		if nextKey == "" {
-			break
-		}
+	if err := i.kvStore.Reset(ctx); err != nil {
+		return err
+	}
+
+	ranges, revision, err := i.etcdClt.PartitionKeys(ctx, i.syncPartitions)
+	if err != nil {
+		return err
 	}
+	logger.Infof("Syncing %d partitions of etcd at revision %d", len(ranges), revision)
 
+	count, err := i.syncRanges(ctx, ranges, revision)
+	if err != nil {
+		return err
+	}
+
+	// Wait for the batches to be indexed, the KV store is not searchable before
+	if err := i.kvStore.Flush(ctx); err != nil {
+		return err
+	}
+
+	metrics.InitialSyncDuration.Set(time.Since(start).Seconds())
+	metrics.InitialSyncKeys.Set(float64(count))
+	i.setApplied(revision)
+	i.syncRevision = revision
+
+	i.markReady()
+	logger.Infof("Initial sync indexed %d keys in %s", count, time.Since(start))
+
+	// Save the checkpoint right away, another process would sync again from scratch otherwise
+	if err := i.kvStore.SaveCheckpoint(ctx, revision); err != nil {
+		logger.Errorf("Failed to save the checkpoint of the initial sync: %v", err)
+	}
 	return nil
 }
 
-func (i *Ingestor) ChangeUpdater(ctx context.Context) error {
-	// Get the watch channel and error channel from etcd
-	eventCh, errCh := i.etcdClt.Watch(ctx)
-	i.watchChan = eventCh
+// Resume continues the ingestion of a KV store kept up to date by this or another process, from the revision persisted.
+// The watch of ChangeUpdater starts right after that revision, which it resyncs from if compacted meanwhile.
+func (i *Ingestor) Resume(ctx context.Context) (bool, error) {
+	revision, err := i.kvStore.LoadCheckpoint(ctx)
+	if err != nil || revision == 0 {
+		return false, err
+	}
+
+	i.setApplied(revision)
+	i.syncRevision = revision
+	i.markReady()
+	i.initDone()
+	logger.Infof("Resuming the ingestion from the checkpoint at revision %d", revision)
+	return true, nil
+}
+
+// initDone releases ChangeUpdater once the KV store was initialized, either by the initial sync or from the checkpoint.
+// Only the first initialization releases it, the ingestion restarted afterwards, e.g. when elected leader again,
+// initializes the KV store before calling ChangeUpdater.
+func (i *Ingestor) initDone() {
+	i.initOnce.Do(func() {
+		close(i.initDoneCh)
+	})
+}
+
+// markReady reports the KV store searchable
+func (i *Ingestor) markReady() {
+	i.readyOnce.Do(func() {
+		metrics.SearchReady.Set(1)
+		close(i.readyCh)
+	})
+}
 
+func (i *Ingestor) SearchReady() <-chan struct{} {
+	return i.readyCh
+}
+
+// ChangeUpdater applies the changes of etcd to the KV store, restarting the watch from the last applied revision
+// with an exponential backoff whenever it fails. It only returns once ctx is done or the ingestion stayed degraded
+// for the whole outage budget.
+func (i *Ingestor) ChangeUpdater(ctx context.Context) error {
 	// Wait for initialization to complete
 	select {
 	case <-i.initDoneCh:
 	case <-ctx.Done():
 		return ctx.Err()
-	case err, ok := <-errCh:
-		if !ok {
-			return nil
+	}
+
+	ctx, giveUp := context.WithCancelCause(ctx)
+	defer giveUp(nil)
+	i.superviseWith(giveUp)
+	defer i.superviseWith(nil)
+
+	// Replay the changes since the initial sync
+	var fromRevision int64
+	if i.syncRevision > 0 {
+		fromRevision = i.syncRevision + 1
+	}
+
+	attempt := 0
+	for {
+		i.watchStarted()
+		err := i.watch(ctx, fromRevision)
+		if errors.Is(err, etcd.ErrCompacted) {
+			// The changes since the last applied event are lost, diff etcd against the KV store instead
+			logger.Warnf("Watch fell behind etcd compaction, resyncing the KV store: %v", err)
+			var revision int64
+			revision, err = i.resync(ctx)
+			if err == nil {
+				i.setApplied(revision)
+				i.watchRecovered()
+				fromRevision = revision + 1
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		if err == nil {
+			err = errWatchClosed
+		}
+
+		// Back off from the first delay again if the watch had recovered since the previous failure
+		if !i.watchFailed(err) {
+			attempt = 0
+		}
+		delay := i.backoff(attempt)
+		attempt++
+		metrics.WatchFailures.Inc()
+
+		// The events of the failed batch were not applied, they are received again
+		if applied := i.lastApplied(); applied > 0 {
+			fromRevision = applied + 1
+		}
+		logger.Warnf("Watch failed, restarting it from revision %d in %s: %v", fromRevision, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return context.Cause(ctx)
 		}
-		return err
 	}
+}
+
+// watch applies the changes from fromRevision until the watch fails or ctx is done. The batch being written
+// when ctx is done, and the events pending, are still written.
+func (i *Ingestor) watch(ctx context.Context, fromRevision int64) error {
+	writeCtx := context.WithoutCancel(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Get the watch channel and error channel from etcd
+	eventCh, errCh := i.etcdClt.Watch(ctx, fromRevision)
+
+	batch := newEventBatch()
+	// fires batchWindow after the first event of the batch, nil while the batch is empty
+	var windowCh <-chan time.Time
+	var window *time.Timer
+	defer func() {
+		if window != nil {
+			window.Stop()
+		}
+	}()
 
 	// Start a goroutine to listen to watch events
 	for {
 		select {
 		case event, ok := <-eventCh:
 			if !ok {
-				// Channel closed, exit
-				return nil
+				// Channel closed, write the pending events and exit
+				return i.write(writeCtx, batch)
 			}
 
-			logger.Debugf("Received event %s for key %s", event.Type, event.Key)
-			// Handle the event based on type
-			switch event.Type {
-			case "PUT":
-				// Update the kvstore with the new/updated key-value
-				if err := i.kvStore.Put(ctx, event.Key, event.Value); err != nil {
-					// return as it will lead to inconsistent state
-					return err
+			if event.Type == etcd.EventTypeProgress {
+				// The events up to the revision were all received, the KV store is up to date with it once they are written
+				if batch.len() == 0 {
+					i.setApplied(event.Revision)
+				} else {
+					batch.progress = event.Revision
 				}
-			case "DELETE":
-				// Remove the key from kvstore
-				if err := i.kvStore.Delete(ctx, event.Key); err != nil {
-					// return as it will lead to inconsistent state
-					return err
+				continue
+			}
+
+			logger.Debugf("Received event %s for key %s", event.Type, event.Key)
+			batch.add(event)
+			if batch.len() < i.batchSize && i.batchWindow > 0 {
+				if windowCh == nil {
+					window = time.NewTimer(i.batchWindow)
+					windowCh = window.C
 				}
+				continue
+			}
+			if window != nil {
+				window.Stop()
+			}
+			windowCh = nil
+			if err := i.write(writeCtx, batch); err != nil {
+				// return as it will lead to inconsistent state
+				return err
+			}
+
+		case <-windowCh:
+			windowCh = nil
+			if err := i.write(writeCtx, batch); err != nil {
+				// return as it will lead to inconsistent state
+				return err
 			}
 
 		case err, ok := <-errCh:
 			if !ok {
 				// Error channel closed, exit
-				return nil
+				return i.write(writeCtx, batch)
+			}
+			// Return watch error, the pending events were received before it and are still valid
+			if writeErr := i.write(writeCtx, batch); writeErr != nil {
+				logger.Errorf("Failed to write pending events: %v", writeErr)
 			}
-			// Return watch error
 			return err
 
 		case <-ctx.Done():
-			// Context cancelled, exit
+			// Context cancelled, write the pending events and exit
+			if err := i.write(writeCtx, batch); err != nil {
+				return err
+			}
 			return ctx.Err()
 		}
 	}
 
 }
 
-func (i *Ingestor) GetIngestionDelay(ctx context.Context) int {
-	return len(i.watchChan)
+// write applies the batch to the KV store, keeping only the latest event of each key, then publishes
+// all of its events in order and empties it
+func (i *Ingestor) write(ctx context.Context, batch *eventBatch) error {
+	if batch.len() == 0 {
+		return nil
+	}
+
+	i.writeMu.Lock()
+	defer i.writeMu.Unlock()
+	if i.dirty != nil {
+		for _, event := range batch.events {
+			i.dirty[event.Key] = struct{}{}
+		}
+	}
+
+	puts, deletes := batch.changes()
+	if len(puts) > 0 {
+		if err := i.kvStore.PutBatch(ctx, puts); err != nil {
+			return err
+		}
+	}
+	if len(deletes) > 0 {
+		if err := i.kvStore.DeleteBatch(ctx, deletes); err != nil {
+			return err
+		}
+	}
+	if err := i.kvStore.Flush(ctx); err != nil {
+		return err
+	}
+	logger.Debugf("Applied %d events as %d puts and %d deletes", batch.len(), len(puts), len(deletes))
+	i.watchRecovered()
+
+	for _, event := range batch.events {
+		i.applied(event)
+		i.publish(event)
+	}
+	// A progress received before other events of the batch is superseded by them
+	if batch.progress > batch.events[len(batch.events)-1].Revision {
+		i.setApplied(batch.progress)
+	}
+	batch.reset()
+	return nil
+}
+
+func (i *Ingestor) Subscribe(ctx context.Context) <-chan etcd.WatchEvent {
+	ch := make(chan etcd.WatchEvent, subscriberChannelSize)
+
+	i.subsMu.Lock()
+	if i.subsClosed {
+		i.subsMu.Unlock()
+		close(ch)
+		return ch
+	}
+	i.subscribers[ch] = struct{}{}
+	i.subsMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		i.unsubscribe(ch)
+	}()
+
+	return ch
+}
+
+// publish fans the event out to all subscribers without blocking the ingestion.
+// Subscribers that cannot keep up are disconnected so that they never silently miss events.
+func (i *Ingestor) publish(event etcd.WatchEvent) {
+	i.subsMu.Lock()
+	defer i.subsMu.Unlock()
+
+	for ch := range i.subscribers {
+		select {
+		case ch <- event:
+		default:
+			logger.Warnf("Watch subscriber is too slow, disconnecting it")
+			delete(i.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (i *Ingestor) CloseSubscribers() {
+	i.subsMu.Lock()
+	defer i.subsMu.Unlock()
+
+	i.subsClosed = true
+	for ch := range i.subscribers {
+		delete(i.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (i *Ingestor) unsubscribe(ch chan etcd.WatchEvent) {
+	i.subsMu.Lock()
+	defer i.subsMu.Unlock()
+
+	if _, ok := i.subscribers[ch]; ok {
+		delete(i.subscribers, ch)
+		close(ch)
+	}
+}
+
+// applied records that the event was acknowledged by the KV store
+func (i *Ingestor) applied(event etcd.WatchEvent) {
+	now := time.Now()
+	latency := now.Sub(event.ObservedAt)
+
+	metrics.WatchEvents.WithLabelValues(event.Type).Inc()
+	metrics.AppliedRevision.Set(float64(event.Revision))
+	metrics.ApplyLatency.Observe(latency.Seconds())
+
+	i.delayMu.Lock()
+	defer i.delayMu.Unlock()
+	i.appliedRevision = event.Revision
+	i.lastAppliedAt = now
+	i.lastLatency = latency
+	i.latencies.add(latency)
+}
+
+// setApplied records that the KV store matches etcd at the revision
+func (i *Ingestor) setApplied(revision int64) {
+	metrics.AppliedRevision.Set(float64(revision))
+
+	i.delayMu.Lock()
+	defer i.delayMu.Unlock()
+	i.appliedRevision = revision
+}
+
+// lastApplied returns the revision the KV store matches etcd at
+func (i *Ingestor) lastApplied() int64 {
+	i.delayMu.Lock()
+	defer i.delayMu.Unlock()
+	return i.appliedRevision
+}
+
+// GetIngestionDelay compares the last applied revision to the current revision of etcd,
+// along with the apply latencies of the recent events
+func (i *Ingestor) GetIngestionDelay(ctx context.Context) (Delay, error) {
+	head, err := i.etcdClt.CurrentRevision(ctx)
+	if err != nil {
+		return Delay{}, err
+	}
+
+	i.delayMu.Lock()
+	defer i.delayMu.Unlock()
+
+	delay := Delay{
+		AppliedRevision: i.appliedRevision,
+		HeadRevision:    head,
+		LastAppliedAt:   i.lastAppliedAt,
+		LastLatency:     i.lastLatency,
+		P50Latency:      i.latencies.percentile(50),
+		P99Latency:      i.latencies.percentile(99),
+		Health:          i.Health(),
+	}
+	select {
+	case <-i.readyCh:
+		delay.SearchReady = true
+	default:
+	}
+	if i.appliedRevision > 0 {
+		delay.RevisionLag = max(head-i.appliedRevision, 0)
+	}
+	return delay, nil
 }
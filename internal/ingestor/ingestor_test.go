@@ -0,0 +1,121 @@
+package ingestor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// waitApplied fails the test unless the applied revision reaches revision within a second
+func waitApplied(t *testing.T, i *Ingestor, revision int64) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for i.lastApplied() != revision {
+		if time.Now().After(deadline) {
+			t.Fatalf("applied revision = %d, want %d", i.lastApplied(), revision)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWatchAppliesProgress(t *testing.T) {
+	logger.L = &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}
+
+	store := &fakeStore{docs: map[string]common.KV{}}
+	etcdClt := &fakeEtcd{watchCh: make(chan etcd.WatchEvent)}
+	i := NewIngestor(store, etcdClt, config.IngestionConfig{BatchWindow: 50}).(*Ingestor)
+	events := i.Subscribe(t.Context())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- i.watch(ctx, 0) }()
+
+	// The progress is applied along with the batch it was received with
+	etcdClt.watchCh <- etcd.WatchEvent{Type: "PUT", Key: "/a", Value: "1", Revision: 5}
+	etcdClt.watchCh <- etcd.WatchEvent{Type: etcd.EventTypeProgress, Revision: 9}
+	waitApplied(t, i, 9)
+	if _, ok := store.docs["/a"]; !ok {
+		t.Errorf("/a was not written before the progress was applied")
+	}
+
+	// Without pending events, the progress is applied right away
+	etcdClt.watchCh <- etcd.WatchEvent{Type: etcd.EventTypeProgress, Revision: 12}
+	waitApplied(t, i, 12)
+
+	// A progress received before later events of the batch does not move the applied revision back
+	etcdClt.watchCh <- etcd.WatchEvent{Type: "PUT", Key: "/b", Value: "2", Revision: 14}
+	etcdClt.watchCh <- etcd.WatchEvent{Type: etcd.EventTypeProgress, Revision: 14}
+	etcdClt.watchCh <- etcd.WatchEvent{Type: "DELETE", Key: "/a", Revision: 16}
+	waitApplied(t, i, 16)
+
+	cancel()
+	<-done
+
+	// Progress events are not published to the subscribers
+	for _, want := range []string{"PUT", "PUT", "DELETE"} {
+		if event := <-events; event.Type != want {
+			t.Errorf("published %s event, want %s", event.Type, want)
+		}
+	}
+	select {
+	case event := <-events:
+		t.Errorf("published unexpected %s event", event.Type)
+	default:
+	}
+}
+
+func TestFollowPublishesChanges(t *testing.T) {
+	logger.L = &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}
+
+	store := &fakeStore{docs: map[string]common.KV{}, checkpoint: 7}
+	etcdClt := &fakeEtcd{watchCh: make(chan etcd.WatchEvent)}
+	i := NewIngestor(store, etcdClt, config.IngestionConfig{CheckpointInterval: 60}).(*Ingestor)
+	// The process ingested up to the revision before losing the leadership
+	i.setApplied(10)
+	events := i.Subscribe(t.Context())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- i.Follow(ctx) }()
+
+	// The changes are published without being written, the leader writes them
+	etcdClt.watchCh <- etcd.WatchEvent{Type: "PUT", Key: "/a", Value: "1", Revision: 11}
+	etcdClt.watchCh <- etcd.WatchEvent{Type: etcd.EventTypeProgress, Revision: 12}
+	etcdClt.watchCh <- etcd.WatchEvent{Type: "DELETE", Key: "/b", Revision: 13}
+	for _, want := range []string{"PUT", "DELETE"} {
+		select {
+		case event := <-events:
+			if event.Type != want {
+				t.Errorf("published %s event, want %s", event.Type, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s event was not published", want)
+		}
+	}
+	if from := etcdClt.watchedFrom.Load(); from != 11 {
+		t.Errorf("watched from revision %d, want 11", from)
+	}
+	if len(store.docs) != 0 {
+		t.Errorf("follower wrote %d keys", len(store.docs))
+	}
+
+	select {
+	case <-i.SearchReady():
+	case <-time.After(time.Second):
+		t.Fatalf("follower is not ready after the checkpoint was loaded")
+	}
+	waitApplied(t, i, 7)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Follow did not return once ctx was done")
+	}
+}
@@ -0,0 +1,261 @@
+package ingestor
+
+import (
+	"context"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/metrics"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+// Reconciliation reports the last run of the reconciler
+type Reconciliation struct {
+	Enabled      bool
+	Interval     time.Duration
+	Runs         int64
+	LastRunAt    time.Time // zero if the reconciler did not run yet
+	LastDuration time.Duration
+	Revision     int64  // revision etcd was read at by the last run
+	Drift        Drift  // repairs applied by the last run
+	Skipped      int    // keys the watch changed during the last run, left to it
+	Error        string // error of the last run, empty if it succeeded
+}
+
+// storedKey is the state of a key in the KV store
+type storedKey struct {
+	modRevision int64
+	valueHash   string
+}
+
+// Reconciler compares etcd to the KV store every reconcile interval once the initial sync is searchable, and
+// repairs the keys missing, stale or lingering in the KV store. Failed runs are logged and reported, they do not
+// stop the reconciler.
+func (i *Ingestor) Reconciler(ctx context.Context) error {
+	select {
+	case <-i.readyCh:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	ticker := time.NewTicker(i.reconcileInterval)
+	defer ticker.Stop()
+
+	logger.Infof("Starting reconciler (running every %s)", i.reconcileInterval)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			i.reconcile(ctx)
+		}
+	}
+}
+
+func (i *Ingestor) GetReconciliation() Reconciliation {
+	i.reconciliationMu.Lock()
+	defer i.reconciliationMu.Unlock()
+
+	reconciliation := i.reconciliation
+	reconciliation.Enabled = i.reconcileInterval > 0
+	reconciliation.Interval = i.reconcileInterval
+	return reconciliation
+}
+
+// reconcile runs a reconciliation and records its report
+func (i *Ingestor) reconcile(ctx context.Context) {
+	i.syncMu.Lock()
+	defer i.syncMu.Unlock()
+
+	start := time.Now()
+	revision, drift, skipped, err := i.repairDrift(ctx)
+	duration := time.Since(start)
+
+	i.reconciliationMu.Lock()
+	i.reconciliation.Runs++
+	i.reconciliation.LastRunAt = start
+	i.reconciliation.LastDuration = duration
+	i.reconciliation.Revision = revision
+	i.reconciliation.Drift = drift
+	i.reconciliation.Skipped = skipped
+	i.reconciliation.Error = ""
+	if err != nil {
+		i.reconciliation.Error = err.Error()
+	}
+	i.reconciliationMu.Unlock()
+
+	metrics.ObserveReconciliation(drift.Added, drift.Updated, drift.Deleted, err)
+	switch {
+	case err != nil:
+		logger.Errorf("Reconciliation failed after %s: %v", duration, err)
+	case drift.Added+drift.Updated+drift.Deleted > 0:
+		logger.Warnf("Reconciliation at revision %d repaired drift in %s: %d added, %d updated, %d deleted, %d left to the watch",
+			revision, duration, drift.Added, drift.Updated, drift.Deleted, skipped)
+	default:
+		logger.Infof("Reconciliation at revision %d found no drift in %s", revision, duration)
+	}
+}
+
+// repairDrift diffs etcd against the KV store by mod revision and value hash, and applies the repairs. Keys changed
+// after the last applied revision, or written by the watch during the reconciliation, are skipped as the watch
+// takes care of them.
+func (i *Ingestor) repairDrift(ctx context.Context) (int64, Drift, int, error) {
+	var drift Drift
+	skipped := 0
+
+	i.writeMu.Lock()
+	i.dirty = map[string]struct{}{}
+	i.writeMu.Unlock()
+	defer func() {
+		i.writeMu.Lock()
+		i.dirty = nil
+		i.writeMu.Unlock()
+	}()
+
+	i.delayMu.Lock()
+	applied := i.appliedRevision
+	i.delayMu.Unlock()
+
+	limiter := &pacer{rate: i.reconcileRateLimit, start: time.Now()}
+
+	stored := map[string]storedKey{}
+	for after := kvstore.ScanStart; ; {
+		kvs, err := i.kvStore.Scan(ctx, after, scanPageSize)
+		if err != nil {
+			return 0, drift, skipped, err
+		}
+		if len(kvs) == 0 {
+			break
+		}
+		// A key the watch rewrote during the scan is seen again at its new revision
+		for _, kv := range kvs {
+			stored[kv.Key] = storedKey{modRevision: kv.ModRevision, valueHash: kv.ValueHash}
+		}
+		if err := limiter.wait(ctx, len(kvs)); err != nil {
+			return 0, drift, skipped, err
+		}
+		after = kvs[len(kvs)-1].ModRevision
+	}
+
+	var adds, updates []common.KV
+	fromKey := ""
+	var revision int64
+	for {
+		kvs, nextKey, readRevision, err := i.etcdClt.GetKeysWithPagination(ctx, fromKey, revision)
+		if err != nil {
+			return 0, drift, skipped, err
+		}
+		revision = readRevision
+
+		for _, kv := range kvs {
+			current, ok := stored[kv.Key]
+			delete(stored, kv.Key)
+			if kv.ModRevision > applied {
+				skipped++
+				continue
+			}
+
+			kv.ValueHash = kvstore.HashValue(kv.Value)
+			switch {
+			case !ok:
+				adds = append(adds, kv)
+			case current.modRevision != kv.ModRevision || current.valueHash != kv.ValueHash:
+				updates = append(updates, kv)
+			}
+		}
+		if err := limiter.wait(ctx, len(kvs)); err != nil {
+			return 0, drift, skipped, err
+		}
+
+		if nextKey == "" {
+			break
+		}
+		fromKey = nextKey
+	}
+
+	// The keys left are not in etcd anymore, unless created after the last applied revision
+	var deletes []string
+	for key, current := range stored {
+		if current.modRevision > applied {
+			skipped++
+			continue
+		}
+		deletes = append(deletes, key)
+	}
+
+	// Keep the watch from writing while the repairs are applied, and leave it the keys it wrote meanwhile
+	i.writeMu.Lock()
+	defer i.writeMu.Unlock()
+
+	clean := func(key string) bool {
+		if _, ok := i.dirty[key]; ok {
+			skipped++
+			return false
+		}
+		return true
+	}
+	var puts []common.KV
+	for _, kv := range adds {
+		if clean(kv.Key) {
+			puts = append(puts, kv)
+			drift.Added++
+		}
+	}
+	for _, kv := range updates {
+		if clean(kv.Key) {
+			puts = append(puts, kv)
+			drift.Updated++
+		}
+	}
+	var removals []string
+	for _, key := range deletes {
+		if clean(key) {
+			removals = append(removals, key)
+		}
+	}
+	drift.Deleted = len(removals)
+
+	for start := 0; start < len(puts); start += i.batchSize {
+		if err := i.kvStore.PutBatch(ctx, puts[start:min(start+i.batchSize, len(puts))]); err != nil {
+			return revision, drift, skipped, err
+		}
+	}
+	if len(removals) > 0 {
+		if err := i.kvStore.DeleteBatch(ctx, removals); err != nil {
+			return revision, drift, skipped, err
+		}
+	}
+	if err := i.kvStore.Flush(ctx); err != nil {
+		return revision, drift, skipped, err
+	}
+	return revision, drift, skipped, nil
+}
+
+// pacer limits the rate keys are read at, a rate of 0 meaning no limit
+type pacer struct {
+	rate  int // keys per second
+	start time.Time
+	count int
+}
+
+// wait blocks until n more keys may be read
+func (p *pacer) wait(ctx context.Context, n int) error {
+	if p.rate <= 0 {
+		return nil
+	}
+	p.count += n
+
+	delay := time.Until(p.start.Add(time.Duration(p.count) * time.Second / time.Duration(p.rate)))
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,201 @@
+package ingestor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// fakeStore is an in-memory KV store, the methods the tests do not use panic
+type fakeStore struct {
+	kvstore.KVStore
+
+	mu         sync.Mutex
+	docs       map[string]common.KV
+	checkpoint int64
+}
+
+func (s *fakeStore) LoadCheckpoint(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkpoint, nil
+}
+
+func (s *fakeStore) Scan(ctx context.Context, afterRevision, limit int64) ([]common.KV, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var kvs []common.KV
+	for _, kv := range s.docs {
+		if kv.ModRevision > afterRevision {
+			kvs = append(kvs, kv)
+		}
+	}
+	sort.Slice(kvs, func(a, b int) bool { return kvs[a].ModRevision < kvs[b].ModRevision })
+	if int64(len(kvs)) <= limit {
+		return kvs, nil
+	}
+	// Complete the page with the keys of its last revision
+	end := limit
+	for end < int64(len(kvs)) && kvs[end].ModRevision == kvs[limit-1].ModRevision {
+		end++
+	}
+	return kvs[:end], nil
+}
+
+func (s *fakeStore) PutBatch(ctx context.Context, kvs []common.KV) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, kv := range kvs {
+		s.docs[kv.Key] = kv
+	}
+	return nil
+}
+
+func (s *fakeStore) DeleteBatch(ctx context.Context, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		delete(s.docs, key)
+	}
+	return nil
+}
+
+func (s *fakeStore) Flush(ctx context.Context) error {
+	return nil
+}
+
+// fakeEtcd serves the keys in a single page, calling onRead before, and the events of watchCh to watches,
+// recording the revision they start from. The methods the tests do not use panic.
+type fakeEtcd struct {
+	etcd.BaseClient
+
+	revision    int64
+	keys        []common.KV
+	onRead      func()
+	watchCh     chan etcd.WatchEvent
+	watchedFrom atomic.Int64
+}
+
+func (c *fakeEtcd) Watch(ctx context.Context, fromRevision int64) (<-chan etcd.WatchEvent, <-chan error) {
+	c.watchedFrom.Store(fromRevision)
+	return c.watchCh, make(chan error)
+}
+
+func (c *fakeEtcd) GetKeysWithPagination(ctx context.Context, fromKey string, revision int64) ([]common.KV, string, int64, error) {
+	if c.onRead != nil {
+		c.onRead()
+	}
+	return slices.Clone(c.keys), "", c.revision, nil
+}
+
+func stored(key, value string, modRevision int64) common.KV {
+	return common.KV{Key: key, Value: value, ModRevision: modRevision, ValueHash: kvstore.HashValue(value)}
+}
+
+func TestRepairDrift(t *testing.T) {
+	logger.L = &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}
+
+	store := &fakeStore{docs: map[string]common.KV{
+		"/updated":       stored("/updated", "old", 4),
+		"/changed-value": stored("/changed-value", "stale", 5),
+		"/deleted":       stored("/deleted", "gone", 3),
+		"/unchanged":     stored("/unchanged", "same", 7),
+		"/watch-deleted": stored("/watch-deleted", "gone", 2),
+		"/recent":        stored("/recent", "new", 12),
+	}}
+	etcdClt := &fakeEtcd{
+		revision: 20,
+		keys: []common.KV{
+			{Key: "/added", Value: "a", ModRevision: 5},
+			{Key: "/updated", Value: "new", ModRevision: 6},
+			{Key: "/changed-value", Value: "fresh", ModRevision: 5},
+			{Key: "/unchanged", Value: "same", ModRevision: 7},
+			{Key: "/watch-put", Value: "v1", ModRevision: 8},
+			{Key: "/after-applied", Value: "x", ModRevision: 15},
+		},
+	}
+	i := NewIngestor(store, etcdClt, config.IngestionConfig{}).(*Ingestor)
+	i.setApplied(10)
+
+	// The watch applies events between the scan of the KV store and the repairs, the reconciliation leaves them
+	// to it even though they are older than what etcd was read at
+	etcdClt.onRead = func() {
+		batch := newEventBatch()
+		batch.add(etcd.WatchEvent{Type: "PUT", Key: "/watch-put", Value: "v2", Revision: 9})
+		batch.add(etcd.WatchEvent{Type: "DELETE", Key: "/watch-deleted", Revision: 10})
+		if err := i.write(context.Background(), batch); err != nil {
+			t.Errorf("write: %v", err)
+		}
+	}
+
+	revision, drift, skipped, err := i.repairDrift(context.Background())
+	if err != nil {
+		t.Fatalf("repairDrift: %v", err)
+	}
+	if revision != 20 {
+		t.Errorf("revision = %d, want 20", revision)
+	}
+	if want := (Drift{Added: 1, Updated: 2, Deleted: 1}); drift != want {
+		t.Errorf("drift = %+v, want %+v", drift, want)
+	}
+	// /after-applied and /recent changed after the applied revision, /watch-put and /watch-deleted were written
+	// by the watch during the reconciliation
+	if skipped != 4 {
+		t.Errorf("skipped = %d, want 4", skipped)
+	}
+
+	want := map[string]common.KV{
+		"/added":         stored("/added", "a", 5),
+		"/updated":       stored("/updated", "new", 6),
+		"/changed-value": stored("/changed-value", "fresh", 5),
+		"/unchanged":     stored("/unchanged", "same", 7),
+		"/watch-put":     stored("/watch-put", "v2", 9),
+		"/recent":        stored("/recent", "new", 12),
+	}
+	for key, kv := range want {
+		if got, ok := store.docs[key]; !ok || got != kv {
+			t.Errorf("stored %s = %+v, want %+v", key, got, kv)
+		}
+	}
+	for key := range store.docs {
+		if _, ok := want[key]; !ok {
+			t.Errorf("%s is still stored", key)
+		}
+	}
+	if i.dirty != nil {
+		t.Errorf("dirty keys are still tracked after the reconciliation")
+	}
+}
+
+func TestRepairDriftScansEveryPage(t *testing.T) {
+	logger.L = &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}
+
+	// More keys than a page, many of them at the same revision as written by a transaction
+	store := &fakeStore{docs: map[string]common.KV{}}
+	for n := range 2*scanPageSize + 10 {
+		key := fmt.Sprintf("/deleted/%05d", n)
+		store.docs[key] = stored(key, "v", int64(1+n/300))
+	}
+	i := NewIngestor(store, &fakeEtcd{revision: 20}, config.IngestionConfig{}).(*Ingestor)
+	i.setApplied(20)
+
+	_, drift, _, err := i.repairDrift(context.Background())
+	if err != nil {
+		t.Fatalf("repairDrift: %v", err)
+	}
+	if drift.Deleted != 2*scanPageSize+10 || len(store.docs) != 0 {
+		t.Errorf("deleted %d keys, %d left", drift.Deleted, len(store.docs))
+	}
+}
@@ -0,0 +1,134 @@
+package ingestor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/metrics"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+// number of documents read from the KV store at once when diffing it against etcd
+const scanPageSize = 1000
+
+// maximum number of consecutive resyncs failing because the revision they read got compacted meanwhile
+const maxResyncAttempts = 3
+
+// Drift counts the changes applied to the KV store to match etcd
+type Drift struct {
+	Added   int // keys missing from the KV store
+	Updated int // keys stored with another mod revision or value than in etcd
+	Deleted int // keys stored but no longer in etcd
+}
+
+// resync brings the KV store up to date with etcd without recreating it, by diffing the mod revisions of the
+// stored keys against etcd, and returns the revision etcd was read at
+func (i *Ingestor) resync(ctx context.Context) (int64, error) {
+	i.syncMu.Lock()
+	defer i.syncMu.Unlock()
+
+	var err error
+	for attempt := 1; attempt <= maxResyncAttempts; attempt++ {
+		var revision int64
+		var drift Drift
+		start := time.Now()
+		revision, drift, err = i.diff(ctx)
+		if err == nil {
+			logger.Infof("Resynced the KV store at revision %d in %s: %d added, %d updated, %d deleted",
+				revision, time.Since(start), drift.Added, drift.Updated, drift.Deleted)
+			metrics.Resyncs.Inc()
+			return revision, nil
+		}
+		if !errors.Is(err, etcd.ErrCompacted) {
+			return 0, err
+		}
+		logger.Warnf("Resync attempt %d read a compacted revision: %v", attempt, err)
+	}
+	return 0, err
+}
+
+// diff reads etcd at a single revision and applies to the KV store the keys missing or stored at another
+// mod revision, then deletes the stored keys not in etcd
+func (i *Ingestor) diff(ctx context.Context) (int64, Drift, error) {
+	var drift Drift
+
+	stored, err := i.storedRevisions(ctx)
+	if err != nil {
+		return 0, drift, err
+	}
+
+	fromKey := ""
+	var revision int64
+	for {
+		kvs, nextKey, readRevision, err := i.etcdClt.GetKeysWithPagination(ctx, fromKey, revision)
+		if err != nil {
+			return 0, drift, err
+		}
+		revision = readRevision
+
+		var puts []common.KV
+		for _, kv := range kvs {
+			storedRevision, ok := stored[kv.Key]
+			delete(stored, kv.Key)
+			switch {
+			case !ok:
+				drift.Added++
+			case storedRevision != kv.ModRevision:
+				drift.Updated++
+			default:
+				continue
+			}
+			puts = append(puts, kv)
+		}
+		if len(puts) > 0 {
+			hashValues(puts)
+			if err := i.kvStore.PutBatch(ctx, puts); err != nil {
+				return 0, drift, err
+			}
+		}
+
+		if nextKey == "" {
+			break
+		}
+		fromKey = nextKey
+	}
+
+	// The keys left are not in etcd anymore
+	deletes := make([]string, 0, len(stored))
+	for key := range stored {
+		deletes = append(deletes, key)
+	}
+	drift.Deleted = len(deletes)
+	if len(deletes) > 0 {
+		if err := i.kvStore.DeleteBatch(ctx, deletes); err != nil {
+			return 0, drift, err
+		}
+	}
+
+	if err := i.kvStore.Flush(ctx); err != nil {
+		return 0, drift, err
+	}
+	return revision, drift, nil
+}
+
+// storedRevisions returns the mod revision of every key of the KV store
+func (i *Ingestor) storedRevisions(ctx context.Context) (map[string]int64, error) {
+	stored := map[string]int64{}
+	for after := kvstore.ScanStart; ; {
+		kvs, err := i.kvStore.Scan(ctx, after, scanPageSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(kvs) == 0 {
+			return stored, nil
+		}
+		for _, kv := range kvs {
+			stored[kv.Key] = kv.ModRevision
+		}
+		after = kvs[len(kvs)-1].ModRevision
+	}
+}
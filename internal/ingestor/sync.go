@@ -0,0 +1,87 @@
+package ingestor
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+// syncRanges reads the ranges concurrently at the revision while their pages are written to the KV store
+// by syncConcurrency writers, and returns the number of keys written
+func (i *Ingestor) syncRanges(ctx context.Context, ranges []etcd.KeyRange, revision int64) (int64, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	pages := make(chan []common.KV, i.syncConcurrency)
+
+	var readers sync.WaitGroup
+	for _, keyRange := range ranges {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			if err := i.readRange(ctx, keyRange, revision, pages); err != nil {
+				cancel(err)
+			}
+		}()
+	}
+	go func() {
+		readers.Wait()
+		close(pages)
+	}()
+
+	var count atomic.Int64
+	var writers sync.WaitGroup
+	for range i.syncConcurrency {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for page := range pages {
+				if err := i.kvStore.PutBatch(ctx, page); err != nil {
+					cancel(err)
+					continue // drain the pages so that the readers stop
+				}
+				count.Add(int64(len(page)))
+			}
+		}()
+	}
+	writers.Wait()
+
+	return count.Load(), context.Cause(ctx)
+}
+
+// readRange sends the pages of the range to pages until the range is read or ctx is done
+func (i *Ingestor) readRange(ctx context.Context, keyRange etcd.KeyRange, revision int64, pages chan<- []common.KV) error {
+	fromKey := ""
+	for {
+		keys, nextKey, err := i.etcdClt.GetRangeWithPagination(ctx, keyRange, fromKey, revision)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			hashValues(keys)
+			logger.Debugf("Inserting %d keys into KVStore", len(keys))
+			select {
+			case pages <- keys:
+			case <-ctx.Done():
+				return context.Cause(ctx)
+			}
+		}
+		// If no nextKey is returned, we've reached the end of the range
+		if nextKey == "" {
+			return nil
+		}
+		fromKey = nextKey
+	}
+}
+
+// hashValues sets the hash of the etcd values, to be stored along with them
+func hashValues(kvs []common.KV) {
+	for idx := range kvs {
+		kvs[idx].ValueHash = kvstore.HashValue(kvs[idx].Value)
+	}
+}
@@ -13,3 +13,19 @@ const (
 	ETCD_V2 EtcdVersion = "v2"
 	ETCD_V3 EtcdVersion = "v3"
 )
+
+type AuditSink string
+
+const (
+	AUDIT_SINK_FILE    AuditSink = "file"
+	AUDIT_SINK_STDOUT  AuditSink = "stdout"
+	AUDIT_SINK_WEBHOOK AuditSink = "webhook"
+)
+
+type RedactionDetector string
+
+const (
+	REDACTION_DETECTOR_PEM     RedactionDetector = "pem"
+	REDACTION_DETECTOR_JWT     RedactionDetector = "jwt"
+	REDACTION_DETECTOR_ENTROPY RedactionDetector = "entropy"
+)
@@ -5,4 +5,11 @@ const (
 	KEY_CONSTANT             = "key"
 	VALUE_CONSTANT           = "value"
 	ID_CONSTANT              = "id"
+	MOD_REVISION_CONSTANT    = "mod_revision"
+	VALUE_HASH_CONSTANT      = "value_hash"
+	REVISION_CONSTANT        = "revision"
+	CHECKPOINT_ID            = "checkpoint"
+	DEFAULT_SEARCH_LIMIT     = 100
+	MAX_SEARCH_LIMIT         = 1000
+	REDACTED_VALUE           = "[REDACTED]"
 )
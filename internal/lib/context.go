@@ -9,11 +9,35 @@ type ContextKey string
 
 const (
 	CtxRequestID ContextKey = "request_id"
+	CtxIdentity  ContextKey = "identity"
+	CtxApproval  ContextKey = "approval"
 )
 
+// Approval is the approved change a request applies on behalf of its requester
+type Approval struct {
+	ChangeID    string
+	RequestedBy string // subject of the requester
+}
+
 func GetRequestID(ctx context.Context) string {
 	if requestID, ok := ctx.Value(CtxRequestID).(string); ok {
 		return requestID
 	}
 	return ""
 }
+
+// GetApproval returns the approved change the request applies, nil if the request is not applying one
+func GetApproval(ctx context.Context) *Approval {
+	if approval, ok := ctx.Value(CtxApproval).(*Approval); ok {
+		return approval
+	}
+	return nil
+}
+
+// GetIdentity returns the authenticated caller of the request, nil if authentication is disabled
+func GetIdentity(ctx context.Context) *Identity {
+	if identity, ok := ctx.Value(CtxIdentity).(*Identity); ok {
+		return identity
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package lib
+
+import "testing"
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{pattern: "/app/*", key: "/app/config", want: true},
+		{pattern: "/app/*", key: "/app/", want: true},
+		{pattern: "/app/*", key: "/app/db/password", want: false},
+		{pattern: "/app/*", key: "/apps/config", want: false},
+		{pattern: "/app/**", key: "/app/db/password", want: true},
+		{pattern: "/app/**", key: "/app", want: false},
+		{pattern: "**", key: "/any/key", want: true},
+		{pattern: "**", key: "", want: true},
+		{pattern: "*", key: "/app", want: false},
+		{pattern: "/shared/*/config", key: "/shared/team/config", want: true},
+		{pattern: "/shared/*/config", key: "/shared/a/b/config", want: false},
+		{pattern: "/shared/**/config", key: "/shared/a/b/config", want: true},
+		{pattern: "/shared/**/config", key: "/shared/a/b/config/extra", want: false},
+		{pattern: "/db?", key: "/db1", want: true},
+		{pattern: "/db?", key: "/db", want: false},
+		{pattern: "/db?", key: "/db/", want: false},
+		// Patterns are anchored and their other characters are literal
+		{pattern: "/app", key: "/app/config", want: false},
+		{pattern: "/app", key: "/prefix/app", want: false},
+		{pattern: "/a.b", key: "/axb", want: false},
+		{pattern: "/a+(b)", key: "/a+(b)", want: true},
+	}
+	for _, tt := range tests {
+		re, err := CompileGlob(tt.pattern)
+		if err != nil {
+			t.Fatalf("CompileGlob(%q): %v", tt.pattern, err)
+		}
+		if got := re.MatchString(tt.key); got != tt.want {
+			t.Errorf("%q matches %q: %t, want %t", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
@@ -2,4 +2,5 @@ package lib
 
 const (
 	HeaderRequestID = "X-Request-ID"
+	HeaderAPIKey    = "X-API-Key"
 )
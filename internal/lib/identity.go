@@ -0,0 +1,28 @@
+package lib
+
+type AuthMethod string
+
+const (
+	AUTH_METHOD_API_KEY AuthMethod = "api_key"
+	AUTH_METHOD_JWT     AuthMethod = "jwt"
+	AUTH_METHOD_SESSION AuthMethod = "session"
+)
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	Subject string     // name of the API key or subject of the token
+	Email   string     // empty for API keys
+	Groups  []string   // groups the caller belongs to, used for authorization
+	Method  AuthMethod // how the caller authenticated
+	Issuer  string     // issuer of the token, empty for API keys
+}
+
+// Principal returns the subject qualified with the issuer of its token, or marked as the name of an API key,
+// so that callers authenticated differently never compare equal
+func (i *Identity) Principal() string {
+	if i.Method == AUTH_METHOD_API_KEY {
+		return string(AUTH_METHOD_API_KEY) + ":" + i.Subject
+	}
+	// Sessions are created from the ID tokens of the issuer, they are the same callers as its tokens
+	return "token:" + i.Issuer + " " + i.Subject
+}
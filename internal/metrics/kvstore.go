@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+)
+
+// kvStore measures the latency and errors of the operations of the wrapped store
+type kvStore struct {
+	next kvstore.KVStore
+}
+
+// NewKVStore wraps the store to measure its operations
+func NewKVStore(next kvstore.KVStore) kvstore.KVStore {
+	return &kvStore{next: next}
+}
+
+func (s *kvStore) Get(ctx context.Context, key string) (string, error) {
+	start := time.Now()
+	value, err := s.next.Get(ctx, key)
+	observe("get", start, err)
+	return value, err
+}
+
+func (s *kvStore) Put(ctx context.Context, key, value string) error {
+	start := time.Now()
+	err := s.next.Put(ctx, key, value)
+	observe("put", start, err)
+	return err
+}
+
+func (s *kvStore) PutBatch(ctx context.Context, kvs []common.KV) error {
+	start := time.Now()
+	err := s.next.PutBatch(ctx, kvs)
+	observe("put_batch", start, err)
+	return err
+}
+
+func (s *kvStore) Flush(ctx context.Context) error {
+	start := time.Now()
+	err := s.next.Flush(ctx)
+	observe("flush", start, err)
+	return err
+}
+
+func (s *kvStore) Search(ctx context.Context, searchStr string, offset, limit int64) ([]common.KV, error) {
+	start := time.Now()
+	kvs, err := s.next.Search(ctx, searchStr, offset, limit)
+	observe("search", start, err)
+	return kvs, err
+}
+
+func (s *kvStore) Scan(ctx context.Context, afterRevision, limit int64) ([]common.KV, error) {
+	start := time.Now()
+	kvs, err := s.next.Scan(ctx, afterRevision, limit)
+	observe("scan", start, err)
+	return kvs, err
+}
+
+func (s *kvStore) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := s.next.Delete(ctx, key)
+	observe("delete", start, err)
+	return err
+}
+
+func (s *kvStore) DeleteBatch(ctx context.Context, keys []string) error {
+	start := time.Now()
+	err := s.next.DeleteBatch(ctx, keys)
+	observe("delete_batch", start, err)
+	return err
+}
+
+func (s *kvStore) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := s.next.Ping(ctx)
+	observe("ping", start, err)
+	return err
+}
+
+func (s *kvStore) Reset(ctx context.Context) error {
+	start := time.Now()
+	err := s.next.Reset(ctx)
+	observe("reset", start, err)
+	return err
+}
+
+func (s *kvStore) SaveCheckpoint(ctx context.Context, revision int64) error {
+	start := time.Now()
+	err := s.next.SaveCheckpoint(ctx, revision)
+	observe("save_checkpoint", start, err)
+	return err
+}
+
+func (s *kvStore) LoadCheckpoint(ctx context.Context) (int64, error) {
+	start := time.Now()
+	revision, err := s.next.LoadCheckpoint(ctx)
+	observe("load_checkpoint", start, err)
+	return revision, err
+}
+
+func (s *kvStore) Close(ctx context.Context) error {
+	return s.next.Close(ctx)
+}
+
+func observe(operation string, start time.Time, err error) {
+	KVStoreOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		KVStoreOperationErrors.WithLabelValues(operation).Inc()
+	}
+}
@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "etcdfinder"
+
+// timeout of the etcd requests made when the metrics are scraped
+const scrapeTimeout = 2 * time.Second
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	KVStoreOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "kvstore_operation_duration_seconds",
+		Help:      "Latency of the KV store operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	KVStoreOperationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kvstore_operation_errors_total",
+		Help:      "Failed KV store operations.",
+	}, []string{"operation"})
+
+	WatchEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "watch_events_total",
+		Help:      "etcd watch events applied to the KV store by type.",
+	}, []string{"type"})
+
+	WatchRevisionGapRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "watch_revision_gap_retries_total",
+		Help:      "Watches restarted because an event was missed.",
+	})
+
+	WatchFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "watch_failures_total",
+		Help:      "Watches that failed and were restarted after a backoff.",
+	})
+
+	IngestionDegraded = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ingestion_degraded",
+		Help:      "1 while the watch is failing or etcd connection checks fail, 0 otherwise.",
+	})
+
+	EtcdHealthChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "etcd_health_checks_total",
+		Help:      "etcd connection checks of the auditor by result.",
+	}, []string{"result"})
+
+	InitialSyncDuration = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "initial_sync_duration_seconds",
+		Help:      "Duration of the initial sync of the KV store with etcd.",
+	})
+
+	InitialSyncKeys = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "initial_sync_keys",
+		Help:      "Keys inserted into the KV store by the initial sync.",
+	})
+
+	ApplyLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "apply_latency_seconds",
+		Help:      "Time between receiving a watch event from etcd and the KV store acknowledging it.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	Resyncs = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "resyncs_total",
+		Help:      "Resyncs of the KV store with etcd after the watch fell behind compaction.",
+	})
+
+	Reconciliations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconciliations_total",
+		Help:      "Runs of the reconciler by result.",
+	}, []string{"result"})
+
+	ReconciliationDrift = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconciliation_drift_total",
+		Help:      "Keys repaired by the reconciler, by kind of drift: added, updated or deleted.",
+	}, []string{"kind"})
+
+	Leader = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "1 while the process is the elected leader ingesting into the KV store in high-availability mode.",
+	})
+
+	SearchReady = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "search_ready",
+		Help:      "1 once the initial sync is fully indexed and searchable, 0 before.",
+	})
+
+	AppliedRevision = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "applied_revision",
+		Help:      "etcd revision of the last watch event applied to the KV store.",
+	})
+)
+
+// ObserveHealthCheck counts the result of an etcd connection check
+func ObserveHealthCheck(err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	EtcdHealthChecks.WithLabelValues(result).Inc()
+}
+
+// ObserveReconciliation counts the result of a reconciler run and the keys it repaired
+func ObserveReconciliation(added, updated, deleted int, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	Reconciliations.WithLabelValues(result).Inc()
+	ReconciliationDrift.WithLabelValues("added").Add(float64(added))
+	ReconciliationDrift.WithLabelValues("updated").Add(float64(updated))
+	ReconciliationDrift.WithLabelValues("deleted").Add(float64(deleted))
+}
+
+// RegisterHeadRevision exposes the current revision of etcd, read with current when the metrics are scraped
+func RegisterHeadRevision(current func(ctx context.Context) (int64, error)) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "etcd_head_revision",
+		Help:      "Current revision of etcd, -1 if it cannot be read.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+		defer cancel()
+		revision, err := current(ctx)
+		if err != nil {
+			return -1
+		}
+		return float64(revision)
+	})
+}
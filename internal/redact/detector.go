@@ -0,0 +1,57 @@
+package redact
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// detector reports whether a value holds a secret
+type detector func(value string) bool
+
+var (
+	pemRegexp = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]+-----`)
+	jwtRegexp = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	// tokenRegexp matches the runs of characters used by base64 and hex encoded secrets
+	tokenRegexp = regexp.MustCompile(`[A-Za-z0-9+/=_-]+`)
+)
+
+func detectPEM(value string) bool {
+	return pemRegexp.MatchString(value)
+}
+
+func detectJWT(value string) bool {
+	return jwtRegexp.MatchString(value)
+}
+
+// detectEntropy returns a detector matching values with a token of at least minLength characters,
+// holding a digit, whose Shannon entropy is at least threshold bits per character
+func detectEntropy(minLength int, threshold float64) detector {
+	return func(value string) bool {
+		for _, token := range tokenRegexp.FindAllString(value, -1) {
+			if len(token) < minLength || !strings.ContainsFunc(token, unicode.IsDigit) {
+				continue
+			}
+			if entropy(token) >= threshold {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func entropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, r := range s {
+		counts[r]++
+	}
+
+	var bits float64
+	n := float64(len(s))
+	for _, count := range counts {
+		p := float64(count) / n
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
@@ -0,0 +1,42 @@
+package redact
+
+import (
+	"context"
+
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+// kvStore stores REDACTED_VALUE instead of the sensitive values, so that they never reach the index
+type kvStore struct {
+	kvstore.KVStore
+	redactor *Redactor
+}
+
+// NewKVStore wraps the store to keep sensitive values out of it
+func NewKVStore(next kvstore.KVStore, redactor *Redactor) kvstore.KVStore {
+	return &kvStore{KVStore: next, redactor: redactor}
+}
+
+func (s *kvStore) Put(ctx context.Context, key, value string) error {
+	return s.KVStore.Put(ctx, key, s.mask(key, value))
+}
+
+func (s *kvStore) PutBatch(ctx context.Context, kvs []common.KV) error {
+	masked := make([]common.KV, len(kvs))
+	for i, kv := range kvs {
+		masked[i] = kv
+		masked[i].Value = s.mask(kv.Key, kv.Value)
+	}
+	return s.KVStore.PutBatch(ctx, masked)
+}
+
+func (s *kvStore) mask(key, value string) string {
+	if !s.redactor.Sensitive(key, value) {
+		return value
+	}
+	logger.Debugf("Redacting the value of %s in the KV store", key)
+	return lib.REDACTED_VALUE
+}
@@ -0,0 +1,97 @@
+package redact
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/etcdfinder/etcdfinder/internal/authz"
+	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+)
+
+const (
+	defaultEntropyMinLength = 20
+	defaultEntropyThreshold = 4.0
+)
+
+// Redactor masks the secret values of keys matching its key patterns or detected in the values
+type Redactor struct {
+	keyPatterns []*regexp.Regexp
+	detectors   []detector
+	revealer    authz.Authorizer
+	enabled     bool
+}
+
+// New creates a redactor from the config, with no rules if redaction is disabled. Callers granted
+// the reveal permission by revealer see the values, nobody does if revealer is nil.
+func New(conf config.RedactionConfig, revealer authz.Authorizer) (*Redactor, error) {
+	r := &Redactor{revealer: revealer}
+	if !conf.Enabled {
+		return r, nil
+	}
+	r.enabled = true
+
+	for _, pattern := range conf.KeyPatterns {
+		re, err := lib.CompileGlob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key pattern %q: %w", pattern, err)
+		}
+		r.keyPatterns = append(r.keyPatterns, re)
+	}
+
+	for _, name := range conf.Detectors {
+		switch name {
+		case lib.REDACTION_DETECTOR_PEM:
+			r.detectors = append(r.detectors, detectPEM)
+		case lib.REDACTION_DETECTOR_JWT:
+			r.detectors = append(r.detectors, detectJWT)
+		case lib.REDACTION_DETECTOR_ENTROPY:
+			minLength, threshold := conf.Entropy.MinLength, conf.Entropy.Threshold
+			if minLength <= 0 {
+				minLength = defaultEntropyMinLength
+			}
+			if threshold <= 0 {
+				threshold = defaultEntropyThreshold
+			}
+			r.detectors = append(r.detectors, detectEntropy(minLength, threshold))
+		default:
+			return nil, fmt.Errorf("unknown detector %q", name)
+		}
+	}
+
+	return r, nil
+}
+
+// Enabled returns whether values may be masked
+func (r *Redactor) Enabled() bool {
+	return r.enabled
+}
+
+// Sensitive returns whether the value of the key must be redacted
+func (r *Redactor) Sensitive(key, value string) bool {
+	for _, re := range r.keyPatterns {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	for _, detect := range r.detectors {
+		if detect(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Revealed returns whether the caller may see the values of the key, even if sensitive
+func (r *Redactor) Revealed(ctx context.Context, key string) bool {
+	return r.revealer != nil && r.revealer.Allowed(ctx, authz.PermissionReveal, key)
+}
+
+// Mask returns the value, or REDACTED_VALUE if it is sensitive and the caller may not reveal it
+func (r *Redactor) Mask(ctx context.Context, key, value string) string {
+	if !r.Sensitive(key, value) || r.Revealed(ctx, key) {
+		return value
+	}
+	return lib.REDACTED_VALUE
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+
+	"github.com/etcdfinder/etcdfinder/internal/auth"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/gin-gonic/gin"
+)
+
+// AuthMiddleware authenticates the caller from the X-API-Key header, an Authorization bearer token
+// or the session cookie set by the OIDC login, and attaches its identity to the request context.
+// Unauthenticated requests are rejected.
+func AuthMiddleware(authenticator *auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		var identity *lib.Identity
+		var err error
+		if key := c.GetHeader(lib.HeaderAPIKey); key != "" {
+			identity, err = authenticator.AuthenticateAPIKey(key)
+		} else if token, ok := bearerToken(c.GetHeader("Authorization")); ok {
+			identity, err = authenticator.AuthenticateToken(ctx, token)
+		} else if cookie, cookieErr := c.Cookie(auth.SessionCookie); cookieErr == nil && authenticator.OIDCEnabled() {
+			identity, err = authenticator.AuthenticateSession(cookie)
+		} else {
+			err = customerrors.ErrUnauthenticated
+		}
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="etcdfinder"`)
+			c.Error(err) //nolint
+			c.Abort()
+			return
+		}
+
+		c.Request = c.Request.WithContext(context.WithValue(ctx, lib.CtxIdentity, identity))
+		c.Next()
+	}
+}
+
+func bearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
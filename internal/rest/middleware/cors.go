@@ -9,7 +9,8 @@ import (
 // CORSMiddleware handles CORS headers
 func CORSMiddleware(c *gin.Context) {
 	c.Writer.Header().Set("Access-Control-Allow-Origin", "*") // TODO: Set to specific origin
-	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag, X-Request-ID")
 	c.Writer.Header().Set("Access-Control-Allow-Headers", "*")
 	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
 
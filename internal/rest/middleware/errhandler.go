@@ -27,6 +27,7 @@ func ErrorHandler() gin.HandlerFunc {
 			response := customerrors.ErrorResponse{
 				Success: false,
 				Error: customerrors.ErrorDetail{
+					Code:          customerrors.CodeFromErr(err),
 					Display:       display,
 					InternalError: err.Error(),
 					Details:       details,
@@ -21,8 +21,8 @@ func LoggerMiddleware() gin.HandlerFunc {
 		latency := end.Sub(start)
 
 		if len(c.Errors) > 0 {
-			// Skip error logging for 404 responses
-			if c.Writer.Status() != http.StatusNotFound {
+			// Skip error logging for 404 responses and changes held for approval
+			if status := c.Writer.Status(); status != http.StatusNotFound && status != http.StatusAccepted {
 				for _, e := range c.Errors.Errors() {
 					logger.Errorf(e)
 				}
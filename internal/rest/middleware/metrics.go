@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsMiddleware counts the requests and measures their latency by route
+func MetricsMiddleware(c *gin.Context) {
+	start := time.Now()
+
+	c.Next()
+
+	// Unmatched paths share a label, so that arbitrary paths do not create new series
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	metrics.HTTPRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+	metrics.HTTPRequestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+}
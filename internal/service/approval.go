@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+
+	"github.com/etcdfinder/etcdfinder/internal/changes"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+)
+
+// approvalGate turns the puts and deletes of critical keys into changes awaiting approval,
+// and delegates everything else to the wrapped service
+type approvalGate struct {
+	Etcdfinder
+	manager *changes.Manager
+}
+
+// NewApprovalGate wraps the service so that changes of critical keys are only applied once approved.
+// Approved changes are applied through next.
+func NewApprovalGate(next Etcdfinder, manager *changes.Manager) Etcdfinder {
+	return &approvalGate{Etcdfinder: next, manager: manager}
+}
+
+func (a *approvalGate) PutKey(ctx context.Context, key string, value string) (int64, error) {
+	if !a.manager.IsCritical(key) {
+		return a.Etcdfinder.PutKey(ctx, key, value)
+	}
+	return 0, a.request(ctx, changes.OperationPut, key, value, changes.AnyRevision)
+}
+
+func (a *approvalGate) CompareAndPutKey(ctx context.Context, key string, value string, modRevision int64) (int64, error) {
+	if !a.manager.IsCritical(key) {
+		return a.Etcdfinder.CompareAndPutKey(ctx, key, value, modRevision)
+	}
+	return 0, a.request(ctx, changes.OperationPut, key, value, modRevision)
+}
+
+func (a *approvalGate) DeleteKey(ctx context.Context, key string) error {
+	if !a.manager.IsCritical(key) {
+		return a.Etcdfinder.DeleteKey(ctx, key)
+	}
+	return a.request(ctx, changes.OperationDelete, key, "", changes.AnyRevision)
+}
+
+func (a *approvalGate) CompareAndDeleteKey(ctx context.Context, key string, modRevision int64) error {
+	if !a.manager.IsCritical(key) {
+		return a.Etcdfinder.CompareAndDeleteKey(ctx, key, modRevision)
+	}
+	return a.request(ctx, changes.OperationDelete, key, "", modRevision)
+}
+
+// request records the change and returns ErrApprovalRequired with the ID of the change in its details
+func (a *approvalGate) request(ctx context.Context, op changes.Operation, key, value string, modRevision int64) error {
+	change, err := a.manager.Request(ctx, a.Etcdfinder, op, key, value, modRevision)
+	if err != nil {
+		return err
+	}
+	return customerrors.WithDetails(customerrors.ErrApprovalRequired, map[string]any{"change_id": change.ID})
+}
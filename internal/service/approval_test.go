@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/etcdfinder/etcdfinder/internal/authz"
+	"github.com/etcdfinder/etcdfinder/internal/changes"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+)
+
+// changeStore stores the changes in the keys of a fakeService
+type changeStore struct {
+	*fakeService
+}
+
+func (s changeStore) GetKV(ctx context.Context, key string) (common.KV, error) {
+	return s.GetKeyWithRevision(ctx, key)
+}
+
+func (s changeStore) GetPrefix(ctx context.Context, prefix string) ([]common.KV, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kvs []common.KV
+	for key, kv := range s.keys {
+		if strings.HasPrefix(key, prefix) {
+			kvs = append(kvs, kv)
+		}
+	}
+	return kvs, nil
+}
+
+func (s changeStore) CompareAndPut(ctx context.Context, key string, value string, modRevision int64) (etcd.Mutation, error) {
+	revision, err := s.CompareAndPutKey(ctx, key, value, modRevision)
+	return etcd.Mutation{Key: key, Revision: revision}, err
+}
+
+func (s changeStore) CompareAndDelete(ctx context.Context, key string, modRevision int64) (etcd.Mutation, error) {
+	return etcd.Mutation{Key: key}, s.CompareAndDeleteKey(ctx, key, modRevision)
+}
+
+func as(subject string) context.Context {
+	return context.WithValue(context.Background(), lib.CtxIdentity, &lib.Identity{Subject: subject})
+}
+
+func TestApprovalGate(t *testing.T) {
+	tests := []struct {
+		name      string
+		write     func(svc Etcdfinder, ctx context.Context, key string, revision int64) error
+		key       string
+		wantValue string // value of the key once the write is approved, empty if deleted
+	}{
+		{name: "put", key: "/prod/db", wantValue: "new", write: func(svc Etcdfinder, ctx context.Context, key string, revision int64) error {
+			_, err := svc.PutKey(ctx, key, "new")
+			return err
+		}},
+		{name: "conditional put", key: "/prod/db", wantValue: "new", write: func(svc Etcdfinder, ctx context.Context, key string, revision int64) error {
+			_, err := svc.CompareAndPutKey(ctx, key, "new", revision)
+			return err
+		}},
+		{name: "delete", key: "/prod/db", write: func(svc Etcdfinder, ctx context.Context, key string, revision int64) error {
+			return svc.DeleteKey(ctx, key)
+		}},
+		{name: "conditional delete", key: "/prod/db", write: func(svc Etcdfinder, ctx context.Context, key string, revision int64) error {
+			return svc.CompareAndDeleteKey(ctx, key, revision)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := newFakeService(map[string]string{"/prod/db": "old", "/app/db": "old"})
+			manager, err := changes.NewManager(changeStore{newFakeService(nil)}, "/etcdfinder/changes", []string{"/prod/**"}, 0, authz.NewAllowAll())
+			if err != nil {
+				t.Fatalf("NewManager: %v", err)
+			}
+			svc := NewApprovalGate(next, manager)
+
+			if err := tt.write(svc, as("alice"), tt.key, next.keys[tt.key].ModRevision); !errors.Is(err, customerrors.ErrApprovalRequired) {
+				t.Fatalf("write of a critical key = %v, want ErrApprovalRequired", err)
+			}
+			if kv := next.keys[tt.key]; kv.Value != "old" {
+				t.Fatalf("critical key was written before its approval: %q", kv.Value)
+			}
+			// Keys that are not critical are written right away
+			if err := tt.write(svc, as("alice"), "/app/db", next.keys["/app/db"].ModRevision); err != nil {
+				t.Fatalf("write of a key that is not critical: %v", err)
+			}
+
+			pending, err := manager.List(as("bob"), changes.StatusPending)
+			if err != nil || len(pending) != 1 {
+				t.Fatalf("List = %d changes, %v", len(pending), err)
+			}
+			if _, err := manager.Approve(as("alice"), next, pending[0].ID); !errors.Is(err, customerrors.ErrForbidden) {
+				t.Errorf("approval by the requester = %v, want ErrForbidden", err)
+			}
+			if _, err := manager.Approve(as("bob"), next, pending[0].ID); err != nil {
+				t.Fatalf("Approve: %v", err)
+			}
+			kv, ok := next.keys[tt.key]
+			if kv.Value != tt.wantValue || ok != (tt.wantValue != "") {
+				t.Errorf("approved key is %q, want %q", kv.Value, tt.wantValue)
+			}
+		})
+	}
+}
+
+// A critical key changed between the request and the approval is left as is
+func TestApprovalGateStaleChange(t *testing.T) {
+	next := newFakeService(map[string]string{"/prod/db": "old"})
+	manager, err := changes.NewManager(changeStore{newFakeService(nil)}, "/etcdfinder/changes", []string{"/prod/**"}, 0, authz.NewAllowAll())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	svc := NewApprovalGate(next, manager)
+
+	if _, err := svc.PutKey(as("alice"), "/prod/db", "new"); !errors.Is(err, customerrors.ErrApprovalRequired) {
+		t.Fatalf("PutKey = %v, want ErrApprovalRequired", err)
+	}
+	if _, err := svc.CompareAndPutKey(as("alice"), "/prod/db", "new", 7); !errors.Is(err, customerrors.ErrPreconditionFailed) {
+		t.Errorf("conditional put against another revision = %v, want ErrPreconditionFailed", err)
+	}
+	if _, err := next.PutKey(context.Background(), "/prod/db", "other"); err != nil {
+		t.Fatalf("PutKey: %v", err)
+	}
+
+	pending, err := manager.List(as("bob"), changes.StatusPending)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("List = %d changes, %v", len(pending), err)
+	}
+	if _, err := manager.Approve(as("bob"), next, pending[0].ID); !errors.Is(err, customerrors.ErrChangeStale) {
+		t.Errorf("Approve = %v, want ErrChangeStale", err)
+	}
+	if kv := next.keys["/prod/db"]; kv.Value != "other" {
+		t.Errorf("key is %q, want the value written after the request", kv.Value)
+	}
+	if stale, err := manager.List(as("bob"), changes.StatusStale); err != nil || len(stale) != 1 {
+		t.Errorf("List(stale) = %d changes, %v", len(stale), err)
+	}
+}
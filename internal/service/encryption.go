@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/etcdfinder/etcdfinder/internal/envelope"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+)
+
+// encrypting encrypts the values written to keys under the prefixes, and decrypts the encrypted values
+// read from any key, so that other etcd clients only see ciphertext
+type encrypting struct {
+	Etcdfinder
+	keyring  *envelope.Keyring
+	prefixes []string
+}
+
+// NewEncrypting wraps the service to encrypt the values of keys under the prefixes with the keyring
+func NewEncrypting(next Etcdfinder, keyring *envelope.Keyring, prefixes []string) Etcdfinder {
+	return &encrypting{Etcdfinder: next, keyring: keyring, prefixes: prefixes}
+}
+
+func (e *encrypting) GetKey(ctx context.Context, key string) (string, error) {
+	value, err := e.Etcdfinder.GetKey(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return e.decrypt(key, value)
+}
+
+func (e *encrypting) GetKeyWithRevision(ctx context.Context, key string) (common.KV, error) {
+	kv, err := e.Etcdfinder.GetKeyWithRevision(ctx, key)
+	if err != nil {
+		return common.KV{}, err
+	}
+	if kv.Value, err = e.decrypt(kv.Key, kv.Value); err != nil {
+		return common.KV{}, err
+	}
+	return kv, nil
+}
+
+func (e *encrypting) PutKey(ctx context.Context, key string, value string) (int64, error) {
+	value, err := e.encrypt(key, value)
+	if err != nil {
+		return 0, err
+	}
+	return e.Etcdfinder.PutKey(ctx, key, value)
+}
+
+func (e *encrypting) CompareAndPutKey(ctx context.Context, key string, value string, modRevision int64) (int64, error) {
+	value, err := e.encrypt(key, value)
+	if err != nil {
+		return 0, err
+	}
+	return e.Etcdfinder.CompareAndPutKey(ctx, key, value, modRevision)
+}
+
+func (e *encrypting) WatchKeys(ctx context.Context, prefix string) <-chan etcd.WatchEvent {
+	events := e.Etcdfinder.WatchKeys(ctx, prefix)
+
+	decrypted := make(chan etcd.WatchEvent)
+	go func() {
+		defer close(decrypted)
+		for event := range events {
+			value, err := e.decrypt(event.Key, event.Value)
+			if err != nil {
+				// The event is still streamed so that watchers learn about the change
+				logger.Errorf("Failed to decrypt watch event: %v", err)
+			}
+			event.Value = value
+			select {
+			case decrypted <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return decrypted
+}
+
+func (e *encrypting) encrypt(key, value string) (string, error) {
+	for _, prefix := range e.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return e.keyring.Encrypt(key, value)
+		}
+	}
+	return value, nil
+}
+
+func (e *encrypting) decrypt(key, value string) (string, error) {
+	if !envelope.IsEncrypted(value) {
+		return value, nil
+	}
+	return e.keyring.Decrypt(key, value)
+}
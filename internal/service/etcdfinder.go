@@ -2,42 +2,130 @@ package service
 
 import (
 	"context"
+	"strings"
 
+	"github.com/etcdfinder/etcdfinder/internal/audit"
+	"github.com/etcdfinder/etcdfinder/internal/authz"
 	"github.com/etcdfinder/etcdfinder/internal/ingestor"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
 	"github.com/etcdfinder/etcdfinder/pkg/etcd"
 	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
 )
 
 type Etcdfinder interface {
 	GetKey(ctx context.Context, key string) (string, error)
-	SearchKeys(ctx context.Context, searchStr string) ([]string, error)
-	PutKey(ctx context.Context, key string, value string) error
+	GetKeyWithRevision(ctx context.Context, key string) (common.KV, error)
+	SearchKeys(ctx context.Context, searchStr string, offset, limit int64) ([]string, error)
+	ListKeys(ctx context.Context, cursor, limit int64) ([]string, int64, error)
+	PutKey(ctx context.Context, key string, value string) (int64, error)
+	CompareAndPutKey(ctx context.Context, key string, value string, modRevision int64) (int64, error)
 	DeleteKey(ctx context.Context, key string) error
-	GetIngestionDelay(ctx context.Context) int
+	CompareAndDeleteKey(ctx context.Context, key string, modRevision int64) error
+	GetIngestionDelay(ctx context.Context) (ingestor.Delay, error)
+	GetReconciliation(ctx context.Context) ingestor.Reconciliation
+	WatchKeys(ctx context.Context, prefix string) <-chan etcd.WatchEvent
 }
 
 type DefaultEtcdfinder struct {
 	etcdClt     etcd.BaseClient
 	kvStore     kvstore.KVStore
 	ingestorClt ingestor.Base
+	authorizer  authz.Authorizer
+	auditor     audit.Recorder
 }
 
-func NewDefaultEtcdfinder(etcdClt etcd.BaseClient, kvStore kvstore.KVStore, ingestorClt ingestor.Base) Etcdfinder {
+func NewDefaultEtcdfinder(etcdClt etcd.BaseClient, kvStore kvstore.KVStore, ingestorClt ingestor.Base, authorizer authz.Authorizer, auditor audit.Recorder) Etcdfinder {
 	return &DefaultEtcdfinder{
 		etcdClt:     etcdClt,
 		kvStore:     kvStore,
 		ingestorClt: ingestorClt,
+		authorizer:  authorizer,
+		auditor:     auditor,
 	}
 }
 
 func (d *DefaultEtcdfinder) GetKey(ctx context.Context, key string) (string, error) {
+	if err := d.authorizer.Authorize(ctx, authz.PermissionRead, key); err != nil {
+		return "", err
+	}
 	// Always Read from kvStore
 	return d.etcdClt.Get(ctx, key)
 }
 
-func (d *DefaultEtcdfinder) SearchKeys(ctx context.Context, searchStr string) ([]string, error) {
+func (d *DefaultEtcdfinder) GetKeyWithRevision(ctx context.Context, key string) (common.KV, error) {
+	if err := d.authorizer.Authorize(ctx, authz.PermissionRead, key); err != nil {
+		return common.KV{}, err
+	}
+	return d.etcdClt.GetKV(ctx, key)
+}
+
+func (d *DefaultEtcdfinder) SearchKeys(ctx context.Context, searchStr string, offset, limit int64) ([]string, error) {
+	if d.authorizer.Unrestricted(ctx, authz.PermissionSearch) {
+		return d.searchKeys(ctx, searchStr, offset, limit)
+	}
+	if limit <= 0 {
+		limit = lib.DEFAULT_SEARCH_LIMIT
+	}
+
+	// Keys the caller may not search are dropped from the results, so the offset applies to the
+	// authorized keys only and the index is scanned from the start until the page is filled
+	var keys []string
+	var skipped int64
+	for pageOffset := int64(0); ; pageOffset += lib.MAX_SEARCH_LIMIT {
+		page, err := d.searchKeys(ctx, searchStr, pageOffset, lib.MAX_SEARCH_LIMIT)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range page {
+			if !d.authorizer.Allowed(ctx, authz.PermissionSearch, key) {
+				continue
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			keys = append(keys, key)
+			if int64(len(keys)) == limit {
+				return keys, nil
+			}
+		}
+		if len(page) < lib.MAX_SEARCH_LIMIT {
+			return keys, nil
+		}
+	}
+}
+
+// ListKeys returns about limit indexed keys the caller may search, from the cursor returned by the previous page,
+// 0 for the first page, along with the cursor of the next page, 0 once every key was listed. Unlike searches,
+// listings are not capped by the maximum number of hits of the search index. A key modified during the listing
+// may be listed twice.
+func (d *DefaultEtcdfinder) ListKeys(ctx context.Context, cursor, limit int64) ([]string, int64, error) {
+	if limit <= 0 {
+		limit = lib.DEFAULT_SEARCH_LIMIT
+	}
+
+	// Cursors are the revision the page starts after, shifted so that 0 starts the listing
+	kvs, err := d.kvStore.Scan(ctx, cursor+kvstore.ScanStart, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(kvs) == 0 {
+		return []string{}, 0, nil
+	}
+
+	keys := make([]string, 0, len(kvs))
+	for _, kv := range kvs {
+		if d.authorizer.Allowed(ctx, authz.PermissionSearch, kv.Key) {
+			keys = append(keys, kv.Key)
+		}
+	}
+	return keys, kvs[len(kvs)-1].ModRevision - kvstore.ScanStart, nil
+}
+
+func (d *DefaultEtcdfinder) searchKeys(ctx context.Context, searchStr string, offset, limit int64) ([]string, error) {
 	var keys []string
-	kvs, err := d.kvStore.Search(ctx, searchStr)
+	kvs, err := d.kvStore.Search(ctx, searchStr, offset, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -47,22 +135,100 @@ func (d *DefaultEtcdfinder) SearchKeys(ctx context.Context, searchStr string) ([
 	return keys, nil
 }
 
-func (d *DefaultEtcdfinder) PutKey(ctx context.Context, key string, value string) error {
-	key, err := d.etcdClt.Put(ctx, key, value)
+func (d *DefaultEtcdfinder) PutKey(ctx context.Context, key string, value string) (int64, error) {
+	if err := d.authorizer.Authorize(ctx, authz.PermissionWrite, key); err != nil {
+		return 0, err
+	}
+	mutation, err := d.etcdClt.Put(ctx, key, value)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return d.kvStore.Put(ctx, key, value)
+	d.record(ctx, audit.OperationPut, mutation, &value)
+	// The index is updated by the watch of the ingestor, in the order of the revisions
+	return mutation.Revision, nil
+}
+
+func (d *DefaultEtcdfinder) CompareAndPutKey(ctx context.Context, key string, value string, modRevision int64) (int64, error) {
+	if err := d.authorizer.Authorize(ctx, authz.PermissionWrite, key); err != nil {
+		return 0, err
+	}
+	mutation, err := d.etcdClt.CompareAndPut(ctx, key, value, modRevision)
+	if err != nil {
+		return 0, err
+	}
+	d.record(ctx, audit.OperationPut, mutation, &value)
+	// The index is updated by the watch of the ingestor, in the order of the revisions
+	return mutation.Revision, nil
 }
 
 func (d *DefaultEtcdfinder) DeleteKey(ctx context.Context, key string) error {
-	key, err := d.etcdClt.Delete(ctx, key)
+	if err := d.authorizer.Authorize(ctx, authz.PermissionDelete, key); err != nil {
+		return err
+	}
+	mutation, err := d.etcdClt.Delete(ctx, key)
 	if err != nil {
 		return err
 	}
-	return d.kvStore.Delete(ctx, key)
+	d.record(ctx, audit.OperationDelete, mutation, nil)
+	return nil
 }
 
-func (d *DefaultEtcdfinder) GetIngestionDelay(ctx context.Context) int {
+func (d *DefaultEtcdfinder) CompareAndDeleteKey(ctx context.Context, key string, modRevision int64) error {
+	if err := d.authorizer.Authorize(ctx, authz.PermissionDelete, key); err != nil {
+		return err
+	}
+	mutation, err := d.etcdClt.CompareAndDelete(ctx, key, modRevision)
+	if err != nil {
+		return err
+	}
+	d.record(ctx, audit.OperationDelete, mutation, nil)
+	return nil
+}
+
+// record audits the mutation, along with the previous value etcd returned with it. The new value is nil for deletes.
+func (d *DefaultEtcdfinder) record(ctx context.Context, op audit.Operation, mutation etcd.Mutation, value *string) {
+	change := audit.Change{
+		Operation: op,
+		Key:       mutation.Key,
+		NewValue:  value,
+		Revision:  mutation.Revision,
+	}
+	if mutation.Prev != nil {
+		change.OldValue = &mutation.Prev.Value
+		change.PrevRevision = mutation.Prev.ModRevision
+	}
+	d.auditor.Record(ctx, change)
+}
+
+func (d *DefaultEtcdfinder) GetIngestionDelay(ctx context.Context) (ingestor.Delay, error) {
 	return d.ingestorClt.GetIngestionDelay(ctx)
 }
+
+func (d *DefaultEtcdfinder) GetReconciliation(ctx context.Context) ingestor.Reconciliation {
+	return d.ingestorClt.GetReconciliation()
+}
+
+// WatchKeys streams the changes applied to keys under prefix until ctx is done
+func (d *DefaultEtcdfinder) WatchKeys(ctx context.Context, prefix string) <-chan etcd.WatchEvent {
+	events := d.ingestorClt.Subscribe(ctx)
+	if prefix == "" && d.authorizer.Unrestricted(ctx, authz.PermissionRead) {
+		return events
+	}
+
+	// Only changes to keys the caller may read are streamed
+	filtered := make(chan etcd.WatchEvent)
+	go func() {
+		defer close(filtered)
+		for event := range events {
+			if !strings.HasPrefix(event.Key, prefix) || !d.authorizer.Allowed(ctx, authz.PermissionRead, event.Key) {
+				continue
+			}
+			select {
+			case filtered <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return filtered
+}
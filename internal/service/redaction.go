@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/internal/redact"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+)
+
+// redacting masks the sensitive values returned by the wrapped service, unless the caller may reveal them
+type redacting struct {
+	Etcdfinder
+	redactor *redact.Redactor
+}
+
+// NewRedacting wraps the service to mask the sensitive values it returns
+func NewRedacting(next Etcdfinder, redactor *redact.Redactor) Etcdfinder {
+	return &redacting{Etcdfinder: next, redactor: redactor}
+}
+
+func (r *redacting) GetKey(ctx context.Context, key string) (string, error) {
+	value, err := r.Etcdfinder.GetKey(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return r.redactor.Mask(ctx, key, value), nil
+}
+
+func (r *redacting) GetKeyWithRevision(ctx context.Context, key string) (common.KV, error) {
+	kv, err := r.Etcdfinder.GetKeyWithRevision(ctx, key)
+	if err != nil {
+		return common.KV{}, err
+	}
+	kv.Value = r.redactor.Mask(ctx, kv.Key, kv.Value)
+	return kv, nil
+}
+
+// PutKey refuses to write the placeholder of a value the caller cannot reveal, which would most likely be
+// a masked value read and written back, destroying the secret
+func (r *redacting) PutKey(ctx context.Context, key string, value string) (int64, error) {
+	if err := r.checkWritable(ctx, key, value); err != nil {
+		return 0, err
+	}
+	return r.Etcdfinder.PutKey(ctx, key, value)
+}
+
+func (r *redacting) CompareAndPutKey(ctx context.Context, key string, value string, modRevision int64) (int64, error) {
+	if err := r.checkWritable(ctx, key, value); err != nil {
+		return 0, err
+	}
+	return r.Etcdfinder.CompareAndPutKey(ctx, key, value, modRevision)
+}
+
+func (r *redacting) checkWritable(ctx context.Context, key, value string) error {
+	if r.redactor.Enabled() && value == lib.REDACTED_VALUE && !r.redactor.Revealed(ctx, key) {
+		return fmt.Errorf("%s: %w", key, customerrors.ErrValueRedacted)
+	}
+	return nil
+}
+
+func (r *redacting) WatchKeys(ctx context.Context, prefix string) <-chan etcd.WatchEvent {
+	events := r.Etcdfinder.WatchKeys(ctx, prefix)
+
+	masked := make(chan etcd.WatchEvent)
+	go func() {
+		defer close(masked)
+		for event := range events {
+			event.Value = r.redactor.Mask(ctx, event.Key, event.Value)
+			select {
+			case masked <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return masked
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/etcdfinder/etcdfinder/internal/authz"
+	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/internal/redact"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+)
+
+// fakeService keeps the keys in memory along with their mod revisions, the methods the tests do not use panic
+type fakeService struct {
+	Etcdfinder
+
+	mu       sync.Mutex
+	keys     map[string]common.KV
+	revision int64
+}
+
+func newFakeService(values map[string]string) *fakeService {
+	s := &fakeService{keys: map[string]common.KV{}}
+	for key, value := range values {
+		s.revision++
+		s.keys[key] = common.KV{Key: key, Value: value, ModRevision: s.revision}
+	}
+	return s
+}
+
+func (s *fakeService) GetKey(ctx context.Context, key string) (string, error) {
+	kv, err := s.GetKeyWithRevision(ctx, key)
+	return kv.Value, err
+}
+
+func (s *fakeService) GetKeyWithRevision(ctx context.Context, key string) (common.KV, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kv, ok := s.keys[key]
+	if !ok {
+		return common.KV{}, customerrors.ErrKeyNotFound
+	}
+	return kv, nil
+}
+
+func (s *fakeService) PutKey(ctx context.Context, key string, value string) (int64, error) {
+	return s.CompareAndPutKey(ctx, key, value, -1)
+}
+
+// CompareAndPutKey puts the key if it is at modRevision, 0 meaning absent and -1 any revision
+func (s *fakeService) CompareAndPutKey(ctx context.Context, key string, value string, modRevision int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if modRevision >= 0 && s.keys[key].ModRevision != modRevision {
+		return 0, customerrors.ErrPreconditionFailed
+	}
+	s.revision++
+	s.keys[key] = common.KV{Key: key, Value: value, ModRevision: s.revision}
+	return s.revision, nil
+}
+
+func (s *fakeService) DeleteKey(ctx context.Context, key string) error {
+	return s.CompareAndDeleteKey(ctx, key, -1)
+}
+
+func (s *fakeService) CompareAndDeleteKey(ctx context.Context, key string, modRevision int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kv, ok := s.keys[key]
+	if modRevision >= 0 && kv.ModRevision != modRevision {
+		return customerrors.ErrPreconditionFailed
+	}
+	if !ok {
+		return customerrors.ErrKeyNotFound
+	}
+	s.revision++
+	delete(s.keys, key)
+	return nil
+}
+
+// revealer grants the reveal permission on the keys under prefix to every caller
+type revealer struct {
+	authz.Authorizer
+	prefix string
+}
+
+func (r revealer) Allowed(ctx context.Context, permission authz.Permission, key string) bool {
+	return permission == authz.PermissionReveal && strings.HasPrefix(key, r.prefix)
+}
+
+func TestRedacting(t *testing.T) {
+	redactor, err := redact.New(config.RedactionConfig{Enabled: true, KeyPatterns: []string{"/**/password"}}, revealer{prefix: "/team/"})
+	if err != nil {
+		t.Fatalf("redact.New: %v", err)
+	}
+	next := newFakeService(map[string]string{
+		"/app/db/password":  "hunter2",
+		"/app/db/host":      "db.local",
+		"/team/db/password": "swordfish",
+	})
+	svc := NewRedacting(next, redactor)
+	ctx := context.Background()
+
+	t.Run("masked get", func(t *testing.T) {
+		for key, want := range map[string]string{
+			"/app/db/password":  lib.REDACTED_VALUE,
+			"/app/db/host":      "db.local",
+			"/team/db/password": "swordfish",
+		} {
+			if value, err := svc.GetKey(ctx, key); err != nil || value != want {
+				t.Errorf("GetKey(%s) = %q, %v, want %q", key, value, err, want)
+			}
+			if kv, err := svc.GetKeyWithRevision(ctx, key); err != nil || kv.Value != want {
+				t.Errorf("GetKeyWithRevision(%s) = %q, %v, want %q", key, kv.Value, err, want)
+			}
+		}
+	})
+
+	t.Run("placeholder written back", func(t *testing.T) {
+		value, err := svc.GetKey(ctx, "/app/db/password")
+		if err != nil {
+			t.Fatalf("GetKey: %v", err)
+		}
+		if _, err := svc.PutKey(ctx, "/app/db/password", value); !errors.Is(err, customerrors.ErrValueRedacted) {
+			t.Errorf("PutKey of the placeholder = %v, want ErrValueRedacted", err)
+		}
+		if _, err := svc.CompareAndPutKey(ctx, "/app/db/password", value, 1); !errors.Is(err, customerrors.ErrValueRedacted) {
+			t.Errorf("CompareAndPutKey of the placeholder = %v, want ErrValueRedacted", err)
+		}
+		if kv := next.keys["/app/db/password"]; kv.Value != "hunter2" {
+			t.Errorf("secret was overwritten with %q", kv.Value)
+		}
+	})
+
+	t.Run("other writes", func(t *testing.T) {
+		if _, err := svc.PutKey(ctx, "/app/db/password", "correct horse"); err != nil {
+			t.Errorf("PutKey of a new secret: %v", err)
+		}
+		// Callers who may reveal the key read its actual value, the placeholder is what they mean to write
+		if _, err := svc.PutKey(ctx, "/team/db/password", lib.REDACTED_VALUE); err != nil {
+			t.Errorf("PutKey of the placeholder by a revealer: %v", err)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		disabled, err := redact.New(config.RedactionConfig{}, nil)
+		if err != nil {
+			t.Fatalf("redact.New: %v", err)
+		}
+		if _, err := NewRedacting(next, disabled).PutKey(ctx, "/app/db/host", lib.REDACTED_VALUE); err != nil {
+			t.Errorf("PutKey of the placeholder without redaction: %v", err)
+		}
+	})
+}
@@ -0,0 +1,533 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/pkg/client"
+	"github.com/gdamore/tcell/v2"
+)
+
+// delay after the last keystroke before the search is sent
+const searchDebounce = 150 * time.Millisecond
+
+const helpText = "Tab: switch pane  /: search  Enter: open  e: edit  d: delete  r: reload  q: quit"
+
+type pane int
+
+const (
+	paneTree pane = iota
+	paneSearch
+	panePreview
+)
+
+// Events posted from background goroutines to the event loop, which owns all state
+type (
+	keysLoaded struct {
+		keys []string
+		err  error
+	}
+	searchDue struct {
+		seq int
+	}
+	searchDone struct {
+		seq  int
+		keys []string
+		err  error
+	}
+	valueLoaded struct {
+		key   string
+		value string
+		err   error
+	}
+	watchReceived struct {
+		event client.WatchEvent
+	}
+	watchEnded struct {
+		err error
+	}
+	opDone struct {
+		status string
+		err    error
+	}
+	quit struct{}
+)
+
+// App is the full-screen terminal UI
+type App struct {
+	ctx    context.Context
+	clt    *client.Client
+	screen tcell.Screen
+
+	focus pane
+
+	tree     *tree
+	treeList list
+
+	query      string
+	searchSeq  int // incremented on every keystroke so that stale results are dropped
+	results    []string
+	resultList list
+
+	selectedKey string
+	preview     []string
+	previewList list
+
+	status        string
+	pendingDelete string // key awaiting confirmation of its deletion
+}
+
+// Run starts the terminal UI and blocks until the user quits or ctx is done
+func Run(ctx context.Context, clt *client.Client) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return fmt.Errorf("failed to create screen: %w", err)
+	}
+	if err := screen.Init(); err != nil {
+		return fmt.Errorf("failed to initialize screen: %w", err)
+	}
+	defer screen.Fini()
+
+	app := &App{
+		ctx:    ctx,
+		clt:    clt,
+		screen: screen,
+		tree:   newTree(),
+		status: "Loading keys...",
+	}
+
+	go func() {
+		<-ctx.Done()
+		app.post(quit{})
+	}()
+	go app.loadKeys()
+	go app.watch()
+
+	for {
+		app.draw()
+
+		switch ev := screen.PollEvent().(type) {
+		case nil:
+			return nil
+		case *tcell.EventResize:
+			screen.Sync()
+		case *tcell.EventKey:
+			if app.handleKey(ev) {
+				return nil
+			}
+		case *tcell.EventInterrupt:
+			if _, ok := ev.Data().(quit); ok {
+				return nil
+			}
+			app.handleEvent(ev.Data())
+		}
+	}
+}
+
+func (a *App) post(data any) {
+	_ = a.screen.PostEvent(tcell.NewEventInterrupt(data))
+}
+
+func (a *App) loadKeys() {
+	keys := []string{}
+	seen := map[string]bool{}
+	// Searches are capped by the search index, listing returns every key
+	for key, err := range a.clt.ListKeysIter(a.ctx, lib.MAX_SEARCH_LIMIT) {
+		if err != nil {
+			a.post(keysLoaded{err: err})
+			return
+		}
+		// Keys modified during the listing are listed twice
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	a.post(keysLoaded{keys: keys})
+}
+
+func (a *App) watch() {
+	eventCh, errCh := a.clt.WatchKeys(a.ctx, "")
+	for event := range eventCh {
+		a.post(watchReceived{event: event})
+	}
+	a.post(watchEnded{err: <-errCh})
+}
+
+func (a *App) search(seq int, query string) {
+	keys, err := a.clt.SearchKeys(a.ctx, query, 0, lib.MAX_SEARCH_LIMIT)
+	a.post(searchDone{seq: seq, keys: keys, err: err})
+}
+
+func (a *App) loadValue(key string) {
+	value, err := a.clt.GetKey(a.ctx, key)
+	a.post(valueLoaded{key: key, value: value, err: err})
+}
+
+func (a *App) deleteKey(key string) {
+	err := a.clt.DeleteKey(a.ctx, key)
+	a.post(opDone{status: "Deleted " + key, err: err})
+}
+
+func (a *App) handleEvent(data any) {
+	switch ev := data.(type) {
+	case keysLoaded:
+		if ev.err != nil {
+			a.status = "Failed to load keys: " + ev.err.Error()
+			return
+		}
+		a.tree.add(ev.keys...)
+		a.status = fmt.Sprintf("Loaded %d keys", len(ev.keys))
+	case searchDue:
+		if ev.seq == a.searchSeq {
+			go a.search(ev.seq, a.query)
+		}
+	case searchDone:
+		if ev.seq != a.searchSeq {
+			return
+		}
+		if ev.err != nil {
+			a.status = "Search failed: " + ev.err.Error()
+			return
+		}
+		a.results = ev.keys
+		a.resultList = list{}
+	case valueLoaded:
+		if ev.key != a.selectedKey {
+			return
+		}
+		if ev.err != nil {
+			a.preview = []string{"Failed to get value: " + ev.err.Error()}
+			return
+		}
+		a.preview = formatValue(ev.value)
+	case watchReceived:
+		a.applyWatchEvent(ev.event)
+	case watchEnded:
+		if ev.err != nil {
+			a.status = "Live updates stopped: " + ev.err.Error()
+		} else {
+			a.status = "Live updates stopped"
+		}
+	case opDone:
+		if ev.err != nil {
+			a.status = "Failed: " + ev.err.Error()
+			return
+		}
+		a.status = ev.status
+	}
+}
+
+func (a *App) applyWatchEvent(event client.WatchEvent) {
+	switch event.Type {
+	case "PUT":
+		a.tree.add(event.Key)
+		if event.Key == a.selectedKey {
+			a.preview = formatValue(event.Value)
+		}
+	case "DELETE":
+		a.tree.remove(event.Key)
+		if event.Key == a.selectedKey {
+			a.preview = []string{"(deleted)"}
+		}
+	}
+}
+
+func (a *App) selectKey(key string) {
+	a.selectedKey = key
+	a.preview = []string{"Loading..."}
+	a.previewList = list{}
+	go a.loadValue(key)
+}
+
+// handleKey processes a key press and reports whether the application should exit
+func (a *App) handleKey(ev *tcell.EventKey) bool {
+	if ev.Key() == tcell.KeyCtrlC {
+		return true
+	}
+	a.status = ""
+
+	if a.pendingDelete != "" {
+		if ev.Key() == tcell.KeyRune && (ev.Rune() == 'y' || ev.Rune() == 'Y') {
+			a.status = "Deleting " + a.pendingDelete + "..."
+			go a.deleteKey(a.pendingDelete)
+		} else {
+			a.status = "Delete cancelled"
+		}
+		a.pendingDelete = ""
+		return false
+	}
+
+	switch ev.Key() {
+	case tcell.KeyTab:
+		a.focus = (a.focus + 1) % 3
+		return false
+	case tcell.KeyBacktab:
+		a.focus = (a.focus + 2) % 3
+		return false
+	}
+
+	if a.focus == paneSearch {
+		a.handleSearchKey(ev)
+		return false
+	}
+
+	if ev.Key() == tcell.KeyRune {
+		switch ev.Rune() {
+		case 'q':
+			return true
+		case '/':
+			a.focus = paneSearch
+			return false
+		case 'e':
+			a.edit()
+			return false
+		case 'd':
+			if a.selectedKey != "" {
+				a.pendingDelete = a.selectedKey
+				a.status = "Delete " + a.selectedKey + "? (y/n)"
+			}
+			return false
+		case 'r':
+			a.tree = newTree()
+			a.status = "Loading keys..."
+			go a.loadKeys()
+			if a.selectedKey != "" {
+				a.selectKey(a.selectedKey)
+			}
+			return false
+		}
+	}
+
+	if a.focus == paneTree {
+		a.handleTreeKey(ev)
+	} else {
+		a.handlePreviewKey(ev)
+	}
+	return false
+}
+
+func (a *App) handleSearchKey(ev *tcell.EventKey) {
+	switch ev.Key() {
+	case tcell.KeyEscape:
+		a.focus = paneTree
+	case tcell.KeyUp:
+		a.resultList.cursor--
+	case tcell.KeyDown:
+		a.resultList.cursor++
+	case tcell.KeyEnter:
+		if a.resultList.cursor < len(a.results) {
+			a.selectKey(a.results[a.resultList.cursor])
+		}
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if a.query != "" {
+			runes := []rune(a.query)
+			a.query = string(runes[:len(runes)-1])
+			a.scheduleSearch()
+		}
+	case tcell.KeyRune:
+		a.query += string(ev.Rune())
+		a.scheduleSearch()
+	}
+}
+
+// scheduleSearch sends the search once the user stopped typing for searchDebounce
+func (a *App) scheduleSearch() {
+	a.searchSeq++
+	seq := a.searchSeq
+	time.AfterFunc(searchDebounce, func() {
+		a.post(searchDue{seq: seq})
+	})
+}
+
+func (a *App) handleTreeKey(ev *tcell.EventKey) {
+	rows := a.tree.rows
+	if len(rows) == 0 {
+		return
+	}
+	a.treeList.clamp(len(rows), 0)
+	current := rows[a.treeList.cursor]
+
+	switch {
+	case ev.Key() == tcell.KeyUp || ev.Rune() == 'k':
+		a.treeList.cursor--
+	case ev.Key() == tcell.KeyDown || ev.Rune() == 'j':
+		a.treeList.cursor++
+	case ev.Key() == tcell.KeyEnter || ev.Key() == tcell.KeyRight || ev.Rune() == 'l' || ev.Rune() == ' ':
+		if current.node.isKey {
+			a.selectKey(current.node.path)
+		}
+		if ev.Key() != tcell.KeyRight || !a.tree.expanded[current.node.path] {
+			a.tree.toggle(current)
+		}
+	case ev.Key() == tcell.KeyLeft || ev.Rune() == 'h':
+		// Collapse the current directory, or jump to and collapse its parent
+		if !a.tree.expanded[current.node.path] && current.node.parent != a.tree.root {
+			for i, r := range a.tree.rows {
+				if r.node == current.node.parent {
+					a.treeList.cursor = i
+					current = r
+					break
+				}
+			}
+		}
+		if a.tree.expanded[current.node.path] {
+			a.tree.toggle(current)
+		}
+	}
+}
+
+func (a *App) handlePreviewKey(ev *tcell.EventKey) {
+	_, height := a.screen.Size()
+	page := max(1, height-4)
+
+	switch {
+	case ev.Key() == tcell.KeyUp || ev.Rune() == 'k':
+		a.previewList.scroll(-1)
+	case ev.Key() == tcell.KeyDown || ev.Rune() == 'j':
+		a.previewList.scroll(1)
+	case ev.Key() == tcell.KeyPgUp:
+		a.previewList.scroll(-page)
+	case ev.Key() == tcell.KeyPgDn:
+		a.previewList.scroll(page)
+	}
+}
+
+// edit opens the selected key's value in $EDITOR and saves it if it changed
+func (a *App) edit() {
+	if a.selectedKey == "" {
+		return
+	}
+	key := a.selectedKey
+
+	value, err := a.clt.GetKey(a.ctx, key)
+	if err != nil {
+		a.status = "Failed to get value: " + err.Error()
+		return
+	}
+	if value == lib.REDACTED_VALUE {
+		// Saving the placeholder would replace the secret
+		a.status = "Cannot edit " + key + ": the value is redacted"
+		return
+	}
+
+	edited, err := a.runEditor(value)
+	if err != nil {
+		a.status = "Edit failed: " + err.Error()
+		return
+	}
+	if edited == value {
+		a.status = "No changes"
+		return
+	}
+
+	if err := a.clt.PutKey(a.ctx, key, edited); err != nil {
+		a.status = "Failed to save: " + err.Error()
+		return
+	}
+	a.status = "Saved " + key
+	a.preview = formatValue(edited)
+}
+
+func (a *App) runEditor(value string) (string, error) {
+	f, err := os.CreateTemp("", "etcdfinder-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name()) //nolint
+
+	if _, err := f.WriteString(value); err != nil {
+		f.Close() //nolint
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+
+	if err := a.screen.Suspend(); err != nil {
+		return "", err
+	}
+	cmd := exec.CommandContext(a.ctx, editor, f.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	runErr := cmd.Run()
+	if err := a.screen.Resume(); err != nil {
+		return "", err
+	}
+	if runErr != nil {
+		return "", runErr
+	}
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (a *App) draw() {
+	s := a.screen
+	width, height := s.Size()
+	if width < 20 || height < 5 {
+		return
+	}
+
+	// Search line
+	searchStyle := styleTitle
+	if a.focus == paneSearch {
+		searchStyle = styleFocused
+	}
+	drawText(s, 0, 0, 9, " Search: ", searchStyle)
+	drawText(s, 9, 0, width-9, a.query, styleDefault)
+	if a.focus == paneSearch {
+		s.ShowCursor(9+len([]rune(a.query)), 0)
+	} else {
+		s.HideCursor()
+	}
+
+	treeWidth := width * 3 / 10
+	resultsWidth := width * 3 / 10
+	previewX := treeWidth + resultsWidth + 2
+	previewWidth := width - previewX
+	top, bottom := 1, height-2
+	bodyHeight := bottom - top
+
+	titleStyle := func(p pane) tcell.Style {
+		if a.focus == p {
+			return styleFocused
+		}
+		return styleTitle
+	}
+	drawText(s, 0, top, treeWidth, " Keys", titleStyle(paneTree))
+	drawText(s, treeWidth+1, top, resultsWidth, " Results", titleStyle(paneSearch))
+	drawText(s, previewX, top, previewWidth, " "+a.selectedKey, titleStyle(panePreview))
+	drawVLine(s, treeWidth, top, bottom)
+	drawVLine(s, treeWidth+1+resultsWidth, top, bottom)
+
+	treeLines := make([]string, len(a.tree.rows))
+	for i, r := range a.tree.rows {
+		treeLines[i] = a.tree.label(r)
+	}
+	a.treeList.draw(s, 0, top+1, treeWidth, bodyHeight, treeLines, a.focus == paneTree)
+	a.resultList.draw(s, treeWidth+1, top+1, resultsWidth, bodyHeight, a.results, a.focus == paneSearch)
+	a.previewList.draw(s, previewX, top+1, previewWidth, bodyHeight, a.preview, false)
+
+	status := a.status
+	if status == "" {
+		status = helpText
+	}
+	drawText(s, 0, height-1, width, " "+status, styleStatus)
+
+	s.Show()
+}
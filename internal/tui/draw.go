@@ -0,0 +1,93 @@
+package tui
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+)
+
+var (
+	styleDefault  = tcell.StyleDefault
+	styleTitle    = tcell.StyleDefault.Bold(true)
+	styleFocused  = tcell.StyleDefault.Bold(true).Reverse(true)
+	styleSelected = tcell.StyleDefault.Reverse(true)
+	styleStatus   = tcell.StyleDefault.Foreground(tcell.ColorYellow)
+	styleBorder   = tcell.StyleDefault.Foreground(tcell.ColorGray)
+)
+
+// drawText draws s at (x, y), truncated to width cells, and pads the rest of the width with spaces
+func drawText(s tcell.Screen, x, y, width int, text string, style tcell.Style) {
+	col := 0
+	for _, r := range text {
+		w := runewidth.RuneWidth(r)
+		if col+w > width {
+			break
+		}
+		s.SetContent(x+col, y, r, nil, style)
+		col += w
+	}
+	for ; col < width; col++ {
+		s.SetContent(x+col, y, ' ', nil, style)
+	}
+}
+
+func drawVLine(s tcell.Screen, x, y1, y2 int) {
+	for y := y1; y <= y2; y++ {
+		s.SetContent(x, y, tcell.RuneVLine, nil, styleBorder)
+	}
+}
+
+// list is a scrollable list of lines with a cursor
+type list struct {
+	cursor int
+	offset int
+}
+
+// clamp keeps the cursor within n items and scrolls so that it stays visible in height lines
+func (l *list) clamp(n, height int) {
+	l.cursor = max(0, min(l.cursor, n-1))
+	if l.cursor < l.offset {
+		l.offset = l.cursor
+	}
+	if height > 0 && l.cursor >= l.offset+height {
+		l.offset = l.cursor - height + 1
+	}
+	l.offset = max(0, min(l.offset, n-1))
+}
+
+// scroll moves the view by delta lines, for lists without a visible cursor
+func (l *list) scroll(delta int) {
+	l.offset = max(0, l.offset+delta)
+	l.cursor = l.offset
+}
+
+func (l *list) draw(s tcell.Screen, x, y, width, height int, lines []string, showCursor bool) {
+	l.clamp(len(lines), height)
+	for i := 0; i < height; i++ {
+		idx := l.offset + i
+		if idx >= len(lines) {
+			drawText(s, x, y+i, width, "", styleDefault)
+			continue
+		}
+		style := styleDefault
+		if showCursor && idx == l.cursor {
+			style = styleSelected
+		}
+		drawText(s, x, y+i, width, lines[idx], style)
+	}
+}
+
+// formatValue pretty-prints JSON values and splits the value into displayable lines
+func formatValue(value string) []string {
+	if json.Valid([]byte(value)) {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, []byte(value), "", "  "); err == nil {
+			value = buf.String()
+		}
+	}
+	value = strings.ReplaceAll(value, "\t", "    ")
+	return strings.Split(value, "\n")
+}
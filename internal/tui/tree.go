@@ -0,0 +1,132 @@
+package tui
+
+import (
+	"sort"
+	"strings"
+)
+
+// node is an entry of the key tree, keys are split into segments on "/"
+type node struct {
+	name     string
+	path     string // full path of the node, the key itself for leaves
+	isKey    bool   // whether path is a key, directories may be keys as well
+	parent   *node
+	children map[string]*node
+}
+
+// row is a visible line of the tree pane
+type row struct {
+	node  *node
+	depth int
+}
+
+// tree is the hierarchical view of the indexed keys
+type tree struct {
+	root     *node
+	expanded map[string]bool
+	rows     []row
+}
+
+func newTree() *tree {
+	return &tree{
+		root:     &node{children: map[string]*node{}},
+		expanded: map[string]bool{},
+	}
+}
+
+// splitKey splits a key into its segments, keeping the leading separator on the first one
+func splitKey(key string) []string {
+	parts := strings.SplitAfter(key, "/")
+	segments := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part != "" {
+			segments = append(segments, part)
+		}
+	}
+	return segments
+}
+
+// add inserts the keys, then recomputes the visible rows once
+func (t *tree) add(keys ...string) {
+	for _, key := range keys {
+		n := t.root
+		path := ""
+		for _, segment := range splitKey(key) {
+			path += segment
+			child, ok := n.children[segment]
+			if !ok {
+				child = &node{
+					name:     segment,
+					path:     path,
+					parent:   n,
+					children: map[string]*node{},
+				}
+				n.children[segment] = child
+			}
+			n = child
+		}
+		n.isKey = true
+	}
+	t.flatten()
+}
+
+func (t *tree) remove(key string) {
+	n := t.root
+	for _, segment := range splitKey(key) {
+		child, ok := n.children[segment]
+		if !ok {
+			return
+		}
+		n = child
+	}
+	n.isKey = false
+
+	// Prune directories left without keys
+	for n != t.root && !n.isKey && len(n.children) == 0 {
+		delete(n.parent.children, n.name)
+		n = n.parent
+	}
+	t.flatten()
+}
+
+func (t *tree) toggle(r row) {
+	if len(r.node.children) == 0 {
+		return
+	}
+	t.expanded[r.node.path] = !t.expanded[r.node.path]
+	t.flatten()
+}
+
+// flatten recomputes the visible rows from the expanded directories
+func (t *tree) flatten() {
+	t.rows = t.rows[:0]
+	var walk func(n *node, depth int)
+	walk = func(n *node, depth int) {
+		names := make([]string, 0, len(n.children))
+		for name := range n.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			child := n.children[name]
+			t.rows = append(t.rows, row{node: child, depth: depth})
+			if t.expanded[child.path] {
+				walk(child, depth+1)
+			}
+		}
+	}
+	walk(t.root, 0)
+}
+
+// label renders a row with its indentation and expansion marker
+func (t *tree) label(r row) string {
+	marker := "  "
+	if len(r.node.children) > 0 {
+		marker = "+ "
+		if t.expanded[r.node.path] {
+			marker = "- "
+		}
+	}
+	return strings.Repeat("  ", r.depth) + marker + r.node.name
+}
@@ -2,15 +2,34 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/etcdfinder/etcdfinder/internal/api"
+	"github.com/etcdfinder/etcdfinder/internal/api/health"
+	"github.com/etcdfinder/etcdfinder/internal/api/login"
 	v1 "github.com/etcdfinder/etcdfinder/internal/api/v1"
+	v2 "github.com/etcdfinder/etcdfinder/internal/api/v2"
+	"github.com/etcdfinder/etcdfinder/internal/audit"
+	"github.com/etcdfinder/etcdfinder/internal/auth"
+	"github.com/etcdfinder/etcdfinder/internal/authz"
+	"github.com/etcdfinder/etcdfinder/internal/changes"
+	"github.com/etcdfinder/etcdfinder/internal/cli"
 	"github.com/etcdfinder/etcdfinder/internal/config"
+	"github.com/etcdfinder/etcdfinder/internal/envelope"
 	"github.com/etcdfinder/etcdfinder/internal/ingestor"
 	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/internal/metrics"
+	"github.com/etcdfinder/etcdfinder/internal/redact"
 	"github.com/etcdfinder/etcdfinder/internal/service"
 	"github.com/etcdfinder/etcdfinder/pkg/etcd"
 	"github.com/etcdfinder/etcdfinder/pkg/kvstore"
@@ -18,11 +37,18 @@ import (
 )
 
 func main() {
+	// Subcommands run the command line client against a running server
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		os.Exit(cli.Execute(os.Args[1:]))
+	}
+
 	var configPath string
 	flag.StringVar(&configPath, "config", "", "Path to configuration file")
 	flag.Parse()
 
-	ctx := context.Background()
+	// The root context is cancelled on SIGINT or SIGTERM to shut down gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	conf, err := config.Load(configPath)
 	if err != nil {
@@ -60,7 +86,30 @@ func main() {
 	if err != nil {
 		logger.Fatalf("Failed to create etcd client: %v", err)
 	}
-	defer etcdClient.Close() //nolint
+
+	authorizer := authz.NewAllowAll()
+	if conf.Authz.Enabled {
+		if !conf.Auth.Enabled {
+			logger.Fatalf("Authorization requires authentication to be enabled")
+		}
+		authorizer, err = authz.NewPolicy(conf.Authz)
+		if err != nil {
+			logger.Fatalf("Failed to load authorization policy: %v", err)
+		}
+	}
+
+	// The keys etcdfinder stores for itself are protected as well
+	authorizer = authz.NewProtected(slices.Concat(conf.Server.ProtectedPrefixes, conf.InternalPrefixes()), authorizer)
+
+	// Only callers granted the reveal permission by the policy see redacted values
+	var revealer authz.Authorizer
+	if conf.Authz.Enabled {
+		revealer = authorizer
+	}
+	redactor, err := redact.New(conf.Redaction, revealer)
+	if err != nil {
+		logger.Fatalf("Failed to load redaction rules: %v", err)
+	}
 
 	// Initialize Elasticsearch KV store
 	var kvStore kvstore.KVStore
@@ -68,56 +117,321 @@ func main() {
 		kvStore, err = kvstore.NewMeilisearchStore(
 			conf.Datastore.Meilisearch.Host,
 			conf.Datastore.Meilisearch.IndexName,
-			conf.Datastore.Meilisearch.MatchingStrategy)
+			conf.Datastore.Meilisearch.MatchingStrategy,
+			time.Duration(conf.Datastore.Meilisearch.TaskTimeout)*time.Second,
+			time.Duration(conf.Datastore.Meilisearch.TaskPollInterval)*time.Millisecond)
 		if err != nil {
 			logger.Fatalf("Failed to create Meilisearch store: %v", err)
 		}
 	} else {
 		logger.Fatalf("Unsupported datastore type: %s", conf.Datastore.Type)
 	}
-	defer kvStore.Close(ctx) //nolint
+	kvStore = metrics.NewKVStore(kvStore)
+	kvStore = redact.NewKVStore(kvStore, redactor)
+
+	var keyring *envelope.Keyring
+	if conf.Encryption.Enabled {
+		keyring, err = envelope.LoadKeyring(conf.Encryption.KeyringFile)
+		if err != nil {
+			logger.Fatalf("Failed to load encryption keyring: %v", err)
+		}
+		kvStore = envelope.NewKVStore(kvStore)
+	}
+
+	metrics.RegisterHeadRevision(etcdClient.CurrentRevision)
 
 	// Initialize ingestor
-	ing := ingestor.NewIngestor(kvStore, etcdClient)
+	ing := ingestor.NewIngestor(kvStore, etcdClient, conf.Ingestion)
 
-	// Start watching for etcd changes in background
-	go func() {
-		if err := ing.ChangeUpdater(ctx); err != nil {
-			logger.Fatalf("ChangeUpdater failed: %v", err)
+	// ingestion goroutines writing to the KV store, waited for on shutdown
+	var ingestion sync.WaitGroup
+	var elector etcd.Elector
+	if conf.HA.Enabled {
+		identity := conf.HA.Identity
+		if identity == "" {
+			if identity, err = os.Hostname(); err != nil {
+				logger.Fatalf("Failed to get the hostname: %v", err)
+			}
 		}
-		logger.Fatalf("ChangeUpdater stopped unexpectedly")
-	}()
+		elector, err = etcdClient.NewElector(conf.HA.ElectionPrefix, identity, conf.HA.LeaseTTL)
+		if err != nil {
+			logger.Fatalf("Failed to create leader elector: %v", err)
+		}
+
+		ingestion.Add(1)
+		go func() {
+			defer ingestion.Done()
+			for ctx.Err() == nil {
+				lead(ctx, ing, elector, identity, conf.Ingestion)
+			}
+		}()
+	} else {
+		startIngestion(ctx, ing, conf.Ingestion, &ingestion)
+	}
 
-	// Start etcd connection auditor in background
+	// Start etcd connection auditor in background, failed checks degrade the ingestion until a check succeeds
 	go func() {
-		auditorErrCh := etcdClient.StartAuditor(ctx)
-		if err := <-auditorErrCh; err != nil {
-			logger.Fatalf("Etcd connection auditor failed: %v", err)
+		for err := range etcdClient.StartAuditor(ctx) {
+			ing.ReportHealthCheck(err)
 		}
-		logger.Fatalf("Etcd connection auditor stopped unexpectedly")
 	}()
 
-	logger.Debugf("Initializing KV store with existing etcd data...")
-
-	// Initialize KV store with existing etcd data
-	if err := ing.InitKVStore(ctx); err != nil {
-		logger.Fatalf("Failed to initialize KV store: %v", err)
+	auditor := audit.NewNoopRecorder()
+	var auditQuerier audit.Querier
+	if conf.Audit.Enabled {
+		var sink audit.Sink
+		switch conf.Audit.Sink {
+		case lib.AUDIT_SINK_FILE:
+			fileSink, err := audit.NewFileSink(conf.Audit.File.Path, []byte(conf.Audit.HashSecret))
+			if err != nil {
+				logger.Fatalf("Failed to create audit file sink: %v", err)
+			}
+			if count, err := fileSink.Verify(ctx); err != nil {
+				logger.Errorf("Audit log %s failed verification after %d records: %v", conf.Audit.File.Path, count, err)
+			}
+			// Logged so that a copy of the head is kept outside of the host of the log
+			head := fileSink.Head()
+			logger.Infof("Audit log %s head: %d records, hash %s, signature %s", conf.Audit.File.Path, head.Records, head.Hash, head.Signature)
+			sink, auditQuerier = fileSink, fileSink
+		case lib.AUDIT_SINK_STDOUT:
+			sink = audit.NewStdoutSink()
+		case lib.AUDIT_SINK_WEBHOOK:
+			sink, err = audit.NewWebhookSink(conf.Audit.Webhook.URL, conf.Audit.Webhook.Headers,
+				time.Duration(conf.Audit.Webhook.Timeout)*time.Second)
+			if err != nil {
+				logger.Fatalf("Failed to create audit webhook sink: %v", err)
+			}
+		default:
+			logger.Fatalf("Unsupported audit sink: %s", conf.Audit.Sink)
+		}
+		defer sink.Close() //nolint
+		auditor = audit.NewRecorder(sink, []byte(conf.Audit.HashSecret))
 	}
 
 	// Initialize service layer
-	etcdFinderService := service.NewDefaultEtcdfinder(etcdClient, kvStore, ing)
+	etcdFinderService := service.NewDefaultEtcdfinder(etcdClient, kvStore, ing, authorizer, auditor)
+	if keyring != nil {
+		etcdFinderService = service.NewEncrypting(etcdFinderService, keyring, conf.Encryption.Prefixes)
+	}
 
-	// Initialize router with handlers
-	router, err := api.NewRouter(api.Handlers{
+	var changesHandler *v1.ChangesHandler
+	if conf.Approvals.Enabled {
+		if !conf.Auth.Enabled {
+			logger.Fatalf("Approvals require authentication to be enabled")
+		}
+		manager, err := changes.NewManager(etcdClient, conf.Approvals.Prefix, conf.Approvals.CriticalPatterns,
+			time.Duration(conf.Approvals.TTL)*time.Second, authorizer)
+		if err != nil {
+			logger.Fatalf("Failed to create change manager: %v", err)
+		}
+		if keyring != nil {
+			manager.EncryptWith(keyring, conf.Encryption.Prefixes)
+		}
+		// Approved changes are applied through the service without the gate
+		changesHandler = v1.NewChangesHandler(manager, etcdFinderService, redactor)
+		etcdFinderService = service.NewApprovalGate(etcdFinderService, manager)
+	}
+	etcdFinderService = service.NewRedacting(etcdFinderService, redactor)
+
+	var authenticator *auth.Authenticator
+	if conf.Auth.Enabled {
+		authenticator, err = auth.NewAuthenticator(ctx, conf.Auth)
+		if err != nil {
+			logger.Fatalf("Failed to create authenticator: %v", err)
+		}
+	} else {
+		logger.Warnf("Authentication is disabled, anyone reaching the server can read and modify keys")
+	}
+
+	handlers := api.Handlers{
 		EtcdFinderHandler: v1.NewEtcdfinderHandler(etcdFinderService),
-	})
+		KeysHandler:       v2.NewKeysHandler(etcdFinderService),
+		ChangesHandler:    changesHandler,
+		HealthHandler:     health.NewHealthHandler(ing, kvStore, conf.Server.ReadyMaxLag),
+	}
+	if auditQuerier != nil {
+		handlers.AuditHandler = v1.NewAuditHandler(auditQuerier, authorizer)
+	}
+	if authenticator != nil && authenticator.OIDCEnabled() {
+		handlers.LoginHandler = login.NewLoginHandler(authenticator, conf.Auth.OIDC.SecureCookie)
+	}
+
+	if conf.Server.ReadOnly {
+		logger.Infof("Read-only mode, keys cannot be modified through the API")
+	}
+
+	// Initialize router with handlers
+	router, err := api.NewRouter(api.RouterConfig{
+		Authenticator: authenticator,
+		ReadOnly:      conf.Server.ReadOnly,
+	}, handlers)
 	if err != nil {
 		logger.Fatalf("Failed to create router: %v", err)
 	}
 
+	server := &http.Server{
+		Addr:    ":" + conf.Server.Port,
+		Handler: router,
+	}
+	// Streams of watch events would hold the shutdown until the grace period otherwise
+	server.RegisterOnShutdown(ing.CloseSubscribers)
+
 	// Start the server
-	logger.Infof("Starting server on :%s", conf.Server.Port)
-	if err := router.Run(":" + conf.Server.Port); err != nil {
-		logger.Fatalf("Failed to start server: %v", err)
+	go func() {
+		logger.Infof("Starting server on :%s", conf.Server.Port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	// A second signal kills the process right away
+	stop()
+
+	gracePeriod := time.Duration(conf.Server.ShutdownGracePeriod) * time.Second
+	logger.Infof("Shutting down within %s", gracePeriod)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	// Stop accepting connections and wait for the requests in flight
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Errorf("Failed to drain the HTTP requests: %v", err)
+	}
+
+	// Wait for the ingestion to write the pending events and save its checkpoint
+	drained := make(chan struct{})
+	go func() {
+		ingestion.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-shutdownCtx.Done():
+		logger.Errorf("Ingestion did not stop within the grace period")
+	}
+
+	if elector != nil {
+		// Revoke the leadership so that another replica takes over without waiting for the lease to expire
+		if err := elector.Close(); err != nil {
+			logger.Errorf("Failed to resign the ingestion leadership: %v", err)
+		}
+	}
+	if err := kvStore.Close(shutdownCtx); err != nil {
+		logger.Errorf("Failed to close the KV store: %v", err)
+	}
+	if err := etcdClient.Close(); err != nil {
+		logger.Errorf("Failed to close the etcd client: %v", err)
+	}
+	logger.Infof("Shutdown complete")
+}
+
+// delay before campaigning again for the ingestion leadership after a failed election
+const campaignRetryDelay = 5 * time.Second
+
+// lead follows the ingestion leader until elected, then runs the ingestion until the leadership is lost or ctx is
+// done, and returns once the ingestion stopped
+func lead(ctx context.Context, ing ingestor.Base, elector etcd.Elector, identity string, conf config.IngestionConfig) {
+	// Serve the index kept up to date by the leader until elected
+	followCtx, stopFollowing := context.WithCancel(ctx)
+	followed := make(chan struct{})
+	go func() {
+		defer close(followed)
+		ing.Follow(followCtx) //nolint
+	}()
+
+	logger.Infof("Campaigning for the ingestion leadership as %s", identity)
+	lost, err := elector.Campaign(ctx)
+	stopFollowing()
+	<-followed
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		// etcd may be unreachable, campaign again once it had time to recover
+		logger.Errorf("Leader election failed, campaigning again in %s: %v", campaignRetryDelay, err)
+		select {
+		case <-time.After(campaignRetryDelay):
+		case <-ctx.Done():
+		}
+		return
+	}
+
+	logger.Infof("Elected ingestion leader as %s", identity)
+	metrics.Leader.Set(1)
+	defer metrics.Leader.Set(0)
+
+	leaderCtx, stepDown := context.WithCancel(ctx)
+	var ingestion sync.WaitGroup
+	startIngestion(leaderCtx, ing, conf, &ingestion)
+
+	// Another replica may be elected and apply the same events, stop the ingestion and rejoin as a follower
+	select {
+	case <-lost:
+		logger.Warnf("Lost the ingestion leadership, rejoining as a follower")
+	case <-ctx.Done():
+	}
+	stepDown()
+	ingestion.Wait()
+}
+
+// startIngestion runs the ingestion in background: the initial sync, unless resuming from the checkpoint persisted
+// in the KV store, followed by the watch, the reconciler and the checkpointer. They stop once ctx is done, the
+// watch writing its pending events and saving the checkpoint first.
+func startIngestion(ctx context.Context, ing ingestor.Base, conf config.IngestionConfig, ingestion *sync.WaitGroup) {
+	// Initialize KV store with existing etcd data, /readyz fails until it is searchable, then start watching for
+	// etcd changes from the revision it is up to date with. The watch is restarted until the outage budget is spent.
+	ingestion.Add(1)
+	go func() {
+		defer ingestion.Done()
+		if !initKVStore(ctx, ing) {
+			return
+		}
+		if err := ing.ChangeUpdater(ctx); ctx.Err() == nil {
+			logger.Fatalf("ChangeUpdater failed: %v", err)
+		}
+
+		// Save the revision of the last events written, the ingestion resumes right after it
+		if err := ing.Checkpoint(context.WithoutCancel(ctx)); err != nil {
+			logger.Errorf("Failed to save the checkpoint: %v", err)
+		}
+	}()
+
+	if conf.ReconcileInterval > 0 {
+		ingestion.Add(1)
+		go func() {
+			defer ingestion.Done()
+			if err := ing.Reconciler(ctx); ctx.Err() == nil {
+				logger.Errorf("Reconciler stopped: %v", err)
+			}
+		}()
+	}
+
+	ingestion.Add(1)
+	go func() {
+		defer ingestion.Done()
+		if err := ing.Checkpointer(ctx); ctx.Err() == nil {
+			logger.Errorf("Checkpointer stopped: %v", err)
+		}
+	}()
+}
+
+// initKVStore resumes from the checkpoint persisted in the KV store if one was saved, or syncs it from etcd otherwise.
+// It returns false if ctx was done first.
+func initKVStore(ctx context.Context, ing ingestor.Base) bool {
+	resumed, err := ing.Resume(ctx)
+	if err != nil && ctx.Err() == nil {
+		logger.Fatalf("Failed to load the checkpoint: %v", err)
+	}
+	if resumed || err != nil {
+		return resumed
+	}
+
+	logger.Debugf("Initializing KV store with existing etcd data...")
+	if err := ing.InitKVStore(ctx); err != nil {
+		if ctx.Err() == nil {
+			logger.Fatalf("Failed to initialize KV store: %v", err)
+		}
+		return false
 	}
+	return true
 }
@@ -0,0 +1,306 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/api/dto"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+)
+
+const (
+	defaultTimeout      = 30 * time.Second
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 100 * time.Millisecond
+	maxRetryBackoff     = 5 * time.Second
+)
+
+// Client is a typed client for the etcdfinder HTTP API
+type Client struct {
+	baseURL      string
+	httpClient   *http.Client
+	maxRetries   int           // number of retries on 5xx responses and transport errors
+	retryBackoff time.Duration // initial backoff, doubled after every retry
+	apiKey       string
+	bearerToken  string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the underlying http.Client
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets the maximum number of retries and the initial backoff between them
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryBackoff = backoff
+	}
+}
+
+// WithAPIKey authenticates requests with the API key, sent in the X-API-Key header
+func WithAPIKey(apiKey string) Option {
+	return func(c *Client) {
+		c.apiKey = apiKey
+	}
+}
+
+// WithBearerToken authenticates requests with the token, sent in the Authorization header
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.bearerToken = token
+	}
+}
+
+// New creates a new client for the etcdfinder server listening at baseURL
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base url %q: scheme must be http or https", baseURL)
+	}
+
+	c := &Client{
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		httpClient:   &http.Client{Timeout: defaultTimeout},
+		maxRetries:   defaultMaxRetries,
+		retryBackoff: defaultRetryBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.maxRetries < 0 {
+		return nil, fmt.Errorf("maxRetries must not be negative")
+	}
+
+	return c, nil
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID to send with requests made with it
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, lib.CtxRequestID, requestID)
+}
+
+// GetKey returns the value of the key
+func (c *Client) GetKey(ctx context.Context, key string) (string, error) {
+	var resp dto.GetKeyResponse
+	if err := c.do(ctx, http.MethodPost, "/v1/get-key", dto.GetKeyRequest{Key: key}, &resp); err != nil {
+		return "", err
+	}
+	return resp.Value, nil
+}
+
+// SearchKeys returns a single page of keys matching the search string.
+// A limit of 0 uses the server default page size.
+func (c *Client) SearchKeys(ctx context.Context, searchStr string, offset, limit int64) ([]string, error) {
+	var resp dto.SearchKeysResponse
+	req := dto.SearchKeysRequest{
+		SearchStr: searchStr,
+		Offset:    offset,
+		Limit:     limit,
+	}
+	if err := c.do(ctx, http.MethodPost, "/v1/search-keys", req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Keys, nil
+}
+
+// ListKeys returns a single page of the indexed keys from the cursor, 0 for the first page, along with the cursor
+// of the next page, 0 once every key was listed. A limit of 0 uses the server default page size.
+func (c *Client) ListKeys(ctx context.Context, cursor, limit int64) ([]string, int64, error) {
+	var resp dto.ListKeysResponse
+	if err := c.do(ctx, http.MethodPost, "/v1/list-keys", dto.ListKeysRequest{Cursor: cursor, Limit: limit}, &resp); err != nil {
+		return nil, 0, err
+	}
+	return resp.Keys, resp.NextCursor, nil
+}
+
+// PutKey creates or updates the key with the given value
+func (c *Client) PutKey(ctx context.Context, key string, value string) error {
+	var resp dto.PutKeyResponse
+	return c.do(ctx, http.MethodPut, "/v1/put-key", dto.PutKeyRequest{Key: key, Value: value}, &resp)
+}
+
+// DeleteKey deletes the key
+func (c *Client) DeleteKey(ctx context.Context, key string) error {
+	var resp dto.DeleteKeyResponse
+	return c.do(ctx, http.MethodDelete, "/v1/delete-key", dto.DeleteKeyRequest{Key: key}, &resp)
+}
+
+// GetIngestionDelay returns the apply latency of the last event indexed by the server, in milliseconds
+func (c *Client) GetIngestionDelay(ctx context.Context) (int, error) {
+	lag, err := c.GetIngestionLag(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int(lag.IngestionDelay), nil
+}
+
+// GetIngestionLag returns how far the search index is behind etcd, in revisions and apply latency
+func (c *Client) GetIngestionLag(ctx context.Context) (IngestionLag, error) {
+	var resp dto.GetIngestionDelayResponse
+	err := c.do(ctx, http.MethodGet, "/v1/ingestion-delay", nil, &resp)
+	return IngestionLag(resp), err
+}
+
+// GetReconciliation returns the drift between etcd and the search index repaired by the last reconciliation
+func (c *Client) GetReconciliation(ctx context.Context) (Reconciliation, error) {
+	var resp dto.GetReconciliationResponse
+	err := c.do(ctx, http.MethodGet, "/v1/reconciliation", nil, &resp)
+	return Reconciliation(resp), err
+}
+
+// ListChanges returns the changes of critical keys with the given status, or all of them if empty
+func (c *Client) ListChanges(ctx context.Context, status string) ([]Change, error) {
+	var resp dto.ListChangesResponse
+	path := "/v1/changes"
+	if status != "" {
+		path += "?status=" + url.QueryEscape(status)
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return newChanges(resp.Changes), nil
+}
+
+// GetChange returns the change with the given ID
+func (c *Client) GetChange(ctx context.Context, id string) (Change, error) {
+	var resp dto.Change
+	err := c.do(ctx, http.MethodGet, "/v1/changes/"+url.PathEscape(id), nil, &resp)
+	return Change(resp), err
+}
+
+// ApproveChange applies the change requested by another user
+func (c *Client) ApproveChange(ctx context.Context, id string) (Change, error) {
+	var resp dto.Change
+	err := c.do(ctx, http.MethodPost, "/v1/changes/"+url.PathEscape(id)+"/approve", nil, &resp)
+	return Change(resp), err
+}
+
+// RejectChange discards the change
+func (c *Client) RejectChange(ctx context.Context, id string) (Change, error) {
+	var resp dto.Change
+	err := c.do(ctx, http.MethodPost, "/v1/changes/"+url.PathEscape(id)+"/reject", nil, &resp)
+	return Change(resp), err
+}
+
+// do sends the request, retrying with exponential backoff on 5xx responses and transport errors,
+// and decodes a successful response into out
+func (c *Client) do(ctx context.Context, method, path string, in any, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	// Reuse the caller's request ID so that server logs can be correlated,
+	// otherwise generate one shared by all attempts of this call
+	requestID := lib.GetRequestID(ctx)
+	if requestID == "" {
+		requestID = lib.GenerateUUID()
+	}
+
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := c.doOnce(ctx, method, path, body, requestID, out)
+		if err == nil || attempt >= c.maxRetries || !isRetryable(ctx, err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
+
+func (c *Client) doOnce(ctx context.Context, method, path string, body []byte, requestID string, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(lib.HeaderRequestID, requestID)
+	c.authenticate(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w: %w", errTransport, err)
+	}
+	defer resp.Body.Close() //nolint
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// Changes of critical keys held for approval are accepted without being applied
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || resp.StatusCode == http.StatusAccepted {
+		return newError(resp, respBody)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+func newError(resp *http.Response, body []byte) *Error {
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get(lib.HeaderRequestID),
+	}
+
+	var errResp customerrors.ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err == nil {
+		apiErr.Code = errResp.Error.Code
+		apiErr.Message = errResp.Error.Display
+		apiErr.InternalError = errResp.Error.InternalError
+		apiErr.Details = errResp.Error.Details
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+
+	return apiErr
+}
+
+// authenticate sets the credentials the client was configured with on the request
+func (c *Client) authenticate(req *http.Request) {
+	if c.apiKey != "" {
+		req.Header.Set(lib.HeaderAPIKey, c.apiKey)
+	}
+	if c.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	}
+}
@@ -0,0 +1,400 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/api"
+	"github.com/etcdfinder/etcdfinder/internal/api/health"
+	v1 "github.com/etcdfinder/etcdfinder/internal/api/v1"
+	v2 "github.com/etcdfinder/etcdfinder/internal/api/v2"
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/ingestor"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+	"github.com/etcdfinder/etcdfinder/pkg/client"
+	"github.com/etcdfinder/etcdfinder/pkg/common"
+	"github.com/etcdfinder/etcdfinder/pkg/etcd"
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// criticalKey is held for approval by fakeService
+const criticalKey = "/critical"
+
+// fakeService serves keys from memory
+type fakeService struct {
+	mu       sync.Mutex
+	keys     map[string]string
+	searches atomic.Int32
+	listings atomic.Int32
+	watchCh  chan etcd.WatchEvent
+	prefix   string // prefix of the last watch
+}
+
+func newFakeService(keys map[string]string) *fakeService {
+	return &fakeService{keys: keys, watchCh: make(chan etcd.WatchEvent)}
+}
+
+func (f *fakeService) GetKey(ctx context.Context, key string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.keys[key]
+	if !ok {
+		return "", customerrors.ErrKeyNotFound
+	}
+	return value, nil
+}
+
+func (f *fakeService) GetKeyWithRevision(ctx context.Context, key string) (common.KV, error) {
+	value, err := f.GetKey(ctx, key)
+	return common.KV{Key: key, Value: value, ModRevision: 1}, err
+}
+
+func (f *fakeService) SearchKeys(ctx context.Context, searchStr string, offset, limit int64) ([]string, error) {
+	f.searches.Add(1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for key := range f.keys {
+		if strings.Contains(key, searchStr) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys[min(offset, int64(len(keys))):min(offset+limit, int64(len(keys)))], nil
+}
+
+// ListKeys pages through the sorted keys, the cursor being the index of the first key of the page plus one
+func (f *fakeService) ListKeys(ctx context.Context, cursor, limit int64) ([]string, int64, error) {
+	f.listings.Add(1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := slices.Sorted(maps.Keys(f.keys))
+	start := max(cursor-1, 0)
+	end := min(start+limit, int64(len(keys)))
+	if end == int64(len(keys)) {
+		return keys[start:end], 0, nil
+	}
+	return keys[start:end], end + 1, nil
+}
+
+func (f *fakeService) PutKey(ctx context.Context, key string, value string) (int64, error) {
+	if key == criticalKey {
+		return 0, customerrors.WithDetails(customerrors.ErrApprovalRequired, map[string]any{"change_id": "change-1"})
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys[key] = value
+	return 1, nil
+}
+
+func (f *fakeService) CompareAndPutKey(ctx context.Context, key string, value string, modRevision int64) (int64, error) {
+	return f.PutKey(ctx, key, value)
+}
+
+func (f *fakeService) DeleteKey(ctx context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.keys, key)
+	return nil
+}
+
+func (f *fakeService) CompareAndDeleteKey(ctx context.Context, key string, modRevision int64) error {
+	return f.DeleteKey(ctx, key)
+}
+
+func (f *fakeService) GetIngestionDelay(ctx context.Context) (ingestor.Delay, error) {
+	return ingestor.Delay{}, nil
+}
+
+func (f *fakeService) GetReconciliation(ctx context.Context) ingestor.Reconciliation {
+	return ingestor.Reconciliation{}
+}
+
+func (f *fakeService) WatchKeys(ctx context.Context, prefix string) <-chan etcd.WatchEvent {
+	f.mu.Lock()
+	f.prefix = prefix
+	f.mu.Unlock()
+	return f.watchCh
+}
+
+// newServer serves the router of the API over the service, failing the first failures requests with a 503
+func newServer(t *testing.T, svc *fakeService, failures int32) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	logger.L = &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}
+
+	router, err := api.NewRouter(api.RouterConfig{}, api.Handlers{
+		EtcdFinderHandler: v1.NewEtcdfinderHandler(svc),
+		KeysHandler:       v2.NewKeysHandler(svc),
+		HealthHandler:     health.NewHealthHandler(nil, nil, 0),
+	})
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		router.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func newClient(t *testing.T, server *httptest.Server, maxRetries int) *client.Client {
+	t.Helper()
+	c, err := client.New(server.URL, client.WithRetries(maxRetries, time.Millisecond))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return c
+}
+
+func TestRetriesServerErrors(t *testing.T) {
+	server, requests := newServer(t, newFakeService(map[string]string{"/a": "1"}), 2)
+	c := newClient(t, server, 3)
+
+	value, err := c.GetKey(context.Background(), "/a")
+	if err != nil {
+		t.Fatalf("GetKey: %v", err)
+	}
+	if value != "1" {
+		t.Errorf("value = %q, want %q", value, "1")
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("sent %d requests, want 3", got)
+	}
+}
+
+func TestRetriesGiveUp(t *testing.T) {
+	server, requests := newServer(t, newFakeService(map[string]string{}), 10)
+	c := newClient(t, server, 2)
+
+	_, err := c.GetKey(context.Background(), "/a")
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("GetKey error = %v, want a 503 *client.Error", err)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("sent %d requests, want 3", got)
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	server, requests := newServer(t, newFakeService(map[string]string{}), 0)
+	c := newClient(t, server, 3)
+
+	ctx := client.WithRequestID(context.Background(), "request-1")
+	_, err := c.GetKey(ctx, "/missing")
+	if !errors.Is(err, client.ErrKeyNotFound) {
+		t.Fatalf("GetKey error = %v, want ErrKeyNotFound", err)
+	}
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("GetKey error = %T, want *client.Error", err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Code != customerrors.ErrKeyNotFoundCode || apiErr.RequestID != "request-1" {
+		t.Errorf("error = %+v, want a 404 %s echoing request-1", apiErr, customerrors.ErrKeyNotFoundCode)
+	}
+	// 4xx responses are not retried
+	if got := requests.Load(); got != 1 {
+		t.Errorf("sent %d requests, want 1", got)
+	}
+
+	if _, err := c.SearchKeys(context.Background(), "", 0, lib.MAX_SEARCH_LIMIT+1); !errors.Is(err, client.ErrInvalidPagination) {
+		t.Errorf("SearchKeys error = %v, want ErrInvalidPagination", err)
+	}
+	if err := c.PutKey(context.Background(), "/a", ""); !errors.Is(err, client.ErrValueRequired) {
+		t.Errorf("PutKey error = %v, want ErrValueRequired", err)
+	}
+}
+
+func TestSearchKeysIter(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     int
+		pageSize int64
+		searches int32
+	}{
+		{name: "short last page", keys: 5, pageSize: 2, searches: 3},
+		{name: "empty last page", keys: 4, pageSize: 2, searches: 3},
+		{name: "single page", keys: 1, pageSize: 2, searches: 1},
+		{name: "no keys", keys: 0, pageSize: 2, searches: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := map[string]string{}
+			var want []string
+			for i := range tt.keys {
+				key := "/key/" + string(rune('a'+i))
+				keys[key] = "v"
+				want = append(want, key)
+			}
+			svc := newFakeService(keys)
+			server, _ := newServer(t, svc, 0)
+			c := newClient(t, server, 0)
+
+			var got []string
+			for key, err := range c.SearchKeysIter(context.Background(), "/key", tt.pageSize) {
+				if err != nil {
+					t.Fatalf("SearchKeysIter: %v", err)
+				}
+				got = append(got, key)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("keys = %v, want %v", got, want)
+			}
+			if searches := svc.searches.Load(); searches != tt.searches {
+				t.Errorf("sent %d searches, want %d", searches, tt.searches)
+			}
+		})
+	}
+}
+
+func TestSearchKeysIterStops(t *testing.T) {
+	svc := newFakeService(map[string]string{"/a": "1", "/b": "2", "/c": "3"})
+	server, _ := newServer(t, svc, 0)
+	c := newClient(t, server, 0)
+
+	for range c.SearchKeysIter(context.Background(), "", 2) {
+		break
+	}
+	if searches := svc.searches.Load(); searches != 1 {
+		t.Errorf("sent %d searches after breaking out of the first page, want 1", searches)
+	}
+
+	var err error
+	for _, err = range c.SearchKeysIter(context.Background(), "", lib.MAX_SEARCH_LIMIT+1) {
+	}
+	if !errors.Is(err, client.ErrInvalidPagination) {
+		t.Errorf("SearchKeysIter error = %v, want ErrInvalidPagination", err)
+	}
+}
+
+func TestListKeysIter(t *testing.T) {
+	// More keys than a search may return
+	keys := map[string]string{}
+	var want []string
+	for i := range 2*lib.MAX_SEARCH_LIMIT + 1 {
+		key := fmt.Sprintf("/key/%05d", i)
+		keys[key] = "v"
+		want = append(want, key)
+	}
+	svc := newFakeService(keys)
+	server, _ := newServer(t, svc, 0)
+	c := newClient(t, server, 0)
+
+	var got []string
+	for key, err := range c.ListKeysIter(context.Background(), lib.MAX_SEARCH_LIMIT) {
+		if err != nil {
+			t.Fatalf("ListKeysIter: %v", err)
+		}
+		got = append(got, key)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("listed %d keys, want %d", len(got), len(want))
+	}
+	if listings := svc.listings.Load(); listings != 3 {
+		t.Errorf("sent %d listings, want 3", listings)
+	}
+
+	var err error
+	for _, err = range c.ListKeysIter(context.Background(), lib.MAX_SEARCH_LIMIT+1) {
+	}
+	if !errors.Is(err, client.ErrInvalidPagination) {
+		t.Errorf("ListKeysIter error = %v, want ErrInvalidPagination", err)
+	}
+}
+
+func TestApprovalRequired(t *testing.T) {
+	svc := newFakeService(map[string]string{})
+	server, _ := newServer(t, svc, 0)
+	c := newClient(t, server, 3)
+
+	err := c.PutKey(context.Background(), criticalKey, "v")
+	if !errors.Is(err, client.ErrApprovalRequired) {
+		t.Fatalf("PutKey error = %v, want ErrApprovalRequired", err)
+	}
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusAccepted {
+		t.Errorf("PutKey error = %v, want a 202", err)
+	}
+	if id := client.ChangeID(err); id != "change-1" {
+		t.Errorf("ChangeID = %q, want %q", id, "change-1")
+	}
+	if _, err := svc.GetKey(context.Background(), criticalKey); err == nil {
+		t.Errorf("the change awaiting approval was applied")
+	}
+}
+
+func TestWatchKeys(t *testing.T) {
+	svc := newFakeService(map[string]string{})
+	server, _ := newServer(t, svc, 0)
+	c := newClient(t, server, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	eventCh, errCh := c.WatchKeys(ctx, "/app")
+
+	sent := []etcd.WatchEvent{
+		{Type: "PUT", Key: "/app/a", Value: "line 1\nline 2", Revision: 2},
+		{Type: "DELETE", Key: "/app/b", Revision: 3},
+	}
+	go func() {
+		for _, event := range sent {
+			svc.watchCh <- event
+		}
+		// Ends the stream
+		close(svc.watchCh)
+	}()
+
+	var got []client.WatchEvent
+	for event := range eventCh {
+		got = append(got, event)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("WatchKeys error = %v", err)
+	}
+
+	if len(got) != len(sent) {
+		t.Fatalf("received %d events, want %d", len(got), len(sent))
+	}
+	for i, event := range got {
+		want := sent[i]
+		if event.Type != want.Type || event.Key != want.Key || event.Value != want.Value || event.Revision != want.Revision {
+			t.Errorf("event %d = %+v, want %+v", i, event, want)
+		}
+	}
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if svc.prefix != "/app" {
+		t.Errorf("watched prefix %q, want %q", svc.prefix, "/app")
+	}
+}
+
+func TestWatchKeysError(t *testing.T) {
+	server, _ := newServer(t, newFakeService(map[string]string{}), 1)
+	c := newClient(t, server, 0)
+
+	eventCh, errCh := c.WatchKeys(context.Background(), "")
+	for range eventCh {
+		t.Errorf("received an event from a failed stream")
+	}
+	var apiErr *client.Error
+	if err := <-errCh; !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("WatchKeys error = %v, want a 503 *client.Error", err)
+	}
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+)
+
+// Errors returned by the server, usable with errors.Is against any error returned by the Client
+var (
+	ErrKeyRequired           = customerrors.ErrKeyRequired
+	ErrValueRequired         = customerrors.ErrValueRequired
+	ErrMalformedSearchString = customerrors.ErrMalformedSearchString
+	ErrKeyNotFound           = customerrors.ErrKeyNotFound
+	ErrKeyNotPut             = customerrors.ErrKeyNotPut
+	ErrKeyNotDeleted         = customerrors.ErrKeyNotDeleted
+	ErrInvalidPagination     = customerrors.ErrInvalidPagination
+	ErrUnauthenticated       = customerrors.ErrUnauthenticated
+	ErrForbidden             = customerrors.ErrForbidden
+	ErrKeyProtected          = customerrors.ErrKeyProtected
+	ErrApprovalRequired      = customerrors.ErrApprovalRequired
+	ErrChangeNotFound        = customerrors.ErrChangeNotFound
+	ErrChangeNotPending      = customerrors.ErrChangeNotPending
+	ErrChangeStale           = customerrors.ErrChangeStale
+	ErrValueRedacted         = customerrors.ErrValueRedacted
+)
+
+// errTransport marks errors raised before a response was received
+var errTransport = errors.New("transport error")
+
+// Error is returned for every non-2xx response of the server
+type Error struct {
+	StatusCode    int            // HTTP status code of the response
+	Code          string         // Machine-readable error code, empty if the server did not send one
+	Message       string         // Human-readable error message
+	InternalError string         // Underlying server error
+	Details       map[string]any // Additional error details
+	RequestID     string         // Request ID echoed by the server
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("etcdfinder: %d %s (request_id=%s)", e.StatusCode, e.Message, e.RequestID)
+	}
+	return fmt.Sprintf("etcdfinder: %s: %s (request_id=%s)", e.Code, e.Message, e.RequestID)
+}
+
+// Unwrap maps the error code back to the matching sentinel error
+func (e *Error) Unwrap() error {
+	return customerrors.ErrFromCode(e.Code)
+}
+
+// ChangeID returns the ID of the change awaiting approval when err is ErrApprovalRequired, or ""
+func ChangeID(err error) string {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		return ""
+	}
+	id, _ := apiErr.Details["change_id"].(string)
+	return id
+}
+
+// isRetryable reports whether the request that failed with err may be sent again
+func isRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	// Transport errors are retryable, encoding and decoding errors are not
+	return errors.Is(err, errTransport)
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"iter"
+
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+)
+
+// SearchKeysIter iterates over all keys matching the search string, fetching pageSize keys per request.
+// A pageSize of 0 uses the server default page size. Iteration stops at the first error, which is yielded.
+func (c *Client) SearchKeysIter(ctx context.Context, searchStr string, pageSize int64) iter.Seq2[string, error] {
+	if pageSize == 0 {
+		pageSize = lib.DEFAULT_SEARCH_LIMIT
+	}
+
+	return func(yield func(string, error) bool) {
+		var offset int64
+		for {
+			keys, err := c.SearchKeys(ctx, searchStr, offset, pageSize)
+			if err != nil {
+				yield("", err)
+				return
+			}
+
+			for _, key := range keys {
+				if !yield(key, nil) {
+					return
+				}
+			}
+
+			// A short page means there is nothing left to fetch
+			if int64(len(keys)) < pageSize {
+				return
+			}
+			offset += int64(len(keys))
+		}
+	}
+}
+
+// ListKeysIter iterates over all indexed keys, fetching about pageSize keys per request. Unlike SearchKeysIter,
+// it is not capped by the maximum number of hits of the search index. A pageSize of 0 uses the server default
+// page size. Iteration stops at the first error, which is yielded.
+func (c *Client) ListKeysIter(ctx context.Context, pageSize int64) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		var cursor int64
+		for {
+			keys, next, err := c.ListKeys(ctx, cursor, pageSize)
+			if err != nil {
+				yield("", err)
+				return
+			}
+
+			for _, key := range keys {
+				if !yield(key, nil) {
+					return
+				}
+			}
+
+			if next == 0 {
+				return
+			}
+			cursor = next
+		}
+	}
+}
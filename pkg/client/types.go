@@ -0,0 +1,76 @@
+package client
+
+import (
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/internal/api/dto"
+)
+
+// The results of the Client mirror the responses of the server. They are converted from the API types, so that
+// adding a field to one but not the other fails to compile.
+
+// IngestionLag reports how far the search index is behind etcd
+type IngestionLag struct {
+	IngestionDelay  int64      `json:"ingestion_delay"` // apply latency of the last event, in milliseconds
+	AppliedRevision int64      `json:"applied_revision"`
+	HeadRevision    int64      `json:"head_revision"`
+	RevisionLag     int64      `json:"revision_lag"`
+	P50Latency      int64      `json:"p50_latency"` // in milliseconds
+	P99Latency      int64      `json:"p99_latency"` // in milliseconds
+	LastAppliedAt   *time.Time `json:"last_applied_at,omitempty"`
+	SearchReady     bool       `json:"search_ready"` // whether the initial sync is fully indexed
+	Degraded        bool       `json:"degraded"`     // whether the watch or the etcd connection checks are failing
+	DegradedSince   *time.Time `json:"degraded_since,omitempty"`
+	DegradedReason  string     `json:"degraded_reason,omitempty"`
+}
+
+// Reconciliation reports the drift between etcd and the search index repaired by the last reconciliation
+type Reconciliation struct {
+	Enabled      bool       `json:"enabled"`
+	Interval     int64      `json:"interval"` // in seconds
+	Runs         int64      `json:"runs"`
+	LastRunAt    *time.Time `json:"last_run_at,omitempty"`
+	LastDuration int64      `json:"last_duration"`      // in milliseconds
+	Revision     int64      `json:"revision,omitempty"` // revision etcd was read at by the last run
+	Added        int        `json:"added"`
+	Updated      int        `json:"updated"`
+	Deleted      int        `json:"deleted"`
+	Skipped      int        `json:"skipped"` // keys the watch changed during the last run, left to it
+	Error        string     `json:"error,omitempty"`
+}
+
+// Change is a put or delete of a critical key awaiting approval, or decided
+type Change struct {
+	ID           string     `json:"id"`
+	Operation    string     `json:"operation"` // put or delete
+	Key          string     `json:"key"`
+	Value        string     `json:"value,omitempty"` // new value of puts
+	OldValue     string     `json:"old_value,omitempty"`
+	BaseRevision int64      `json:"base_revision"` // mod revision the change applies to, 0 if the key did not exist
+	Diff         string     `json:"diff"`
+	Status       string     `json:"status"`
+	RequestedBy  string     `json:"requested_by"`
+	RequestID    string     `json:"request_id,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	DecidedBy    string     `json:"decided_by,omitempty"`
+	DecidedAt    *time.Time `json:"decided_at,omitempty"`
+	Revision     int64      `json:"revision,omitempty"` // revision the change was applied at, unknown for deletes
+}
+
+// WatchEvent is a change applied to a key
+type WatchEvent struct {
+	Type     string `json:"type"`
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	Revision int64  `json:"revision,omitempty"` // etcd revision of the change
+}
+
+// newChanges converts the changes of a response
+func newChanges(changes []dto.Change) []Change {
+	converted := make([]Change, len(changes))
+	for i, change := range changes {
+		converted[i] = Change(change)
+	}
+	return converted
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/etcdfinder/etcdfinder/internal/api/dto"
+	"github.com/etcdfinder/etcdfinder/internal/lib"
+)
+
+// maximum size of a single server-sent event line, etcd values are limited to 1.5MiB by default
+const maxWatchLineSize = 4 * 1024 * 1024
+
+// WatchKeys streams the changes of keys under prefix until ctx is done.
+// The event channel is closed when the stream ends, a non-nil error is sent first if it ended abnormally.
+func (c *Client) WatchKeys(ctx context.Context, prefix string) (<-chan WatchEvent, <-chan error) {
+	eventCh := make(chan WatchEvent)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(eventCh)
+		defer close(errCh)
+
+		if err := c.watch(ctx, prefix, eventCh); err != nil && ctx.Err() == nil {
+			errCh <- err
+		}
+	}()
+
+	return eventCh, errCh
+}
+
+func (c *Client) watch(ctx context.Context, prefix string, eventCh chan<- WatchEvent) error {
+	query := url.Values{}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/v1/watch-keys?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	requestID := lib.GetRequestID(ctx)
+	if requestID == "" {
+		requestID = lib.GenerateUUID()
+	}
+	req.Header.Set(lib.HeaderRequestID, requestID)
+	c.authenticate(req)
+
+	// The stream is long-lived, so the overall client timeout must not apply to it
+	streamClient := *c.httpClient
+	streamClient.Timeout = 0
+
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w: %w", errTransport, err)
+	}
+	defer resp.Body.Close() //nolint
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(resp.Body)
+		return newError(resp, body)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxWatchLineSize)
+
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// A blank line dispatches the event
+			if len(data) == 0 {
+				continue
+			}
+			var event dto.WatchEvent
+			if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &event); err != nil {
+				return fmt.Errorf("failed to decode watch event: %w", err)
+			}
+			data = data[:0]
+
+			select {
+			case eventCh <- WatchEvent(event):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		default:
+			// Comments, event names and unknown fields carry no payload
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("watch stream failed: %w", err)
+	}
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package common
 
 type KV struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	ModRevision int64  `json:"mod_revision,omitempty"` // revision of the last modification, 0 if unknown
+	ValueHash   string `json:"value_hash,omitempty"`   // hash of the value in etcd, as stored by the KV store
 }
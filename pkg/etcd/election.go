@@ -0,0 +1,104 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// ErrElectionUnsupported is returned by the clients of etcd versions without leases
+var ErrElectionUnsupported = errors.New("leader election requires etcd v3")
+
+// Elector elects a single leader among the processes campaigning on the same prefix
+type Elector interface {
+	// blocks until elected or ctx is done, returns a channel closed once the leadership is lost.
+	// Campaigning again after the leadership was lost opens a new session.
+	Campaign(ctx context.Context) (<-chan struct{}, error)
+	// gives up the leadership so that another process is elected right away
+	Resign(ctx context.Context) error
+	// closes the session, revoking the leadership if held
+	Close() error
+}
+
+// elector campaigns with a session whose lease expires ttl seconds after the process stops refreshing it
+type elector struct {
+	client   *clientv3.Client
+	prefix   string
+	identity string
+	ttl      int
+
+	mu       sync.Mutex
+	session  *concurrency.Session
+	election *concurrency.Election
+}
+
+// NewElector creates an elector campaigning as identity on the prefix
+func (c *Client) NewElector(prefix, identity string, ttl int64) (Elector, error) {
+	e := &elector{
+		client:   c.client,
+		prefix:   prefix,
+		identity: identity,
+		ttl:      int(ttl),
+	}
+	if _, _, err := e.current(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// current returns the election of the session, replaced by a new session once the lease of the previous one expired
+func (e *elector) current() (*concurrency.Election, *concurrency.Session, error) {
+	e.mu.Lock()
+	session, election := e.session, e.election
+	e.mu.Unlock()
+	if session != nil {
+		select {
+		case <-session.Done():
+		default:
+			return election, session, nil
+		}
+	}
+
+	session, err := concurrency.NewSession(e.client, concurrency.WithTTL(e.ttl))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create election session: %w", err)
+	}
+	election = concurrency.NewElection(session, e.prefix)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.session, e.election = session, election
+	return election, session, nil
+}
+
+func (e *elector) Campaign(ctx context.Context) (<-chan struct{}, error) {
+	election, session, err := e.current()
+	if err != nil {
+		return nil, err
+	}
+	if err := election.Campaign(ctx, e.identity); err != nil {
+		return nil, fmt.Errorf("failed to campaign: %w", err)
+	}
+	// The leader key is attached to the lease of the session, it is deleted once the lease expires
+	return session.Done(), nil
+}
+
+func (e *elector) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	election := e.election
+	e.mu.Unlock()
+	if err := election.Resign(ctx); err != nil {
+		return fmt.Errorf("failed to resign: %w", err)
+	}
+	return nil
+}
+
+func (e *elector) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.session.Close()
+}
@@ -2,23 +2,61 @@ package etcd
 
 import (
 	"context"
+	"errors"
 
 	"github.com/etcdfinder/etcdfinder/pkg/common"
 )
 
+// ErrCompacted is returned by watches and reads of a revision etcd already compacted
+var ErrCompacted = errors.New("etcd revision compacted")
+
 type BaseClient interface {
 	// returns the value of the key and error if any
 	Get(ctx context.Context, key string) (string, error)
-	// returns the key that was put and error if any
-	Put(ctx context.Context, key string, value string) (string, error)
-	// returns the key that was deleted and error if any
-	Delete(ctx context.Context, key string) (string, error)
-	// returns the channel of watch events and error channel
-	Watch(ctx context.Context) (<-chan WatchEvent, <-chan error)
-	// returns the list of keys and the next key to be fetched and error if any
-	GetKeysWithPagination(ctx context.Context, fromKey string) ([]common.KV, string, error)
-	// returns the error channel
+	// returns the key with its value and mod revision and error if any
+	GetKV(ctx context.Context, key string) (common.KV, error)
+	// returns the put, whose revision is the new mod revision of the key, and error if any
+	Put(ctx context.Context, key string, value string) (Mutation, error)
+	// puts the key only if its mod revision matches, 0 meaning the key must not exist
+	// returns the put, whose revision is the new mod revision of the key, and error if any
+	CompareAndPut(ctx context.Context, key string, value string, modRevision int64) (Mutation, error)
+	// returns the delete and error if any
+	Delete(ctx context.Context, key string) (Mutation, error)
+	// deletes the key only if its mod revision matches, which must be positive
+	// returns the delete and error if any
+	CompareAndDelete(ctx context.Context, key string, modRevision int64) (Mutation, error)
+	// returns the channel of watch events starting at fromRevision, or at the current revision if 0, and error channel
+	Watch(ctx context.Context, fromRevision int64) (<-chan WatchEvent, <-chan error)
+	// returns the list of keys at the revision, or at the current revision if 0, the next key to be fetched,
+	// the revision the keys were read at and error if any. Following pages are read at the returned revision.
+	GetKeysWithPagination(ctx context.Context, fromKey string, revision int64) ([]common.KV, string, int64, error)
+	// splits the watched keys into at most n ranges to be read concurrently
+	// returns the ranges, the revision to read them at and error if any
+	PartitionKeys(ctx context.Context, n int) ([]KeyRange, int64, error)
+	// returns the list of keys of the range at the revision and the next key to be fetched and error if any
+	GetRangeWithPagination(ctx context.Context, keyRange KeyRange, fromKey string, revision int64) ([]common.KV, string, error)
+	// returns the channel receiving the result of every connection check, nil if it succeeded, until ctx is done
 	StartAuditor(ctx context.Context) <-chan error
+	// returns the keys under the prefix, which need not be under the root prefix, sorted by key, and error if any
+	GetPrefix(ctx context.Context, prefix string) ([]common.KV, error)
+	// returns the current revision of etcd and error if any
+	CurrentRevision(ctx context.Context) (int64, error)
+	// returns an elector campaigning as identity on the prefix, whose leadership expires ttl seconds after
+	// the process stops, and error if any
+	NewElector(prefix, identity string, ttl int64) (Elector, error)
 	// closes the client
 	Close() error
-}
\ No newline at end of file
+}
+
+// Mutation is a put or delete committed to etcd, along with the key it replaced
+type Mutation struct {
+	Key      string
+	Revision int64      // revision of etcd the mutation was committed at
+	Prev     *common.KV // the key before the mutation, read in the same operation, nil if it did not exist
+}
+
+// KeyRange is the range of keys from Start included to End excluded, an empty End meaning no upper bound
+type KeyRange struct {
+	Start string
+	End   string
+}
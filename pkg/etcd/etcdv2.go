@@ -2,11 +2,14 @@ package etcd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/metrics"
 	"github.com/etcdfinder/etcdfinder/pkg/common"
 	"github.com/etcdfinder/etcdfinder/pkg/logger"
 	etcdv2 "go.etcd.io/etcd/client/v2"
@@ -20,8 +23,18 @@ type ClientV2 struct {
 	numGetKeysLimit       int64  // number of keys to be returned in a single GetKeysWithPagination call
 	EtcdAuditPeriod       time.Duration
 	maxWatchRetries       int64    // maximum number of consecutive failures on the same ModRevision
-	ExpectedModIndex      uint64   // expected modified index of the etcd keys
 	endpoints             []string // endpoints for health checks
+
+	// tree of keys read by the first page of the current pagination, serving the following pages
+	// since etcd v2 cannot read past indexes
+	snapshotMu sync.Mutex
+	snapshot   *treeSnapshot
+}
+
+// treeSnapshot is the tree of keys under the root prefix at an index, root is nil if there were no keys
+type treeSnapshot struct {
+	root  *etcdv2.Node
+	index int64
 }
 
 // NewClientV2 creates a new etcd v2 client
@@ -53,7 +66,6 @@ func NewClientV2(
 		numGetKeysLimit:       numGetKeysLimit,
 		EtcdAuditPeriod:       time.Duration(etcdAuditPeriod) * time.Second,
 		maxWatchRetries:       maxWatchRetries,
-		ExpectedModIndex:      0,
 		endpoints:             endpoints,
 	}, nil
 }
@@ -74,34 +86,122 @@ func (c *ClientV2) Get(ctx context.Context, key string) (string, error) {
 	return resp.Node.Value, nil
 }
 
-func (c *ClientV2) Put(ctx context.Context, key string, value string) (string, error) {
+func (c *ClientV2) GetKV(ctx context.Context, key string) (common.KV, error) {
+	resp, err := c.client.Get(ctx, key, nil)
+	if err != nil {
+		if etcdv2.IsKeyNotFound(err) {
+			return common.KV{}, customerrors.ErrKeyNotFound
+		}
+		return common.KV{}, fmt.Errorf("failed to get key: %w", err)
+	}
+
+	if resp.Node == nil {
+		return common.KV{}, customerrors.ErrKeyNotFound
+	}
+
+	return common.KV{
+		Key:         resp.Node.Key,
+		Value:       resp.Node.Value,
+		ModRevision: int64(resp.Node.ModifiedIndex),
+	}, nil
+}
+
+func (c *ClientV2) Put(ctx context.Context, key string, value string) (Mutation, error) {
 	resp, err := c.client.Set(ctx, key, value, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to put key: %w", err)
+		return Mutation{}, fmt.Errorf("failed to put key: %w", err)
 	}
 	if resp.Node == nil {
-		return "", customerrors.ErrKeyNotPut
+		return Mutation{}, customerrors.ErrKeyNotPut
 	}
-	return resp.Node.Key, nil
+	return mutationV2(resp), nil
 }
 
-func (c *ClientV2) Delete(ctx context.Context, key string) (string, error) {
+func (c *ClientV2) CompareAndPut(ctx context.Context, key string, value string, modRevision int64) (Mutation, error) {
+	opts := &etcdv2.SetOptions{}
+	if modRevision == 0 {
+		opts.PrevExist = etcdv2.PrevNoExist
+	} else {
+		opts.PrevIndex = uint64(modRevision)
+	}
+
+	resp, err := c.client.Set(ctx, key, value, opts)
+	if err != nil {
+		if isPreconditionFailed(err) {
+			return Mutation{}, customerrors.ErrPreconditionFailed
+		}
+		return Mutation{}, fmt.Errorf("failed to put key: %w", err)
+	}
+	if resp.Node == nil {
+		return Mutation{}, customerrors.ErrKeyNotPut
+	}
+	return mutationV2(resp), nil
+}
+
+func (c *ClientV2) Delete(ctx context.Context, key string) (Mutation, error) {
 	resp, err := c.client.Delete(ctx, key, &etcdv2.DeleteOptions{})
 	if err != nil {
-		if etcdv2.IsKeyNotFound(err) {
-			return "", nil // Already deleted
+		var etcdErr etcdv2.Error
+		if errors.As(err, &etcdErr) && etcdErr.Code == etcdv2.ErrorCodeKeyNotFound {
+			// Already deleted
+			return Mutation{Key: key, Revision: int64(etcdErr.Index)}, nil
+		}
+		return Mutation{}, fmt.Errorf("failed to delete key: %w", err)
+	}
+	if resp.Node == nil {
+		return Mutation{}, customerrors.ErrKeyNotDeleted
+	}
+	return mutationV2(resp), nil
+}
+
+func (c *ClientV2) CompareAndDelete(ctx context.Context, key string, modRevision int64) (Mutation, error) {
+	if modRevision <= 0 {
+		return Mutation{}, customerrors.ErrPreconditionFailed
+	}
+
+	resp, err := c.client.Delete(ctx, key, &etcdv2.DeleteOptions{PrevIndex: uint64(modRevision)})
+	if err != nil {
+		if isPreconditionFailed(err) {
+			return Mutation{}, customerrors.ErrPreconditionFailed
 		}
-		return "", fmt.Errorf("failed to delete key: %w", err)
+		return Mutation{}, fmt.Errorf("failed to delete key: %w", err)
 	}
 	if resp.Node == nil {
-		return "", customerrors.ErrKeyNotDeleted
+		return Mutation{}, customerrors.ErrKeyNotDeleted
 	}
-	return resp.Node.Key, nil
+	return mutationV2(resp), nil
 }
 
-// Watch watches for changes on keys
+// mutationV2 converts the response of a set or delete, the modified index of the node being the index
+// the action was committed at
+func mutationV2(resp *etcdv2.Response) Mutation {
+	mutation := Mutation{Key: resp.Node.Key, Revision: int64(resp.Node.ModifiedIndex)}
+	if resp.PrevNode != nil {
+		mutation.Prev = &common.KV{
+			Key:         resp.PrevNode.Key,
+			Value:       resp.PrevNode.Value,
+			ModRevision: int64(resp.PrevNode.ModifiedIndex),
+		}
+	}
+	return mutation
+}
+
+// isPreconditionFailed reports whether a compare-and-swap failed because the key did not match
+func isPreconditionFailed(err error) bool {
+	var etcdErr etcdv2.Error
+	if !errors.As(err, &etcdErr) {
+		return false
+	}
+	switch etcdErr.Code {
+	case etcdv2.ErrorCodeTestFailed, etcdv2.ErrorCodeNodeExist, etcdv2.ErrorCodeKeyNotFound:
+		return true
+	}
+	return false
+}
+
+// Watch watches for changes on keys, starting at fromRevision if positive
 // Returns a channel of WatchEvents and an error channel
-func (c *ClientV2) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error) {
+func (c *ClientV2) Watch(ctx context.Context, fromRevision int64) (<-chan WatchEvent, <-chan error) {
 	eventCh := make(chan WatchEvent, c.watchEventChannelSize)
 	errCh := make(chan error, 1)
 
@@ -110,6 +210,8 @@ func (c *ClientV2) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error)
 		defer close(errCh)
 		watchIndexDiscrepancy := false
 		var consecutiveFailureCount int64
+		// expected modified index of the next event, 0 until the first one
+		var expectedModIndex uint64
 
 		for {
 			var watcher etcdv2.Watcher
@@ -117,8 +219,10 @@ func (c *ClientV2) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error)
 				Recursive: true,
 			}
 
-			if watchIndexDiscrepancy && c.ExpectedModIndex > 0 {
-				watchOpts.AfterIndex = c.ExpectedModIndex - 1
+			if watchIndexDiscrepancy && expectedModIndex > 0 {
+				watchOpts.AfterIndex = expectedModIndex - 1
+			} else if fromRevision > 0 {
+				watchOpts.AfterIndex = uint64(fromRevision) - 1
 			}
 
 			watcher = c.client.Watcher(c.rootPrefixEtcd, watchOpts)
@@ -129,6 +233,12 @@ func (c *ClientV2) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error)
 					if ctx.Err() != nil {
 						return // Context cancelled
 					}
+					// etcd v2 only keeps the last 1000 events
+					var etcdErr etcdv2.Error
+					if errors.As(err, &etcdErr) && etcdErr.Code == etcdv2.ErrorCodeEventIndexCleared {
+						errCh <- fmt.Errorf("watch error: %w: %s", ErrCompacted, etcdErr.Cause)
+						return
+					}
 					errCh <- fmt.Errorf("watch error: %w", err)
 					return
 				}
@@ -138,18 +248,19 @@ func (c *ClientV2) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error)
 				}
 
 				// If this is the first event, set the expected modindex
-				if c.ExpectedModIndex == 0 {
-					c.ExpectedModIndex = resp.Node.ModifiedIndex
+				if expectedModIndex == 0 {
+					expectedModIndex = resp.Node.ModifiedIndex
 				}
 
 				// Check if the modindex is not equal to the expected modindex
-				if resp.Node.ModifiedIndex != c.ExpectedModIndex {
+				if resp.Node.ModifiedIndex != expectedModIndex {
 					consecutiveFailureCount++
-					logger.Warnf("ModIndex mismatch: Consecutive failure #%d on ModIndex %d", consecutiveFailureCount, c.ExpectedModIndex)
+					metrics.WatchRevisionGapRetries.Inc()
+					logger.Warnf("ModIndex mismatch: Consecutive failure #%d on ModIndex %d", consecutiveFailureCount, expectedModIndex)
 
 					// If we've exceeded max retries on the same index, fail fast
 					if consecutiveFailureCount >= c.maxWatchRetries {
-						errCh <- fmt.Errorf("exceeded max watch retries (%d) on ModIndex %d - failing fast to prevent infinite loop", c.maxWatchRetries, c.ExpectedModIndex)
+						errCh <- fmt.Errorf("exceeded max watch retries (%d) on ModIndex %d - failing fast to prevent infinite loop", c.maxWatchRetries, expectedModIndex)
 						return
 					}
 					watchIndexDiscrepancy = true
@@ -158,10 +269,12 @@ func (c *ClientV2) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error)
 
 				// Successfully processed an event, reset failure counter
 				consecutiveFailureCount = 0
-				c.ExpectedModIndex = resp.Node.ModifiedIndex + 1
+				expectedModIndex = resp.Node.ModifiedIndex + 1
 
 				watchEvent := WatchEvent{
-					Key: resp.Node.Key,
+					Key:        resp.Node.Key,
+					Revision:   int64(resp.Node.ModifiedIndex),
+					ObservedAt: time.Now(),
 				}
 
 				switch resp.Action {
@@ -193,22 +306,12 @@ func (c *ClientV2) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error)
 	return eventCh, errCh
 }
 
-// GetKeysWithPagination retrieves keys with pagination support
-func (c *ClientV2) GetKeysWithPagination(ctx context.Context, fromKey string) ([]common.KV, string, error) {
-	// Always fetch from root to ensure we can traverse the tree
-	getKey := c.rootPrefixEtcd
-
-	opts := &etcdv2.GetOptions{
-		Recursive: true,
-		Sort:      true,
-	}
-
-	resp, err := c.client.Get(ctx, getKey, opts)
+// GetKeysWithPagination retrieves keys with pagination support. The first page reads the whole tree of keys,
+// which the following pages at its index are served from until the pagination ends.
+func (c *ClientV2) GetKeysWithPagination(ctx context.Context, fromKey string, revision int64) ([]common.KV, string, int64, error) {
+	snapshot, err := c.getSnapshot(ctx, revision)
 	if err != nil {
-		if etcdv2.IsKeyNotFound(err) {
-			return []common.KV{}, "", nil
-		}
-		return nil, "", fmt.Errorf("failed to get keys: %w", err)
+		return nil, "", 0, err
 	}
 
 	keys := make([]common.KV, 0)
@@ -241,8 +344,9 @@ func (c *ClientV2) GetKeysWithPagination(ctx context.Context, fromKey string) ([
 
 			// Not skipping, add key
 			keys = append(keys, common.KV{
-				Key:   node.Key,
-				Value: node.Value,
+				Key:         node.Key,
+				Value:       node.Value,
+				ModRevision: int64(node.ModifiedIndex),
 			})
 			return
 		}
@@ -258,59 +362,119 @@ func (c *ClientV2) GetKeysWithPagination(ctx context.Context, fromKey string) ([
 		}
 	}
 
-	collectKeys(resp.Node)
+	collectKeys(snapshot.root)
 
 	if len(keys) == 0 {
-		return keys, "", nil
+		c.releaseSnapshot(snapshot)
+		return keys, "", snapshot.index, nil
 	}
 
 	// If result is full, return nextKey.
 	// Note: If we reached exactly end of list and it's full, we still return nextKey.
 	// The next call will return empty, which ends pagination.
-	return keys, keys[len(keys)-1].Key, nil
+	return keys, keys[len(keys)-1].Key, snapshot.index, nil
+}
+
+// getSnapshot reads the tree of keys under the root prefix if index is 0, or returns the tree read at the index
+func (c *ClientV2) getSnapshot(ctx context.Context, index int64) (*treeSnapshot, error) {
+	c.snapshotMu.Lock()
+	defer c.snapshotMu.Unlock()
+
+	if index != 0 {
+		if c.snapshot == nil || c.snapshot.index != index {
+			return nil, fmt.Errorf("etcd v2 cannot read keys at past index %d, restart the pagination", index)
+		}
+		return c.snapshot, nil
+	}
+
+	// Always fetch from root to ensure we can traverse the tree
+	resp, err := c.client.Get(ctx, c.rootPrefixEtcd, &etcdv2.GetOptions{
+		Recursive: true,
+		Sort:      true,
+	})
+	if err != nil {
+		var etcdErr etcdv2.Error
+		if errors.As(err, &etcdErr) && etcdErr.Code == etcdv2.ErrorCodeKeyNotFound {
+			c.snapshot = &treeSnapshot{index: int64(etcdErr.Index)}
+			return c.snapshot, nil
+		}
+		return nil, fmt.Errorf("failed to get keys: %w", err)
+	}
+
+	c.snapshot = &treeSnapshot{root: resp.Node, index: int64(resp.Index)}
+	return c.snapshot, nil
+}
+
+// releaseSnapshot forgets the tree once its pagination ended
+func (c *ClientV2) releaseSnapshot(snapshot *treeSnapshot) {
+	c.snapshotMu.Lock()
+	defer c.snapshotMu.Unlock()
+	if c.snapshot == snapshot {
+		c.snapshot = nil
+	}
+}
+
+// PartitionKeys returns a single range, as etcd v2 cannot read ranges of keys. The tree of keys is read
+// at the returned index, for GetRangeWithPagination to serve its pages.
+func (c *ClientV2) PartitionKeys(ctx context.Context, n int) ([]KeyRange, int64, error) {
+	snapshot, err := c.getSnapshot(ctx, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return []KeyRange{{Start: c.rootPrefixEtcd}}, snapshot.index, nil
+}
+
+// GetRangeWithPagination retrieves all the keys at the index returned by PartitionKeys
+func (c *ClientV2) GetRangeWithPagination(ctx context.Context, keyRange KeyRange, fromKey string, revision int64) ([]common.KV, string, error) {
+	keys, nextKey, _, err := c.GetKeysWithPagination(ctx, fromKey, revision)
+	return keys, nextKey, err
 }
 
 // StartAuditor starts a background goroutine that checks etcd connection health every EtcdAuditPeriod
-// Returns an error channel that will receive errors if the connection check fails
+// Returns a channel receiving the result of every connection check, nil if it succeeded, until ctx is done
 func (c *ClientV2) StartAuditor(ctx context.Context) <-chan error {
-	errCh := make(chan error, 1)
+	resultCh := make(chan error, 1)
 
 	go func() {
-		defer close(errCh)
+		defer close(resultCh)
 		ticker := time.NewTicker(c.EtcdAuditPeriod)
 		defer ticker.Stop()
 
 		logger.Infof("Starting etcd v2 connection auditor (checking every %s)", c.EtcdAuditPeriod)
 
-		// Perform initial health check
-		if err := c.checkConnection(ctx); err != nil {
-			logger.Errorf("Initial etcd v2 connection check failed: %v", err)
-			errCh <- fmt.Errorf("initial etcd v2 connection check failed: %w", err)
-			return
-		}
-		logger.Infof("Initial etcd v2 connection check: OK")
-
 		for {
+			// The first check runs right away, failed checks do not stop the auditor
+			err := c.checkConnection(ctx)
+			if err != nil {
+				logger.Errorf("Etcd v2 connection check failed: %v", err)
+				err = fmt.Errorf("etcd v2 connection check failed: %w", err)
+			} else {
+				logger.Debugf("Etcd v2 connection check: OK")
+			}
+
+			select {
+			case resultCh <- err:
+			case <-ctx.Done():
+				logger.Infof("Stopping etcd v2 connection auditor")
+				return
+			}
+
 			select {
 			case <-ctx.Done():
 				logger.Infof("Stopping etcd v2 connection auditor")
 				return
 			case <-ticker.C:
-				if err := c.checkConnection(ctx); err != nil {
-					logger.Errorf("Etcd v2 connection check failed: %v", err)
-					errCh <- fmt.Errorf("etcd v2 connection check failed: %w", err)
-					return
-				}
-				logger.Debugf("Etcd v2 connection check: OK")
 			}
 		}
 	}()
 
-	return errCh
+	return resultCh
 }
 
 // checkConnection performs a health check on the etcd v2 connection
-func (c *ClientV2) checkConnection(ctx context.Context) error {
+func (c *ClientV2) checkConnection(ctx context.Context) (err error) {
+	defer func() { metrics.ObserveHealthCheck(err) }()
+
 	// Create a timeout context for the health check
 	checkCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
@@ -318,7 +482,7 @@ func (c *ClientV2) checkConnection(ctx context.Context) error {
 	// Try to get the version endpoint to verify connectivity
 	// For v2, we can try to get a key that doesn't exist at a known path
 	// If the service is unreachable, we'll get a network error instead of "key not found"
-	_, err := c.client.Get(checkCtx, "/__etcdfinder_health_check__", nil)
+	_, err = c.client.Get(checkCtx, "/__etcdfinder_health_check__", nil)
 
 	// If we get a key not found error, that's actually good - it means the service is reachable
 	if err != nil && !etcdv2.IsKeyNotFound(err) {
@@ -331,9 +495,52 @@ func (c *ClientV2) checkConnection(ctx context.Context) error {
 	return nil
 }
 
+// GetPrefix returns the keys in the directory of the prefix and its subdirectories
+func (c *ClientV2) GetPrefix(ctx context.Context, prefix string) ([]common.KV, error) {
+	resp, err := c.client.Get(ctx, prefix, &etcdv2.GetOptions{Recursive: true, Sort: true})
+	if err != nil {
+		if etcdv2.IsKeyNotFound(err) {
+			return []common.KV{}, nil
+		}
+		return nil, fmt.Errorf("failed to get keys: %w", err)
+	}
+
+	keys := make([]common.KV, 0)
+	var collectKeys func(node *etcdv2.Node)
+	collectKeys = func(node *etcdv2.Node) {
+		if !node.Dir {
+			keys = append(keys, common.KV{Key: node.Key, Value: node.Value, ModRevision: int64(node.ModifiedIndex)})
+			return
+		}
+		for _, child := range node.Nodes {
+			collectKeys(child)
+		}
+	}
+	collectKeys(resp.Node)
+	return keys, nil
+}
+
+// CurrentRevision returns the current etcd index, reported along with every response
+func (c *ClientV2) CurrentRevision(ctx context.Context) (int64, error) {
+	resp, err := c.client.Get(ctx, "/", nil)
+	if err != nil {
+		var etcdErr etcdv2.Error
+		if errors.As(err, &etcdErr) {
+			return int64(etcdErr.Index), nil
+		}
+		return 0, fmt.Errorf("failed to get etcd index: %w", err)
+	}
+	return int64(resp.Index), nil
+}
+
 // Close closes the etcd v2 client connection
 func (c *ClientV2) Close() error {
 	// The etcd v2 client doesn't have an explicit Close method
 	// The connection is managed by the HTTP transport
 	return nil
 }
+
+// NewElector is not supported by etcd v2
+func (c *ClientV2) NewElector(prefix, identity string, ttl int64) (Elector, error) {
+	return nil, ErrElectionUnsupported
+}
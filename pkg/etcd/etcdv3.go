@@ -2,12 +2,16 @@ package etcd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/etcdfinder/etcdfinder/internal/customerrors"
+	"github.com/etcdfinder/etcdfinder/internal/metrics"
 	"github.com/etcdfinder/etcdfinder/pkg/common"
 	"github.com/etcdfinder/etcdfinder/pkg/logger"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
@@ -19,16 +23,24 @@ type Client struct {
 	numGetKeysLimit       int64  // number of keys to be returned in a single GetKeysWithPagination call
 	EtcdAuditPeriod       time.Duration
 	maxWatchRetries       int64 // maximum number of consecutive failures on the same ModRevision
-	ExpectedModRevision   int64 // expected modified revision of the etcd keys
 }
 
 // WatchEvent represents a change event from etcd
 type WatchEvent struct {
-	Type  string
-	Key   string
-	Value string
+	Type       string
+	Key        string
+	Value      string
+	Revision   int64     // etcd revision of the change
+	ObservedAt time.Time // time the event was received from etcd
 }
 
+// EventTypeProgress is the type of the watch events without key, reporting that every change up to their revision
+// was received. etcd v3 watches send them periodically, so that a quiet prefix of a busy etcd does not look behind.
+const EventTypeProgress = "PROGRESS"
+
+// interval at which etcd v3 watches request a progress event
+const progressRequestInterval = 5 * time.Second
+
 // NewClient creates a new etcd client
 func NewClientV3(
 	endpoints []string,
@@ -55,7 +67,6 @@ func NewClientV3(
 		numGetKeysLimit:       numGetKeysLimit,
 		EtcdAuditPeriod:       time.Duration(etcdAuditPeriod) * time.Second,
 		maxWatchRetries:       maxWatchRetries,
-		ExpectedModRevision:   -1,
 	}, nil
 }
 
@@ -72,33 +83,114 @@ func (c *Client) Get(ctx context.Context, key string) (string, error) {
 	return string(resp.Kvs[0].Value), nil
 }
 
-func (c *Client) Put(ctx context.Context, key string, value string) (string, error) {
-	_, err := c.client.Put(ctx, key, value)
+func (c *Client) GetKV(ctx context.Context, key string) (common.KV, error) {
+	resp, err := c.client.Get(ctx, key)
+	if err != nil {
+		return common.KV{}, fmt.Errorf("failed to get key: %w", err)
+	}
+
+	if len(resp.Kvs) == 0 {
+		return common.KV{}, customerrors.ErrKeyNotFound
+	}
+
+	return common.KV{
+		Key:         key,
+		Value:       string(resp.Kvs[0].Value),
+		ModRevision: resp.Kvs[0].ModRevision,
+	}, nil
+}
+
+func (c *Client) Put(ctx context.Context, key string, value string) (Mutation, error) {
+	resp, err := c.client.Put(ctx, key, value, clientv3.WithPrevKV())
 	if err != nil {
-		return "", fmt.Errorf("failed to put key: %w", err)
+		return Mutation{}, fmt.Errorf("failed to put key: %w", err)
 	}
-	return key, nil
+	// The key is modified at the revision of the put
+	return Mutation{Key: key, Revision: resp.Header.Revision, Prev: prevKV(resp.PrevKv)}, nil
 }
 
-func (c *Client) Delete(ctx context.Context, key string) (string, error) {
-	_, err := c.client.Delete(ctx, key)
+func (c *Client) CompareAndPut(ctx context.Context, key string, value string, modRevision int64) (Mutation, error) {
+	resp, err := c.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", modRevision)).
+		Then(clientv3.OpPut(key, value, clientv3.WithPrevKV())).
+		Commit()
 	if err != nil {
-		return "", fmt.Errorf("failed to delete key: %w", err)
+		return Mutation{}, fmt.Errorf("failed to put key: %w", err)
+	}
+	if !resp.Succeeded {
+		return Mutation{}, customerrors.ErrPreconditionFailed
+	}
+	return Mutation{
+		Key:      key,
+		Revision: resp.Header.Revision,
+		Prev:     prevKV(resp.Responses[0].GetResponsePut().GetPrevKv()),
+	}, nil
+}
+
+func (c *Client) Delete(ctx context.Context, key string) (Mutation, error) {
+	resp, err := c.client.Delete(ctx, key, clientv3.WithPrevKV())
+	if err != nil {
+		return Mutation{}, fmt.Errorf("failed to delete key: %w", err)
+	}
+	mutation := Mutation{Key: key, Revision: resp.Header.Revision}
+	if len(resp.PrevKvs) > 0 {
+		mutation.Prev = prevKV(resp.PrevKvs[0])
+	}
+	return mutation, nil
+}
+
+func (c *Client) CompareAndDelete(ctx context.Context, key string, modRevision int64) (Mutation, error) {
+	if modRevision <= 0 {
+		return Mutation{}, customerrors.ErrPreconditionFailed
+	}
+
+	resp, err := c.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", modRevision)).
+		Then(clientv3.OpDelete(key, clientv3.WithPrevKV())).
+		Commit()
+	if err != nil {
+		return Mutation{}, fmt.Errorf("failed to delete key: %w", err)
+	}
+	if !resp.Succeeded {
+		return Mutation{}, customerrors.ErrPreconditionFailed
+	}
+	mutation := Mutation{Key: key, Revision: resp.Header.Revision}
+	if prevKvs := resp.Responses[0].GetResponseDeleteRange().GetPrevKvs(); len(prevKvs) > 0 {
+		mutation.Prev = prevKV(prevKvs[0])
 	}
-	return key, nil
+	return mutation, nil
 }
 
-// WatchPrefix watches for changes on keys
+// prevKV converts the previous key returned with WithPrevKV, nil if the key did not exist
+func prevKV(kv *mvccpb.KeyValue) *common.KV {
+	if kv == nil {
+		return nil
+	}
+	return &common.KV{
+		Key:         string(kv.Key),
+		Value:       string(kv.Value),
+		ModRevision: kv.ModRevision,
+	}
+}
+
+// WatchPrefix watches for changes on keys, starting at fromRevision if positive
 // Returns a channel of WatchEvents and an error channel
-func (c *Client) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error) {
+func (c *Client) Watch(ctx context.Context, fromRevision int64) (<-chan WatchEvent, <-chan error) {
 	eventCh := make(chan WatchEvent, c.watchEventChannelSize)
 	errCh := make(chan error, 1)
 
 	go func() {
 		defer close(eventCh)
 		defer close(errCh)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go c.requestProgress(ctx)
+
 		watchRevisionDiscrepancy := false
 		var consecutiveFailureCount int64
+		// expected modified revision of the next event, -1 until the first one
+		expectedModRevision := int64(-1)
 
 		for {
 			var watchChan clientv3.WatchChan
@@ -107,36 +199,58 @@ func (c *Client) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error) {
 					ctx,
 					c.rootPrefixEtcd,
 					clientv3.WithPrefix(),
-					clientv3.WithRev(c.ExpectedModRevision))
+					clientv3.WithRev(expectedModRevision),
+					clientv3.WithProgressNotify())
+			} else if fromRevision > 0 {
+				watchChan = c.client.Watch(
+					ctx,
+					c.rootPrefixEtcd,
+					clientv3.WithPrefix(),
+					clientv3.WithRev(fromRevision),
+					clientv3.WithProgressNotify())
 			} else {
 				watchChan = c.client.Watch(
 					ctx,
 					c.rootPrefixEtcd,
-					clientv3.WithPrefix())
+					clientv3.WithPrefix(),
+					clientv3.WithProgressNotify())
 			}
 
 			for watchResp := range watchChan {
+				if watchResp.CompactRevision != 0 {
+					errCh <- fmt.Errorf("watch error: %w: compacted up to revision %d", ErrCompacted, watchResp.CompactRevision)
+					return
+				}
 				if watchResp.Err() != nil {
 					errCh <- fmt.Errorf("watch error: %w", watchResp.Err())
 					return
 				}
+				if watchResp.IsProgressNotify() {
+					select {
+					case eventCh <- WatchEvent{Type: EventTypeProgress, Revision: watchResp.Header.Revision, ObservedAt: time.Now()}:
+					case <-ctx.Done():
+						return
+					}
+					continue
+				}
 
 				for _, event := range watchResp.Events {
 					// if this is the first event, set the expected modrevision to the current modrevision
-					if c.ExpectedModRevision == -1 {
-						c.ExpectedModRevision = event.Kv.ModRevision
+					if expectedModRevision == -1 {
+						expectedModRevision = event.Kv.ModRevision
 					}
 					// check if the modrevision is not equal to the expected modrevision
 					// which is the last modrevision + 1
 					// it means that some event must have been missed due to some network issues
 					// so it will break the loop and restart the watch to ensure consistency
-					if event.Kv.ModRevision != c.ExpectedModRevision {
+					if event.Kv.ModRevision != expectedModRevision {
 						consecutiveFailureCount++
-						logger.Warnf("ModRevision mismatch: Consecutive failure #%d on ModRevision %d", consecutiveFailureCount, c.ExpectedModRevision)
+						metrics.WatchRevisionGapRetries.Inc()
+						logger.Warnf("ModRevision mismatch: Consecutive failure #%d on ModRevision %d", consecutiveFailureCount, expectedModRevision)
 
 						// If we've exceeded max retries on the same revision, fail fast
 						if consecutiveFailureCount >= c.maxWatchRetries {
-							errCh <- fmt.Errorf("exceeded max watch retries (%d) on ModRevision %d - failing fast to prevent infinite loop", c.maxWatchRetries, c.ExpectedModRevision)
+							errCh <- fmt.Errorf("exceeded max watch retries (%d) on ModRevision %d - failing fast to prevent infinite loop", c.maxWatchRetries, expectedModRevision)
 							return
 						}
 						watchRevisionDiscrepancy = true
@@ -145,10 +259,12 @@ func (c *Client) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error) {
 
 					// Successfully processed an event, reset failure counter
 					consecutiveFailureCount = 0
-					c.ExpectedModRevision = event.Kv.ModRevision + 1
+					expectedModRevision = event.Kv.ModRevision + 1
 
 					watchEvent := WatchEvent{
-						Key: string(event.Kv.Key),
+						Key:        string(event.Kv.Key),
+						Revision:   event.Kv.ModRevision,
+						ObservedAt: time.Now(),
 					}
 
 					switch event.Type {
@@ -176,93 +292,177 @@ func (c *Client) Watch(ctx context.Context) (<-chan WatchEvent, <-chan error) {
 	return eventCh, errCh
 }
 
-// GetKeysWithPagination retrieves keys with pagination support
-func (c *Client) GetKeysWithPagination(ctx context.Context, fromKey string) ([]common.KV, string, error) {
+// requestProgress asks etcd for a progress event on the watches of ctx every progressRequestInterval, etcd only
+// sends them every 10 minutes by default
+func (c *Client) requestProgress(ctx context.Context) {
+	ticker := time.NewTicker(progressRequestInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.client.RequestProgress(ctx); err != nil && ctx.Err() == nil {
+				logger.Debugf("Failed to request watch progress: %v", err)
+			}
+		}
+	}
+}
 
-	opts := []clientv3.OpOption{
-		clientv3.WithLimit(c.numGetKeysLimit),
+// GetKeysWithPagination retrieves the keys under the root prefix with pagination support, all pages being read
+// at the revision of the first one
+func (c *Client) GetKeysWithPagination(ctx context.Context, fromKey string, revision int64) ([]common.KV, string, int64, error) {
+	return c.getRange(ctx, c.prefixRange(), fromKey, revision)
+}
+
+// PartitionKeys splits the keys under the root prefix into at most n ranges between the first and last keys,
+// at the current revision
+func (c *Client) PartitionKeys(ctx context.Context, n int) ([]KeyRange, int64, error) {
+	whole := c.prefixRange()
+	first, err := c.client.Get(ctx, whole.Start, append(rangeOpts(whole),
+		clientv3.WithLimit(1),
+		clientv3.WithKeysOnly(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get first key: %w", err)
+	}
+	revision := first.Header.Revision
+	if len(first.Kvs) == 0 {
+		return []KeyRange{whole}, revision, nil
 	}
-	var key string
+
+	last, err := c.client.Get(ctx, whole.Start, append(rangeOpts(whole),
+		clientv3.WithRev(revision),
+		clientv3.WithLimit(1),
+		clientv3.WithKeysOnly(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend))...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get last key: %w", err)
+	}
+	if len(last.Kvs) == 0 {
+		return []KeyRange{whole}, revision, nil
+	}
+
+	return splitRange(whole, string(first.Kvs[0].Key), string(last.Kvs[0].Key), n), revision, nil
+}
+
+// GetRangeWithPagination retrieves the keys of the range as of the revision, with pagination support
+func (c *Client) GetRangeWithPagination(ctx context.Context, keyRange KeyRange, fromKey string, revision int64) ([]common.KV, string, error) {
+	keys, nextKey, _, err := c.getRange(ctx, keyRange, fromKey, revision)
+	return keys, nextKey, err
+}
+
+// getRange reads a page of the range at the revision, or at the current revision if 0, and returns the keys,
+// the next key to be fetched and the revision read at
+func (c *Client) getRange(ctx context.Context, keyRange KeyRange, fromKey string, revision int64) ([]common.KV, string, int64, error) {
+	key := keyRange.Start
 	if fromKey != "" {
-		key = fromKey
-		opts = append(opts, clientv3.WithFromKey())
-	} else {
-		key = c.rootPrefixEtcd
-		opts = append(opts, clientv3.WithPrefix())
+		// smallest key after fromKey
+		key = fromKey + "\x00"
 	}
 
+	opts := append(rangeOpts(keyRange),
+		clientv3.WithLimit(c.numGetKeysLimit),
+		clientv3.WithRev(revision))
 	resp, err := c.client.Get(ctx, key, opts...)
+	if errors.Is(err, rpctypes.ErrCompacted) {
+		return nil, "", 0, fmt.Errorf("failed to get keys at revision %d: %w", revision, ErrCompacted)
+	}
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to get keys: %w", err)
+		return nil, "", 0, fmt.Errorf("failed to get keys: %w", err)
+	}
+	if revision == 0 {
+		revision = resp.Header.Revision
 	}
 
-	keys := make([]common.KV, 0)
-
+	keys := make([]common.KV, 0, len(resp.Kvs))
 	for _, kv := range resp.Kvs {
-		if string(kv.Key) == fromKey {
-			continue
-		}
-
 		keys = append(keys, common.KV{
-			Key:   string(kv.Key),
-			Value: string(kv.Value),
+			Key:         string(kv.Key),
+			Value:       string(kv.Value),
+			ModRevision: kv.ModRevision,
 		})
 	}
 
-	if len(keys) == 0 {
-		return keys, "", nil
+	if !resp.More || len(keys) == 0 {
+		return keys, "", revision, nil
 	}
+	return keys, keys[len(keys)-1].Key, revision, nil
+}
 
-	return keys, keys[len(keys)-1].Key, nil
+// prefixRange returns the range of the keys under the root prefix
+func (c *Client) prefixRange() KeyRange {
+	keyRange := KeyRange{Start: c.rootPrefixEtcd, End: clientv3.GetPrefixRangeEnd(c.rootPrefixEtcd)}
+	if keyRange.End == "\x00" {
+		// the prefix has no upper bound
+		keyRange.End = ""
+	}
+	if keyRange.Start == "" {
+		// every key is watched, etcd does not accept the empty key
+		keyRange.Start = "\x00"
+	}
+	return keyRange
+}
+
+// rangeOpts returns the options restricting a get to the range
+func rangeOpts(keyRange KeyRange) []clientv3.OpOption {
+	if keyRange.End == "" {
+		return []clientv3.OpOption{clientv3.WithFromKey()}
+	}
+	return []clientv3.OpOption{clientv3.WithRange(keyRange.End)}
 }
 
 // StartAuditor starts a background goroutine that checks etcd connection health every EtcdAuditPeriod
-// Returns an error channel that will receive errors if the connection check fails
+// Returns a channel receiving the result of every connection check, nil if it succeeded, until ctx is done
 func (c *Client) StartAuditor(ctx context.Context) <-chan error {
-	errCh := make(chan error, 1)
+	resultCh := make(chan error, 1)
 
 	go func() {
-		defer close(errCh)
+		defer close(resultCh)
 		ticker := time.NewTicker(c.EtcdAuditPeriod)
 		defer ticker.Stop()
 
 		logger.Infof("Starting etcd connection auditor (checking every %s)", c.EtcdAuditPeriod)
 
-		// Perform initial health check
-		if err := c.checkConnection(ctx); err != nil {
-			logger.Errorf("Initial etcd connection check failed: %v", err)
-			errCh <- fmt.Errorf("initial etcd connection check failed: %w", err)
-			return
-		}
-		logger.Infof("Initial etcd connection check: OK")
-
 		for {
+			// The first check runs right away, failed checks do not stop the auditor
+			err := c.checkConnection(ctx)
+			if err != nil {
+				logger.Errorf("Etcd connection check failed: %v", err)
+				err = fmt.Errorf("etcd connection check failed: %w", err)
+			} else {
+				logger.Debugf("Etcd connection check: OK")
+			}
+
+			select {
+			case resultCh <- err:
+			case <-ctx.Done():
+				logger.Infof("Stopping etcd connection auditor")
+				return
+			}
+
 			select {
 			case <-ctx.Done():
 				logger.Infof("Stopping etcd connection auditor")
 				return
 			case <-ticker.C:
-				if err := c.checkConnection(ctx); err != nil {
-					logger.Errorf("Etcd connection check failed: %v", err)
-					errCh <- fmt.Errorf("etcd connection check failed: %w", err)
-					return
-				}
-				logger.Debugf("Etcd connection check: OK")
 			}
 		}
 	}()
 
-	return errCh
+	return resultCh
 }
 
 // checkConnection performs a health check on the etcd connection
-func (c *Client) checkConnection(ctx context.Context) error {
+func (c *Client) checkConnection(ctx context.Context) (err error) {
+	defer func() { metrics.ObserveHealthCheck(err) }()
+
 	// Create a timeout context for the health check
 	checkCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Try to get the status from etcd to verify connectivity
-	_, err := c.client.Status(checkCtx, c.client.Endpoints()[0])
+	_, err = c.client.Status(checkCtx, c.client.Endpoints()[0])
 	if err != nil {
 		return fmt.Errorf("failed to get etcd status: %w", err)
 	}
@@ -270,6 +470,29 @@ func (c *Client) checkConnection(ctx context.Context) error {
 	return nil
 }
 
+// GetPrefix returns the keys under the prefix in a single read
+func (c *Client) GetPrefix(ctx context.Context, prefix string) ([]common.KV, error) {
+	resp, err := c.client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys: %w", err)
+	}
+
+	keys := make([]common.KV, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		keys = append(keys, common.KV{Key: string(kv.Key), Value: string(kv.Value), ModRevision: kv.ModRevision})
+	}
+	return keys, nil
+}
+
+// CurrentRevision returns the revision of the etcd cluster
+func (c *Client) CurrentRevision(ctx context.Context) (int64, error) {
+	resp, err := c.client.Status(ctx, c.client.Endpoints()[0])
+	if err != nil {
+		return 0, fmt.Errorf("failed to get etcd status: %w", err)
+	}
+	return resp.Header.Revision, nil
+}
+
 // Close closes the etcd client connection
 func (c *Client) Close() error {
 	if c.client != nil {
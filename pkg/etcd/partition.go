@@ -0,0 +1,35 @@
+package etcd
+
+// splitRange splits the range into at most n ranges, given the first and last keys it contains.
+// The ranges are split on the first byte where the first and last keys differ, so that keys sharing
+// a long common prefix, such as /registry/, are still spread across the ranges.
+func splitRange(keyRange KeyRange, first, last string, n int) []KeyRange {
+	if n <= 1 || first >= last {
+		return []KeyRange{keyRange}
+	}
+
+	common := 0
+	for common < len(first) && common < len(last) && first[common] == last[common] {
+		common++
+	}
+
+	// first is either a prefix of last or smaller at the byte following the common prefix
+	low := 0
+	if common < len(first) {
+		low = int(first[common])
+	}
+	high := int(last[common])
+	span := high - low + 1
+
+	ranges := make([]KeyRange, 0, n)
+	start := keyRange.Start
+	for i := 1; i < n; i++ {
+		boundary := last[:common] + string([]byte{byte(low + span*i/n)})
+		if boundary <= start {
+			continue
+		}
+		ranges = append(ranges, KeyRange{Start: start, End: boundary})
+		start = boundary
+	}
+	return append(ranges, KeyRange{Start: start, End: keyRange.End})
+}
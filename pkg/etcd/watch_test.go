@@ -0,0 +1,121 @@
+package etcd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/etcdfinder/etcdfinder/pkg/logger"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	etcdv2 "go.etcd.io/etcd/client/v2"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// fakeWatcherV3 hands a new response stream to every watch, the streams are received from watches in call order
+type fakeWatcherV3 struct {
+	clientv3.Watcher
+
+	watches chan chan clientv3.WatchResponse
+}
+
+func (w *fakeWatcherV3) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	stream := make(chan clientv3.WatchResponse)
+	w.watches <- stream
+	return stream
+}
+
+func (w *fakeWatcherV3) RequestProgress(ctx context.Context) error {
+	return nil
+}
+
+// fakeKeysV2 hands a new response stream to every watcher, the streams are received from watches in call order
+type fakeKeysV2 struct {
+	etcdv2.KeysAPI
+
+	watches chan chan *etcdv2.Response
+}
+
+func (k *fakeKeysV2) Watcher(key string, opts *etcdv2.WatcherOptions) etcdv2.Watcher {
+	stream := make(chan *etcdv2.Response)
+	k.watches <- stream
+	return fakeWatcherV2(stream)
+}
+
+type fakeWatcherV2 chan *etcdv2.Response
+
+func (w fakeWatcherV2) Next(ctx context.Context) (*etcdv2.Response, error) {
+	select {
+	case resp := <-w:
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// receive fails the test unless the watch emits an event at revision within a second
+func receive(t *testing.T, events <-chan WatchEvent, errs <-chan error, revision int64) {
+	t.Helper()
+	select {
+	case event := <-events:
+		if event.Revision != revision {
+			t.Fatalf("received event at revision %d, want %d", event.Revision, revision)
+		}
+	case err := <-errs:
+		t.Fatalf("watch failed: %v", err)
+	case <-time.After(time.Second):
+		t.Fatalf("event at revision %d was not received", revision)
+	}
+}
+
+// Watches running on the same client track the revisions they expect on their own
+func TestConcurrentWatchesV3(t *testing.T) {
+	logger.L = &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}
+
+	watcher := &fakeWatcherV3{watches: make(chan chan clientv3.WatchResponse, 2)}
+	c := &Client{client: &clientv3.Client{Watcher: watcher}, watchEventChannelSize: 1, maxWatchRetries: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eventsA, errsA := c.Watch(ctx, 10)
+	streamA := <-watcher.watches
+	eventsB, errsB := c.Watch(ctx, 50)
+	streamB := <-watcher.watches
+
+	put := func(revision int64) clientv3.WatchResponse {
+		return clientv3.WatchResponse{Events: []*clientv3.Event{{
+			Type: mvccpb.PUT,
+			Kv:   &mvccpb.KeyValue{Key: []byte("/key"), Value: []byte("v"), ModRevision: revision},
+		}}}
+	}
+	for n := range int64(3) {
+		streamA <- put(10 + n)
+		receive(t, eventsA, errsA, 10+n)
+		streamB <- put(50 + n)
+		receive(t, eventsB, errsB, 50+n)
+	}
+}
+
+func TestConcurrentWatchesV2(t *testing.T) {
+	logger.L = &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}
+
+	keys := &fakeKeysV2{watches: make(chan chan *etcdv2.Response, 2)}
+	c := &ClientV2{client: keys, watchEventChannelSize: 1, maxWatchRetries: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eventsA, errsA := c.Watch(ctx, 10)
+	streamA := <-keys.watches
+	eventsB, errsB := c.Watch(ctx, 50)
+	streamB := <-keys.watches
+
+	set := func(index uint64) *etcdv2.Response {
+		return &etcdv2.Response{Action: "set", Node: &etcdv2.Node{Key: "/key", Value: "v", ModifiedIndex: index}}
+	}
+	for n := range uint64(3) {
+		streamA <- set(10 + n)
+		receive(t, eventsA, errsA, int64(10+n))
+		streamB <- set(50 + n)
+		receive(t, eventsB, errsB, int64(50+n))
+	}
+}
@@ -2,15 +2,41 @@ package kvstore
 
 import (
 	"context"
+	"strconv"
 
+	"github.com/cespare/xxhash/v2"
 	"github.com/etcdfinder/etcdfinder/pkg/common"
 )
 
+// ScanStart is the afterRevision of the first page of Scan, below the revisions of every stored key
+const ScanStart int64 = -1
+
 type KVStore interface {
 	Get(ctx context.Context, key string) (string, error)
 	Put(ctx context.Context, key, value string) error
+	// PutBatch and DeleteBatch may return before the batch is searchable, Flush waits for the pending batches
 	PutBatch(ctx context.Context, kvs []common.KV) error
-	Search(ctx context.Context, searchStr string) ([]common.KV, error)
+	Flush(ctx context.Context) error
+	Search(ctx context.Context, searchStr string, offset, limit int64) ([]common.KV, error)
+	// Scan returns about limit stored keys whose mod revision is above afterRevision, ScanStart for the first page,
+	// sorted by mod revision, with the value hash they were stored with. A page holds every key of its last mod
+	// revision, which is the afterRevision of the next page: the keys written meanwhile are written at higher
+	// revisions, so a key that is not modified during the scan is never skipped. The last page is empty.
+	Scan(ctx context.Context, afterRevision, limit int64) ([]common.KV, error)
 	Delete(ctx context.Context, key string) error
+	DeleteBatch(ctx context.Context, keys []string) error
+	// Ping checks that the KV store is reachable and available
+	Ping(ctx context.Context) error
+	// Reset removes every key and the checkpoint from the KV store
+	Reset(ctx context.Context) error
+	// SaveCheckpoint persists the revision of etcd the KV store is up to date with
+	SaveCheckpoint(ctx context.Context, revision int64) error
+	// LoadCheckpoint returns the revision persisted by SaveCheckpoint, 0 if there is none
+	LoadCheckpoint(ctx context.Context) (int64, error)
 	Close(ctx context.Context) error
 }
+
+// HashValue returns the hash of an etcd value stored along with it, to detect values the KV store missed
+func HashValue(value string) string {
+	return strconv.FormatUint(xxhash.Sum64String(value), 36)
+}
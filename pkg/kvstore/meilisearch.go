@@ -3,8 +3,12 @@ package kvstore
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/cespare/xxhash/v2"
 	"github.com/etcdfinder/etcdfinder/internal/lib"
@@ -13,38 +17,74 @@ import (
 	"github.com/meilisearch/meilisearch-go"
 )
 
+const (
+	defaultTaskTimeout      = 30 * time.Second
+	defaultTaskPollInterval = 50 * time.Millisecond
+)
+
+// suffix of the index storing the checkpoint along with the index of the keys
+const metaIndexSuffix = "-meta"
+
 // MeilisearchStore implements the KVStore interface using Meilisearch
 type MeilisearchStore struct {
 	client           meilisearch.ServiceManager
 	indexName        string
 	matchingStrategy meilisearch.MatchingStrategy
+	taskTimeout      time.Duration // maximum time to wait for a single task
+	taskPollInterval time.Duration
+
+	// UIDs of the batch tasks enqueued since the last Flush
+	pendingMu sync.Mutex
+	pending   []int64
 }
 
 func makeID(key string) string {
 	return strconv.FormatUint(xxhash.Sum64String(key), 36)
 }
 
-func createDocument(key string, value string) map[string]any {
+func createDocument(kv common.KV) map[string]any {
+	// The value may have been redacted or stripped on its way to the store, the hash of the etcd value is kept if known
+	valueHash := kv.ValueHash
+	if valueHash == "" {
+		valueHash = HashValue(kv.Value)
+	}
 	return map[string]any{
-		lib.ID_CONSTANT:    makeID(key), // Meilisearch uses 'id' as the default primary key
-		lib.KEY_CONSTANT:   key,
-		lib.VALUE_CONSTANT: value,
+		lib.ID_CONSTANT:           makeID(kv.Key), // Meilisearch uses 'id' as the default primary key
+		lib.KEY_CONSTANT:          kv.Key,
+		lib.VALUE_CONSTANT:        kv.Value,
+		lib.MOD_REVISION_CONSTANT: kv.ModRevision,
+		lib.VALUE_HASH_CONSTANT:   valueHash,
 	}
 }
 
-// NewMeilisearchStore creates a new Meilisearch-backed KVStore
-func NewMeilisearchStore(host, indexName, matchingStrategy string) (KVStore, error) {
+// NewMeilisearchStore creates a new Meilisearch-backed KVStore, keeping the documents of an existing index.
+// Writes wait at most taskTimeout for Meilisearch to process them, polling the task every taskPollInterval.
+func NewMeilisearchStore(host, indexName, matchingStrategy string, taskTimeout, taskPollInterval time.Duration) (KVStore, error) {
 	client := meilisearch.New(host)
 
-	// Delete existing index to start fresh
-	if _, err := client.DeleteIndex(indexName); err != nil {
-		// Ignore error as index might not exist
-		// In a production app we might want to check the error type
-		logger.Errorf("Failed to delete existing index: %v", err)
+	ms := &MeilisearchStore{
+		client:           client,
+		indexName:        indexName,
+		matchingStrategy: meilisearch.MatchingStrategy(matchingStrategy),
+		taskTimeout:      taskTimeout,
+		taskPollInterval: taskPollInterval,
+	}
+	if ms.taskTimeout <= 0 {
+		ms.taskTimeout = defaultTaskTimeout
+	}
+	if ms.taskPollInterval <= 0 {
+		ms.taskPollInterval = defaultTaskPollInterval
+	}
+
+	if err := ms.configure(context.Background()); err != nil {
 		return nil, err
 	}
+	return ms, nil
+}
 
-	_, err := client.Index(indexName).UpdateSettings(&meilisearch.Settings{
+// configure applies the settings of the index, creating it if it does not exist
+func (ms *MeilisearchStore) configure(ctx context.Context) error {
+	taskInfo, err := ms.client.Index(ms.indexName).UpdateSettingsWithContext(ctx, &meilisearch.Settings{
 		RankingRules: []string{
 			"words",
 			"exactness",
@@ -58,18 +98,71 @@ func NewMeilisearchStore(host, indexName, matchingStrategy string) (KVStore, err
 		},
 		FilterableAttributes: []string{
 			lib.KEY_CONSTANT,
+			lib.MOD_REVISION_CONSTANT,
+		},
+		// Scan pages the documents by mod revision
+		SortableAttributes: []string{
+			lib.MOD_REVISION_CONSTANT,
 		},
 	})
 	if err != nil {
 		logger.Errorf("Failed to configure index settings: %v", err)
-		return nil, err
+		return err
+	}
+	if _, err := ms.waitForTask(ctx, taskInfo.TaskUID); err != nil {
+		logger.Errorf("Failed to configure index settings: %v", err)
+		return err
 	}
+	return nil
+}
 
-	return &MeilisearchStore{
-		client:           client,
-		indexName:        indexName,
-		matchingStrategy: meilisearch.MatchingStrategy(matchingStrategy),
-	}, nil
+// Reset deletes the checkpoint, then recreates the index empty, so that a partial index is never taken for
+// up to date with the checkpoint
+func (ms *MeilisearchStore) Reset(ctx context.Context) error {
+	for _, indexName := range []string{ms.indexName + metaIndexSuffix, ms.indexName} {
+		taskInfo, err := ms.client.DeleteIndexWithContext(ctx, indexName)
+		if err != nil {
+			return fmt.Errorf("failed to delete index %s: %w", indexName, err)
+		}
+		// Deleting an index that does not exist fails the task, which is fine
+		if _, err := ms.waitForTask(ctx, taskInfo.TaskUID); err != nil && !errors.Is(err, errTaskFailed) {
+			return fmt.Errorf("failed to delete index %s: %w", indexName, err)
+		}
+	}
+	return ms.configure(ctx)
+}
+
+// SaveCheckpoint stores the revision as the single document of the meta index
+func (ms *MeilisearchStore) SaveCheckpoint(ctx context.Context, revision int64) error {
+	doc := map[string]any{
+		lib.ID_CONSTANT:       lib.CHECKPOINT_ID,
+		lib.REVISION_CONSTANT: revision,
+	}
+	primaryKey := lib.ID_CONSTANT
+	taskInfo, err := ms.client.Index(ms.indexName+metaIndexSuffix).AddDocumentsWithContext(ctx, []map[string]any{doc}, &primaryKey)
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	if _, err := ms.waitForTask(ctx, taskInfo.TaskUID); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// LoadCheckpoint reads the revision from the meta index, 0 if the index or the checkpoint does not exist
+func (ms *MeilisearchStore) LoadCheckpoint(ctx context.Context) (int64, error) {
+	var doc struct {
+		Revision int64 `json:"revision"`
+	}
+	err := ms.client.Index(ms.indexName+metaIndexSuffix).GetDocumentWithContext(ctx, lib.CHECKPOINT_ID, nil, &doc)
+	var meiliErr *meilisearch.Error
+	if errors.As(err, &meiliErr) && meiliErr.StatusCode == http.StatusNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+	return doc.Revision, nil
 }
 
 // Get retrieves the value for a given key
@@ -90,31 +183,55 @@ func (ms *MeilisearchStore) Get(ctx context.Context, key string) (string, error)
 
 // Put stores or updates a key-value pair
 func (ms *MeilisearchStore) Put(ctx context.Context, key string, value string) error {
-	doc := createDocument(key, value)
-	_, err := ms.client.Index(ms.indexName).AddDocuments([]map[string]any{doc}, nil)
+	doc := createDocument(common.KV{Key: key, Value: value})
+	taskInfo, err := ms.client.Index(ms.indexName).AddDocumentsWithContext(ctx, []map[string]any{doc}, nil)
 	if err != nil {
 		return fmt.Errorf("failed to add document: %w", err)
 	}
+	if _, err := ms.waitForTask(ctx, taskInfo.TaskUID); err != nil {
+		return fmt.Errorf("failed to add document: %w", err)
+	}
 	return nil
 }
 
-// PutBatch stores or updates a batch of key-value pairs
+// PutBatch enqueues a batch of key-value pairs to store or update, without waiting for them to be indexed.
+// Flush waits for the enqueued batches.
 func (ms *MeilisearchStore) PutBatch(ctx context.Context, kvs []common.KV) error {
 	items := []map[string]any{}
 	for _, kv := range kvs {
-		items = append(items, createDocument(kv.Key, kv.Value))
+		items = append(items, createDocument(kv))
 	}
-	_, err := ms.client.Index(ms.indexName).AddDocuments(items, nil)
+	taskInfo, err := ms.client.Index(ms.indexName).AddDocumentsWithContext(ctx, items, nil)
 	if err != nil {
 		return fmt.Errorf("failed to add documents: %w", err)
 	}
+	ms.track(taskInfo.TaskUID)
 	return nil
 }
 
-// Search searches for keys or values matching the search string
-func (ms *MeilisearchStore) Search(ctx context.Context, searchStr string) ([]common.KV, error) {
+// Flush waits for the batches enqueued by PutBatch to be indexed, and fails if any of them failed
+func (ms *MeilisearchStore) Flush(ctx context.Context) error {
+	ms.pendingMu.Lock()
+	pending := ms.pending
+	ms.pending = nil
+	ms.pendingMu.Unlock()
+
+	// Meilisearch processes the tasks of an index in order, so each task is waited for at most taskTimeout
+	// after the previous one completed
+	for _, taskUID := range pending {
+		if _, err := ms.waitForTask(ctx, taskUID); err != nil {
+			return fmt.Errorf("failed to write documents: %w", err)
+		}
+	}
+	logger.Debugf("Flushed %d Meilisearch tasks", len(pending))
+	return nil
+}
+
+// Search searches for keys or values matching the search string, returning at most limit hits after offset
+func (ms *MeilisearchStore) Search(ctx context.Context, searchStr string, offset, limit int64) ([]common.KV, error) {
 	searchRes, err := ms.client.Index(ms.indexName).Search(searchStr, &meilisearch.SearchRequest{
-		Limit:            100, // Set a reasonable limit
+		Offset:           offset,
+		Limit:            limit,
 		MatchingStrategy: ms.matchingStrategy,
 	})
 	if err != nil {
@@ -143,7 +260,8 @@ func (ms *MeilisearchStore) Search(ctx context.Context, searchStr string) ([]com
 			}
 		}
 
-		if key != "" && value != "" {
+		// Values may legitimately be empty, only the key is required
+		if key != "" {
 			kvs = append(kvs, common.KV{
 				Key:   key,
 				Value: value,
@@ -154,12 +272,120 @@ func (ms *MeilisearchStore) Search(ctx context.Context, searchStr string) ([]com
 	return kvs, nil
 }
 
+// Scan pages the stored documents by mod revision, completing every page with the documents of its last revision.
+// Documents can only leave a past revision, so the documents of a revision fetched at once are all of them.
+func (ms *MeilisearchStore) Scan(ctx context.Context, afterRevision, limit int64) ([]common.KV, error) {
+	// Searches sort by any sortable attribute, unlike the documents route, the page stays within maxTotalHits
+	res, err := ms.client.Index(ms.indexName).SearchWithContext(ctx, "", &meilisearch.SearchRequest{
+		Limit:                limit,
+		Filter:               fmt.Sprintf("%s > %d", lib.MOD_REVISION_CONSTANT, afterRevision),
+		Sort:                 []string{lib.MOD_REVISION_CONSTANT + ":asc"},
+		AttributesToRetrieve: scanFields,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan documents: %w", err)
+	}
+	var kvs []common.KV
+	if err := res.Hits.DecodeInto(&kvs); err != nil {
+		return nil, fmt.Errorf("failed to decode documents: %w", err)
+	}
+	if int64(len(kvs)) < limit {
+		return kvs, nil
+	}
+
+	// The documents of the last revision may not fit in the page, a single etcd transaction can write many keys
+	last := kvs[len(kvs)-1].ModRevision
+	for len(kvs) > 0 && kvs[len(kvs)-1].ModRevision == last {
+		kvs = kvs[:len(kvs)-1]
+	}
+	for groupLimit := limit; ; groupLimit *= 2 {
+		var group meilisearch.DocumentsResult
+		err := ms.client.Index(ms.indexName).GetDocumentsWithContext(ctx, &meilisearch.DocumentsQuery{
+			Limit:  groupLimit,
+			Fields: scanFields,
+			Filter: fmt.Sprintf("%s = %d", lib.MOD_REVISION_CONSTANT, last),
+		}, &group)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get documents: %w", err)
+		}
+		if group.Total <= groupLimit {
+			var groupKVs []common.KV
+			if err := group.Results.DecodeInto(&groupKVs); err != nil {
+				return nil, fmt.Errorf("failed to decode documents: %w", err)
+			}
+			return append(kvs, groupKVs...), nil
+		}
+	}
+}
+
+// scanFields are the fields of the documents returned by Scan
+var scanFields = []string{lib.KEY_CONSTANT, lib.VALUE_CONSTANT, lib.MOD_REVISION_CONSTANT, lib.VALUE_HASH_CONSTANT}
+
 // Delete removes a key-value pair
 func (ms *MeilisearchStore) Delete(ctx context.Context, key string) error {
-	_, err := ms.client.Index(ms.indexName).DeleteDocument(makeID(key))
+	taskInfo, err := ms.client.Index(ms.indexName).DeleteDocumentWithContext(ctx, makeID(key))
 	if err != nil {
 		return fmt.Errorf("failed to delete document: %w", err)
 	}
+	if _, err := ms.waitForTask(ctx, taskInfo.TaskUID); err != nil {
+		return fmt.Errorf("failed to delete document: %w", err)
+	}
+	return nil
+}
+
+// DeleteBatch enqueues the removal of the keys, without waiting for them to be removed from the index.
+// Flush waits for the enqueued batches.
+func (ms *MeilisearchStore) DeleteBatch(ctx context.Context, keys []string) error {
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, makeID(key))
+	}
+	taskInfo, err := ms.client.Index(ms.indexName).DeleteDocumentsWithContext(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("failed to delete documents: %w", err)
+	}
+	ms.track(taskInfo.TaskUID)
+	return nil
+}
+
+// track records a batch task for the next Flush to wait for
+func (ms *MeilisearchStore) track(taskUID int64) {
+	ms.pendingMu.Lock()
+	defer ms.pendingMu.Unlock()
+	ms.pending = append(ms.pending, taskUID)
+}
+
+// errTaskFailed is returned for the tasks Meilisearch failed or canceled
+var errTaskFailed = errors.New("meilisearch task failed")
+
+// waitForTask polls the task until Meilisearch processed it, at most taskTimeout
+func (ms *MeilisearchStore) waitForTask(ctx context.Context, taskUID int64) (*meilisearch.Task, error) {
+	ctx, cancel := context.WithTimeout(ctx, ms.taskTimeout)
+	defer cancel()
+
+	task, err := ms.client.WaitForTaskWithContext(ctx, taskUID, ms.taskPollInterval)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for task %d: %w", taskUID, err)
+	}
+
+	switch task.Status {
+	case meilisearch.TaskStatusFailed:
+		return task, fmt.Errorf("task %d: %w: %s (%s)", taskUID, errTaskFailed, task.Error.Message, task.Error.Code)
+	case meilisearch.TaskStatusCanceled:
+		return task, fmt.Errorf("task %d: %w: canceled", taskUID, errTaskFailed)
+	}
+	return task, nil
+}
+
+// Ping checks that Meilisearch is available
+func (ms *MeilisearchStore) Ping(ctx context.Context) error {
+	health, err := ms.client.HealthWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to reach Meilisearch: %w", err)
+	}
+	if health.Status != "available" {
+		return fmt.Errorf("meilisearch is %s", health.Status)
+	}
 	return nil
 }
 